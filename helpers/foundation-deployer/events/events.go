@@ -0,0 +1,168 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events emits a machine-readable stream of the deployment progress.
+// Each event is written as a JSON object in its own line.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DeploymentStarted  = "deployment_started"
+	DeploymentFinished = "deployment_finished"
+	StageStarted       = "stage_started"
+	StageCompleted     = "stage_completed"
+	StageFailed        = "stage_failed"
+	StepStarted        = "step_started"
+	StepCompleted      = "step_completed"
+	StepSkipped        = "step_skipped"
+	StepFailed         = "step_failed"
+	StepInterrupted    = "step_interrupted"
+	StepDestroyed      = "step_destroyed"
+	BuildStarted       = "build_started"
+	BuildFinished      = "build_finished"
+	BuildRetried       = "build_retried"
+)
+
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Mode        string    `json:"mode,omitempty"`
+	Stage       string    `json:"stage,omitempty"`
+	Step        string    `json:"step,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	BuildID     string    `json:"build_id,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Attempt     int       `json:"attempt,omitempty"`
+	MaxAttempts int       `json:"max_attempts,omitempty"`
+	Duration    float64   `json:"duration_seconds,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+var (
+	mu        sync.Mutex
+	out       io.Writer
+	listeners = map[int]func(Event){}
+	nextID    int
+	now       = time.Now
+)
+
+// SetOutput enables the event stream in the given writer.
+// A nil writer disables the event stream.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// Listen registers a function that receives the emitted events, even if the event stream is disabled.
+// The returned function removes the listener.
+func Listen(f func(Event)) func() {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	listeners[id] = f
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(listeners, id)
+	}
+}
+
+// Emit sends the event to the listeners and writes it if the event stream is enabled.
+func Emit(e Event) {
+	mu.Lock()
+	if e.Time.IsZero() {
+		e.Time = now().UTC()
+	}
+	fs := make([]func(Event), 0, len(listeners))
+	for _, f := range listeners {
+		fs = append(fs, f)
+	}
+	mu.Unlock()
+	for _, f := range fs {
+		f(e)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if out == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding event: %s\n", err)
+		return
+	}
+	if _, err := fmt.Fprintln(out, string(b)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing event: %s\n", err)
+	}
+}
+
+// Since returns the seconds elapsed since the given time.
+func Since(start time.Time) float64 {
+	return now().Sub(start).Seconds()
+}
+
+// stepKey is the context key of the step that waits for the builds.
+type stepKey struct{}
+
+// WithStep returns a copy of the context that adds the given step to the build events,
+// so the builds of steps executed in parallel are recorded in their own step.
+func WithStep(ctx context.Context, step string) context.Context {
+	return context.WithValue(ctx, stepKey{}, step)
+}
+
+// stepFrom returns the step of the context, empty if there is none.
+func stepFrom(ctx context.Context) string {
+	step, _ := ctx.Value(stepKey{}).(string)
+	return step
+}
+
+// Build emits a build event of the given type.
+// The event has the step of the context if there is one.
+func Build(ctx context.Context, eventType, buildID, url, status string, start time.Time) {
+	e := Event{
+		Type:    eventType,
+		Step:    stepFrom(ctx),
+		BuildID: buildID,
+		URL:     url,
+		Status:  status,
+	}
+	if !start.IsZero() {
+		e.Duration = Since(start)
+	}
+	Emit(e)
+}
+
+// Retry emits the event of a new build triggered after a build failed with a retryable error.
+func Retry(ctx context.Context, buildID, url string, attempt, maxAttempts int) {
+	Emit(Event{
+		Type:        BuildRetried,
+		Step:        stepFrom(ctx),
+		BuildID:     buildID,
+		URL:         url,
+		Attempt:     attempt,
+		MaxAttempts: maxAttempts,
+	})
+}
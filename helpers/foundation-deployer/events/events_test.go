@@ -0,0 +1,60 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmit(t *testing.T) {
+	clock := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() {
+		now = time.Now
+		SetOutput(nil)
+	})
+
+	// events are discarded while the event stream is disabled
+	Emit(Event{Type: StepStarted, Step: "disabled"})
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	Emit(Event{Type: StepStarted, Step: "gcp-org.plan"})
+	ctx := context.Background()
+	Build(ctx, BuildFinished, "42", "https://example.com/42", "SUCCESS", clock.Add(-90*time.Second))
+	Retry(WithStep(ctx, "gcp-org.production"), "43", "https://example.com/43", 1, 2)
+
+	received := []string{}
+	stop := Listen(func(e Event) { received = append(received, e.BuildID) })
+	Build(ctx, BuildStarted, "44", "https://example.com/44", "", time.Time{})
+	stop()
+	Build(ctx, BuildStarted, "45", "https://example.com/45", "", time.Time{})
+	assert.Equal(t, []string{"44"}, received, "removed listeners should not receive events")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		`{"time":"2025-01-02T03:04:05Z","type":"step_started","step":"gcp-org.plan"}`,
+		`{"time":"2025-01-02T03:04:05Z","type":"build_finished","status":"SUCCESS","build_id":"42","url":"https://example.com/42","duration_seconds":90}`,
+		`{"time":"2025-01-02T03:04:05Z","type":"build_retried","step":"gcp-org.production","build_id":"43","url":"https://example.com/43","attempt":1,"max_attempts":2}`,
+		`{"time":"2025-01-02T03:04:05Z","type":"build_started","build_id":"44","url":"https://example.com/44"}`,
+		`{"time":"2025-01-02T03:04:05Z","type":"build_started","build_id":"45","url":"https://example.com/45"}`,
+	}, lines)
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/mitchellh/go-testing-interface"
 	"github.com/tidwall/gjson"
 
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
 	localutil "github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
 
 	"github.com/terraform-google-modules/terraform-example-foundation/test/integration/testutils"
@@ -133,15 +134,22 @@ func (g GCP) GetBuildStatus(t testing.TB, projectID, region, buildID string) str
 }
 
 // GetRunningBuildID gets the current build running for the given project, region, and filter
-func (g GCP) GetRunningBuildID(t testing.TB, projectID, region, filter string) string {
-	time.Sleep(g.sleepTime * time.Second)
+func (g GCP) GetRunningBuildID(t testing.TB, ctx context.Context, projectID, region, filter string) (string, error) {
+	if err := localutil.Sleep(ctx, g.sleepTime*time.Second); err != nil {
+		return "", err
+	}
 	builds := g.GetBuilds(t, projectID, region, filter)
 	for id, status := range builds {
 		if status == StatusQueued || status == StatusWorking {
-			return id
+			return id, nil
 		}
 	}
-	return ""
+	return "", nil
+}
+
+// BuildURL returns the console URL of the given build
+func (g GCP) BuildURL(projectID, region, buildID string) string {
+	return fmt.Sprintf("https://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s", region, buildID, projectID)
 }
 
 // GetBuildLogs get the execution logs of the given build
@@ -150,30 +158,31 @@ func (g GCP) GetBuildLogs(t testing.TB, projectID, region, buildID string) strin
 }
 
 // GetFinalBuildState gets the terminal status of the given build. It will wait if build is not finished.
-func (g GCP) GetFinalBuildState(t testing.TB, projectID, region, buildID string, maxBuildRetry int) (string, error) {
+func (g GCP) GetFinalBuildState(t testing.TB, ctx context.Context, projectID, region, buildID string, maxBuildRetry int) (string, error) {
 	var status string
 	count := 0
-	fmt.Printf("waiting for build %s execution.\n", buildID)
+	localutil.Printf(ctx, "waiting for build %s execution.\n", buildID)
 	status = g.GetBuildStatus(t, projectID, region, buildID)
-	fmt.Printf("build status is %s\n", status)
+	localutil.Printf(ctx, "build status is %s\n", status)
 	for status != StatusSuccess && status != StatusFailure && status != StatusCancelled {
-		fmt.Printf("build status is %s\n", status)
+		localutil.Printf(ctx, "build status is %s\n", status)
 		if count >= maxBuildRetry {
 			return "", fmt.Errorf("timeout waiting for build '%s' execution", buildID)
 		}
 		count = count + 1
-		time.Sleep(g.sleepTime * time.Second)
+		if err := localutil.Sleep(ctx, g.sleepTime*time.Second); err != nil {
+			return "", err
+		}
 		status = g.GetBuildStatus(t, projectID, region, buildID)
 	}
-	fmt.Printf("final build status is %s\n", status)
+	localutil.Printf(ctx, "final build status is %s\n", status)
 	return status, nil
 }
 
 // WaitBuildSuccess waits for the current build in a repo to finish.
-func (g GCP) WaitBuildSuccess(t testing.TB, project, region, repo, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+func (g GCP) WaitBuildSuccess(t testing.TB, ctx context.Context, project, region, repo, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
 	var filter, status, build string
 	var timeoutErr, err error
-	ctx := context.Background()
 
 	if commitSha == "" {
 		filter = fmt.Sprintf("source.repoSource.repoName:%s", repo)
@@ -181,26 +190,33 @@ func (g GCP) WaitBuildSuccess(t testing.TB, project, region, repo, commitSha, fa
 		filter = fmt.Sprintf("source.repoSource.commitSha:%s", commitSha)
 	}
 
-	build = g.GetRunningBuildID(t, project, region, filter)
+	build, err = g.GetRunningBuildID(t, ctx, project, region, filter)
+	if err != nil {
+		return err
+	}
 	for i := 0; i < maxErrorRetries; i++ {
 		if build != "" {
-			status, timeoutErr = g.GetFinalBuildState(t, project, region, build, maxBuildRetry)
+			start := time.Now()
+			events.Build(ctx, events.BuildStarted, build, g.BuildURL(project, region, build), "", time.Time{})
+			status, timeoutErr = g.GetFinalBuildState(t, ctx, project, region, build, maxBuildRetry)
 			if timeoutErr != nil {
 				return timeoutErr
 			}
+			events.Build(ctx, events.BuildFinished, build, g.BuildURL(project, region, build), status, start)
 		} else {
 			status, build = g.GetLastBuildStatus(t, project, region, filter)
 			if build == "" {
 				return fmt.Errorf("no build found for filter: %s", filter)
 			}
+			events.Build(ctx, events.BuildFinished, build, g.BuildURL(project, region, build), status, time.Time{})
 		}
 
 		if status != StatusSuccess {
 			logs := g.GetBuildLogs(t, project, region, build)
 			if !localutil.IsRetryableError(t, logs) {
-				return fmt.Errorf("%s\nSee:\nhttps://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s\nfor details", failureMsg, region, build, project)
+				return fmt.Errorf("%s\nSee:\n%s\nfor details", failureMsg, g.BuildURL(project, region, build))
 			}
-			fmt.Println("build failed with retryable error. a new build will be triggered.")
+			localutil.Println(ctx, "build failed with retryable error. a new build will be triggered.")
 		} else {
 			return nil // Build succeeded
 		}
@@ -210,9 +226,12 @@ func (g GCP) WaitBuildSuccess(t testing.TB, project, region, repo, commitSha, fa
 		if err != nil {
 			return fmt.Errorf("failed to trigger new build (attempt %d/%d): %w", i+1, maxErrorRetries, err)
 		}
-		fmt.Printf("triggered new build with ID: %s (attempt %d/%d)\n", build, i+1, maxErrorRetries)
+		localutil.Printf(ctx, "triggered new build with ID: %s (attempt %d/%d)\n", build, i+1, maxErrorRetries)
+		events.Retry(ctx, build, g.BuildURL(project, region, build), i+1, maxErrorRetries)
 		if i < maxErrorRetries-1 {
-			time.Sleep(timeBetweenErrorRetries) // Wait before retrying
+			if err := localutil.Sleep(ctx, timeBetweenErrorRetries); err != nil { // Wait before retrying
+				return err
+			}
 		}
 	}
 	return fmt.Errorf("%s\nbuild failed after %d retries.\nSee Cloud Build logs for details", failureMsg, maxErrorRetries)
@@ -106,7 +106,7 @@ func TestGetFinalBuildState(t *gotest.T) {
 		sleepTime: 1,
 	}
 
-	status2, err := gcp.GetFinalBuildState(t, "prj-b-cicd-0123", "us-central1", "buildID", 40)
+	status2, err := gcp.GetFinalBuildState(t, context.Background(), "prj-b-cicd-0123", "us-central1", "buildID", 40)
 	assert.NoError(t, err)
 	assert.Equal(t, StatusFailure, status2)
 	assert.Equal(t, callCount, 2, "Runf must be called twice")
@@ -142,7 +142,7 @@ func TestWaitBuildSuccess(t *gotest.T) {
 		sleepTime: 1,
 	}
 
-	err = gcp.WaitBuildSuccess(t, "prj-b-cicd-0123", "us-central1", "repo", "", "failed_test_for_WaitBuildSuccess", 40, 2, 1*time.Second)
+	err = gcp.WaitBuildSuccess(t, context.Background(), "prj-b-cicd-0123", "us-central1", "repo", "", "failed_test_for_WaitBuildSuccess", 40, 2, 1*time.Second)
 	assert.Error(t, err, "should have failed")
 	assert.Contains(t, err.Error(), "failed_test_for_WaitBuildSuccess", "should have failed with custom info")
 	assert.Equal(t, callCount, 3, "Runf must be called three times")
@@ -177,7 +177,7 @@ func TestWaitBuildTimeout(t *gotest.T) {
 		sleepTime: 1,
 	}
 
-	err = gcp.WaitBuildSuccess(t, "prj-b-cicd-0123", "us-central1", "repo", "", "failed_test_for_WaitBuildSuccess", 1, 1, 1*time.Second)
+	err = gcp.WaitBuildSuccess(t, context.Background(), "prj-b-cicd-0123", "us-central1", "repo", "", "failed_test_for_WaitBuildSuccess", 1, 1, 1*time.Second)
 	assert.Error(t, err, "should have failed")
 	assert.Contains(t, err.Error(), "timeout waiting for build '736f4689-2497-4382-afd0-b5f0f50eea5b' execution", "should have failed with timeout error")
 	assert.Equal(t, callCount, 3, "Runf must be called three times")
@@ -227,7 +227,7 @@ func TestWaitBuildSuccessRetry(t *gotest.T) {
 		sleepTime: 1,
 	}
 
-	err = gcp.WaitBuildSuccess(t, "prj-b-cicd-0123", "us-central1", "repo", "", "", 40, 2, 1*time.Second)
+	err = gcp.WaitBuildSuccess(t, context.Background(), "prj-b-cicd-0123", "us-central1", "repo", "", "", 40, 2, 1*time.Second)
 
 	assert.Nil(t, err, "should have succeeded")
 	assert.Equal(t, runfCallCount, 5, "Runf must be called five times")
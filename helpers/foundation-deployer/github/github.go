@@ -20,11 +20,13 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v58/github"
 	"github.com/mitchellh/go-testing-interface"
 
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
 
 	"golang.org/x/oauth2"
@@ -43,26 +45,58 @@ const (
 	StatusNeutral        = "neutral"
 	StatusSkipped        = "skipped"
 	StatusActionRequired = "action_required"
+
+	DefaultBaseURL = "https://github.com"
 )
 
 type GH struct {
 	TriggerNewBuild func(t testing.TB, ctx context.Context, owner, repo, token, commitSha string, runID int64) (int64, string, string, error)
 	sleepTime       time.Duration
+	baseURL         string
+	apiURL          string
 }
 
-// NewGH creates a new wrapper for The GitHub API
-func NewGH() GH {
-	return GH{
-		TriggerNewBuild: triggerNewBuild,
-		sleepTime:       20,
+// NewGH creates a new wrapper for The GitHub API.
+// baseURL is the URL of the GitHub instance, github.com if empty.
+// apiURL is the URL of the GitHub Enterprise Server API, derived from baseURL if empty.
+func NewGH(baseURL, apiURL string) GH {
+	g := GH{
+		sleepTime: 20,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		apiURL:    apiURL,
+	}
+	if g.baseURL == "" {
+		g.baseURL = DefaultBaseURL
 	}
+	g.TriggerNewBuild = g.triggerNewBuild
+	return g
 }
 
-// triggerNewBuild triggers a new action execution
-func triggerNewBuild(t testing.TB, ctx context.Context, owner, repo, token, commitSha string, runID int64) (int64, string, string, error) {
+// newClient creates a GitHub API client for github.com or for a GitHub Enterprise Server instance
+func (g GH) newClient(ctx context.Context, token string) (*github.Client, error) {
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	tc := oauth2.NewClient(ctx, ts)
 	client := github.NewClient(tc)
+	if g.apiURL != "" {
+		return client.WithEnterpriseURLs(g.apiURL, g.apiURL)
+	}
+	if g.baseURL != DefaultBaseURL {
+		return client.WithEnterpriseURLs(g.baseURL, g.baseURL)
+	}
+	return client, nil
+}
+
+// RunURL returns the URL of an action run
+func (g GH) RunURL(owner, repo string, runID int64) string {
+	return fmt.Sprintf("%s/%s/%s/actions/runs/%d", g.baseURL, owner, repo, runID)
+}
+
+// triggerNewBuild triggers a new action execution
+func (g GH) triggerNewBuild(t testing.TB, ctx context.Context, owner, repo, token, commitSha string, runID int64) (int64, string, string, error) {
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to create client: %v", err)
+	}
 
 	resp, err := client.Actions.RerunWorkflowByID(ctx, owner, repo, runID)
 	if err != nil {
@@ -88,7 +122,9 @@ func triggerNewBuild(t testing.TB, ctx context.Context, owner, repo, token, comm
 	// in the API results after re-running it
 	// needed because RerunWorkflowByID dos no return
 	// the ID of the new workflow
-	time.Sleep(30 * time.Second)
+	if err := utils.Sleep(ctx, 30*time.Second); err != nil {
+		return 0, "", "", err
+	}
 
 	runs, _, err := client.Actions.ListRepositoryWorkflowRuns(ctx, owner, repo, opts)
 
@@ -120,9 +156,10 @@ func triggerNewBuild(t testing.TB, ctx context.Context, owner, repo, token, comm
 
 // GetLastActionState returns the state of the latest action
 func (g GH) GetLastActionState(t testing.TB, ctx context.Context, owner, repo, token, commitSha string) (int64, string, string, error) {
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to create client: %v", err)
+	}
 
 	opts := &github.ListWorkflowRunsOptions{
 		HeadSHA: commitSha,
@@ -160,9 +197,10 @@ func (g GH) GetLastActionState(t testing.TB, ctx context.Context, owner, repo, t
 
 // GetActionState returns the state of a given action
 func (g GH) GetActionState(t testing.TB, ctx context.Context, owner, repo, token string, runID int64) (string, string, error) {
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create client: %v", err)
+	}
 
 	run, _, err := client.Actions.GetWorkflowRunByID(ctx, owner, repo, runID)
 	if err != nil {
@@ -183,9 +221,10 @@ func (g GH) GetActionState(t testing.TB, ctx context.Context, owner, repo, token
 
 // GetBuildLogs returns the execution logs of an action
 func (g GH) GetBuildLogs(t testing.TB, ctx context.Context, owner, repo, token string, runID int64) (string, error) {
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create client: %v", err)
+	}
 
 	listJobsOpts := &github.ListWorkflowJobsOptions{
 		ListOptions: github.ListOptions{PerPage: 10},
@@ -242,50 +281,58 @@ func (g GH) GetFinalActionState(t testing.TB, ctx context.Context, owner, repo,
 	var status, conclusion string
 	var err error
 	count := 0
-	fmt.Printf("waiting for action %d execution.\n", runID)
+	utils.Printf(ctx, "waiting for action %d execution.\n", runID)
 	status, conclusion, err = g.GetActionState(t, ctx, owner, repo, token, runID)
 	if err != nil {
 		return "", "", err
 	}
-	fmt.Printf("action status is %s\n", status)
+	utils.Printf(ctx, "action status is %s\n", status)
 	for status != statusCompleted {
-		fmt.Printf("action status is %s\n", status)
+		utils.Printf(ctx, "action status is %s\n", status)
 		if count >= maxBuildRetry {
 			return "", "", fmt.Errorf("timeout waiting for action '%d' execution", runID)
 		}
 		count = count + 1
-		time.Sleep(g.sleepTime * time.Second)
+		if err := utils.Sleep(ctx, g.sleepTime*time.Second); err != nil {
+			return "", "", err
+		}
 		status, conclusion, err = g.GetActionState(t, ctx, owner, repo, token, runID)
 		if err != nil {
 			return "", "", err
 		}
 	}
-	fmt.Printf("final action state is %s\n", conclusion)
+	utils.Printf(ctx, "final action state is %s\n", conclusion)
 	return status, conclusion, nil
 }
 
 // WaitBuildSuccess waits for the current build in a repo to finish.
-func (g GH) WaitBuildSuccess(t testing.TB, owner, repo, token, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+func (g GH) WaitBuildSuccess(t testing.TB, ctx context.Context, owner, repo, token, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
 	var status, conclusion string
 	var runID int64
 	var err error
 
 	// wait for the new workflow and action to be created and appear in the API results
 	// after the code being pushed to the repository
-	time.Sleep(30 * time.Second)
+	if err := utils.Sleep(ctx, 30*time.Second); err != nil {
+		return err
+	}
 
-	ctx := context.Background()
 	runID, status, conclusion, err = g.GetLastActionState(t, ctx, owner, repo, token, commitSha)
 	if err != nil {
 		return err
 	}
 	for i := 0; i < maxErrorRetries; i++ {
+		runURL := g.RunURL(owner, repo, runID)
+		var start time.Time
 		if status != statusCompleted {
+			start = time.Now()
+			events.Build(ctx, events.BuildStarted, fmt.Sprint(runID), runURL, status, time.Time{})
 			_, conclusion, err = g.GetFinalActionState(t, ctx, owner, repo, token, runID, maxBuildRetry)
 			if err != nil {
 				return err
 			}
 		}
+		events.Build(ctx, events.BuildFinished, fmt.Sprint(runID), runURL, conclusion, start)
 
 		if conclusion != StatusSuccess {
 			logs, err := g.GetBuildLogs(t, ctx, owner, repo, token, runID)
@@ -293,9 +340,9 @@ func (g GH) WaitBuildSuccess(t testing.TB, owner, repo, token, commitSha, failur
 				return err
 			}
 			if !utils.IsRetryableError(t, logs) {
-				return fmt.Errorf("%s\nSee:\nhttps://github.com/%s/%s/actions/runs/%d\nfor details", failureMsg, owner, repo, runID)
+				return fmt.Errorf("%s\nSee:\n%s\nfor details", failureMsg, runURL)
 			}
-			fmt.Println("build failed with retryable error. a new build will be triggered.")
+			utils.Println(ctx, "build failed with retryable error. a new build will be triggered.")
 		} else {
 			return nil // Build succeeded
 		}
@@ -305,10 +352,84 @@ func (g GH) WaitBuildSuccess(t testing.TB, owner, repo, token, commitSha, failur
 		if err != nil {
 			return fmt.Errorf("failed to trigger new action (attempt %d/%d): %w", i+1, maxErrorRetries, err)
 		}
-		fmt.Printf("triggered new action with ID: %d (attempt %d/%d)\n", runID, i+1, maxErrorRetries)
+		utils.Printf(ctx, "triggered new action with ID: %d (attempt %d/%d)\n", runID, i+1, maxErrorRetries)
+		events.Retry(ctx, fmt.Sprint(runID), g.RunURL(owner, repo, runID), i+1, maxErrorRetries)
 		if i < maxErrorRetries-1 {
-			time.Sleep(timeBetweenErrorRetries) // Wait before retrying
+			if err := utils.Sleep(ctx, timeBetweenErrorRetries); err != nil { // Wait before retrying
+				return err
+			}
 		}
 	}
 	return fmt.Errorf("%s action failed after %d retries", failureMsg, maxErrorRetries)
 }
+
+// CreatePullRequest opens a pull request from the head branch to the base branch and returns its number and URL.
+// If a pull request between the branches is already open it is returned instead.
+func (g GH) CreatePullRequest(t testing.TB, ctx context.Context, owner, repo, token, head, base, title string) (int, string, error) {
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create client: %v", err)
+	}
+
+	opts := &github.PullRequestListOptions{
+		State: "open",
+		Head:  fmt.Sprintf("%s:%s", owner, head),
+		Base:  base,
+	}
+	prs, _, err := client.PullRequests.List(ctx, owner, repo, opts)
+	if err != nil {
+		return 0, "", fmt.Errorf("error listing pull requests: %v", err)
+	}
+	if len(prs) > 0 {
+		return prs[0].GetNumber(), prs[0].GetHTMLURL(), nil
+	}
+
+	pr, _, err := client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+		Title: github.String(title),
+		Head:  github.String(head),
+		Base:  github.String(base),
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("error creating pull request from %s to %s: %v", head, base, err)
+	}
+	return pr.GetNumber(), pr.GetHTMLURL(), nil
+}
+
+// WaitPullRequestMerged waits for a pull request to be merged.
+// If autoMerge is true the pull request is merged as soon as the branch protection rules allow it.
+func (g GH) WaitPullRequestMerged(t testing.TB, ctx context.Context, owner, repo, token string, number int, autoMerge bool, maxRetry int) error {
+	client, err := g.newClient(ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+
+	for count := 0; ; count++ {
+		pr, _, err := client.PullRequests.Get(ctx, owner, repo, number)
+		if err != nil {
+			return fmt.Errorf("error getting pull request %d: %v", number, err)
+		}
+		if pr.GetMerged() {
+			utils.Printf(ctx, "pull request %s merged\n", pr.GetHTMLURL())
+			return nil
+		}
+		if pr.GetState() == "closed" {
+			return fmt.Errorf("pull request %s was closed without being merged", pr.GetHTMLURL())
+		}
+		// the mergeable state is "clean" when the required reviews and checks are satisfied
+		if autoMerge && pr.GetMergeableState() == "clean" {
+			_, _, err := client.PullRequests.Merge(ctx, owner, repo, number, "", &github.PullRequestOptions{MergeMethod: "merge"})
+			if err == nil {
+				utils.Printf(ctx, "pull request %s merged\n", pr.GetHTMLURL())
+				return nil
+			}
+			utils.Printf(ctx, "failed to merge pull request %s: %v\n", pr.GetHTMLURL(), err)
+		}
+		if count >= maxRetry {
+			return fmt.Errorf("timeout waiting for pull request %s to be merged", pr.GetHTMLURL())
+		}
+		utils.Printf(ctx, "waiting for pull request %s to be merged. Mergeable state is %s\n", pr.GetHTMLURL(), pr.GetMergeableState())
+		if err := utils.Sleep(ctx, g.sleepTime*time.Second); err != nil {
+			return err
+		}
+	}
+}
@@ -19,9 +19,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/mitchellh/go-testing-interface"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
 
 	gitlab "gitlab.com/gitlab-org/api/client-go"
@@ -40,25 +42,54 @@ const (
 	StatusFailed             = "failed"
 	StatusCancelled          = "canceled"
 	StatusCanceling          = "canceling"
+
+	DefaultBaseURL = "https://gitlab.com"
 )
 
 type GL struct {
 	TriggerNewBuild func(t testing.TB, ctx context.Context, owner, project, token string, jobID int) (int, string, error)
 	sleepTime       time.Duration
+	baseURL         string
+	apiURL          string
+}
+
+// NewGL creates a new GitLab wrapper for The GitLab API.
+// baseURL is the URL of the GitLab instance, gitlab.com if empty.
+// apiURL is the URL of the GitLab API, derived from baseURL if empty.
+func NewGL(baseURL, apiURL string) GL {
+	g := GL{
+		sleepTime: 20,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		apiURL:    apiURL,
+	}
+	if g.baseURL == "" {
+		g.baseURL = DefaultBaseURL
+	}
+	g.TriggerNewBuild = g.triggerNewBuild
+	return g
 }
 
-// NewGL creates a new GitLab wrapper for The GitLab API
-func NewGL() GL {
-	return GL{
-		TriggerNewBuild: triggerNewBuild,
-		sleepTime:       20,
+// newClient creates a GitLab API client for gitlab.com or for a self-managed GitLab instance
+func (g GL) newClient(token string) (*gitlab.Client, error) {
+	apiURL := g.apiURL
+	if apiURL == "" && g.baseURL != DefaultBaseURL {
+		apiURL = g.baseURL
+	}
+	if apiURL == "" {
+		return gitlab.NewClient(token)
 	}
+	return gitlab.NewClient(token, gitlab.WithBaseURL(apiURL))
+}
+
+// JobURL returns the URL of a job
+func (g GL) JobURL(owner, project string, jobID int) string {
+	return fmt.Sprintf("%s/%s/%s/-/jobs/%d", g.baseURL, owner, project, jobID)
 }
 
 // triggerNewBuild triggers a new job execution
-func triggerNewBuild(t testing.TB, ctx context.Context, owner, project, token string, jobID int) (int, string, error) {
+func (g GL) triggerNewBuild(t testing.TB, ctx context.Context, owner, project, token string, jobID int) (int, string, error) {
 
-	git, err := gitlab.NewClient(token)
+	git, err := g.newClient(token)
 	if err != nil {
 		return 0, "", fmt.Errorf("failed to create client: %v", err)
 	}
@@ -79,7 +110,7 @@ func triggerNewBuild(t testing.TB, ctx context.Context, owner, project, token st
 
 // GetLastJobStatusForSHA finds the latest job associated with a specific commit SHA
 func (g GL) GetLastJobStatusForSHA(t testing.TB, ctx context.Context, owner, project, token, sha string) (string, int, error) {
-	git, err := gitlab.NewClient(token)
+	git, err := g.newClient(token)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to create client: %v", err)
 	}
@@ -131,7 +162,7 @@ func (g GL) GetLastJobStatusForSHA(t testing.TB, ctx context.Context, owner, pro
 
 // GetJobLogs returns the execution logs of a given job
 func (g GL) GetJobLogs(t testing.TB, ctx context.Context, owner, project, token string, jobID int) (string, error) {
-	git, err := gitlab.NewClient(token)
+	git, err := g.newClient(token)
 	if err != nil {
 		return "", fmt.Errorf("failed to create client: %v", err)
 	}
@@ -155,7 +186,7 @@ func (g GL) GetJobLogs(t testing.TB, ctx context.Context, owner, project, token
 
 // GetJobStatus returns the status of a given gob
 func (g GL) GetJobStatus(t testing.TB, ctx context.Context, owner, project, token string, jobID int) (string, error) {
-	git, err := gitlab.NewClient(token)
+	git, err := g.newClient(token)
 	if err != nil {
 		return "", fmt.Errorf("failed to create client: %v", err)
 	}
@@ -173,51 +204,59 @@ func (g GL) GetFinalJobStatus(t testing.TB, ctx context.Context, owner, project,
 	var status string
 	var err error
 	count := 0
-	fmt.Printf("waiting for job %d execution.\n", jobID)
+	utils.Printf(ctx, "waiting for job %d execution.\n", jobID)
 
 	status, err = g.GetJobStatus(t, ctx, owner, project, token, jobID)
 	if err != nil {
 		return "", err
 	}
-	fmt.Printf("job status is %s\n", status)
+	utils.Printf(ctx, "job status is %s\n", status)
 	for status != StatusSuccess && status != StatusFailed && status != StatusCancelled {
-		fmt.Printf("job status is %s\n", status)
+		utils.Printf(ctx, "job status is %s\n", status)
 		if count >= maxBuildRetry {
 			return "", fmt.Errorf("timeout waiting for job '%d' execution", jobID)
 		}
 		count = count + 1
-		time.Sleep(g.sleepTime * time.Second)
+		if err := utils.Sleep(ctx, g.sleepTime*time.Second); err != nil {
+			return "", err
+		}
 		status, err = g.GetJobStatus(t, ctx, owner, project, token, jobID)
 		if err != nil {
 			return "", err
 		}
 	}
-	fmt.Printf("final job state is %s\n", status)
+	utils.Printf(ctx, "final job state is %s\n", status)
 	return status, nil
 }
 
 // WaitBuildSuccess waits for the current job in a project to finish.
-func (g GL) WaitBuildSuccess(t testing.TB, owner, project, token, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+func (g GL) WaitBuildSuccess(t testing.TB, ctx context.Context, owner, project, token, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
 	var status string
 	var jobID int
 	var err error
 
 	// wait for the new job to be created and appear in the API results
 	// after the code being pushed to the project
-	time.Sleep(30 * time.Second)
+	if err := utils.Sleep(ctx, 30*time.Second); err != nil {
+		return err
+	}
 
-	ctx := context.Background()
 	status, jobID, err = g.GetLastJobStatusForSHA(t, ctx, owner, project, token, commitSha)
 	if err != nil {
 		return err
 	}
 	for i := 0; i < maxErrorRetries; i++ {
+		jobURL := g.JobURL(owner, project, jobID)
+		var start time.Time
 		if status != StatusSuccess && status != StatusFailed && status != StatusCancelled {
+			start = time.Now()
+			events.Build(ctx, events.BuildStarted, fmt.Sprint(jobID), jobURL, status, time.Time{})
 			status, err = g.GetFinalJobStatus(t, ctx, owner, project, token, jobID, maxBuildRetry)
 			if err != nil {
 				return err
 			}
 		}
+		events.Build(ctx, events.BuildFinished, fmt.Sprint(jobID), jobURL, status, start)
 
 		if status != StatusSuccess {
 			logs, err := g.GetJobLogs(t, ctx, owner, project, token, jobID)
@@ -225,9 +264,9 @@ func (g GL) WaitBuildSuccess(t testing.TB, owner, project, token, commitSha, fai
 				return err
 			}
 			if !utils.IsRetryableError(t, logs) {
-				return fmt.Errorf("%s\nSee:\nhttps://gitlab.com/%s/%s/-/jobs/%d\nfor details", failureMsg, owner, project, jobID)
+				return fmt.Errorf("%s\nSee:\n%s\nfor details", failureMsg, jobURL)
 			}
-			fmt.Println("job failed with retryable error. a new job will be triggered.")
+			utils.Println(ctx, "job failed with retryable error. a new job will be triggered.")
 		} else {
 			return nil // job succeeded
 		}
@@ -237,9 +276,12 @@ func (g GL) WaitBuildSuccess(t testing.TB, owner, project, token, commitSha, fai
 		if err != nil {
 			return fmt.Errorf("failed to trigger new job (attempt %d/%d): %w", i+1, maxErrorRetries, err)
 		}
-		fmt.Printf("triggered new job with ID: %d (attempt %d/%d)\n", jobID, i+1, maxErrorRetries)
+		utils.Printf(ctx, "triggered new job with ID: %d (attempt %d/%d)\n", jobID, i+1, maxErrorRetries)
+		events.Retry(ctx, fmt.Sprint(jobID), g.JobURL(owner, project, jobID), i+1, maxErrorRetries)
 		if i < maxErrorRetries-1 {
-			time.Sleep(timeBetweenErrorRetries) // Wait before retrying
+			if err := utils.Sleep(ctx, timeBetweenErrorRetries); err != nil { // Wait before retrying
+				return err
+			}
 		}
 	}
 	return fmt.Errorf("%s job failed after %d retries", failureMsg, maxErrorRetries)
@@ -248,7 +290,7 @@ func (g GL) WaitBuildSuccess(t testing.TB, owner, project, token, commitSha, fai
 // AddProjectsToJobTokenScope adds a list of projects to the token scope of a project that host the runner image
 func (g GL) AddProjectsToJobTokenScope(t testing.TB, owner, cicdProject, token string, repos []string) error {
 	ctx := context.Background()
-	git, err := gitlab.NewClient(token)
+	git, err := g.newClient(token)
 	if err != nil {
 		return fmt.Errorf("failed to create client: %v", err)
 	}
@@ -299,3 +341,75 @@ func getProjectIDByPath(git *gitlab.Client, ctx context.Context, projectPath str
 	}
 	return project.ID, nil
 }
+
+// CreateMergeRequest opens a merge request from the source branch to the target branch and returns its IID and URL.
+// If a merge request between the branches is already open it is returned instead.
+func (g GL) CreateMergeRequest(t testing.TB, ctx context.Context, owner, project, token, source, target, title string) (int, string, error) {
+	git, err := g.newClient(token)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create client: %v", err)
+	}
+
+	projectPath := fmt.Sprintf("%s/%s", owner, project)
+	opts := &gitlab.ListProjectMergeRequestsOptions{
+		State:        gitlab.Ptr("opened"),
+		SourceBranch: gitlab.Ptr(source),
+		TargetBranch: gitlab.Ptr(target),
+	}
+	mrs, _, err := git.MergeRequests.ListProjectMergeRequests(projectPath, opts, gitlab.WithContext(ctx))
+	if err != nil {
+		return 0, "", fmt.Errorf("error listing merge requests: %w", err)
+	}
+	if len(mrs) > 0 {
+		return mrs[0].IID, mrs[0].WebURL, nil
+	}
+
+	mr, _, err := git.MergeRequests.CreateMergeRequest(projectPath, &gitlab.CreateMergeRequestOptions{
+		Title:        gitlab.Ptr(title),
+		SourceBranch: gitlab.Ptr(source),
+		TargetBranch: gitlab.Ptr(target),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return 0, "", fmt.Errorf("error creating merge request from %s to %s: %w", source, target, err)
+	}
+	return mr.IID, mr.WebURL, nil
+}
+
+// WaitMergeRequestMerged waits for a merge request to be merged.
+// If autoMerge is true the merge request is merged as soon as the approval rules allow it.
+func (g GL) WaitMergeRequestMerged(t testing.TB, ctx context.Context, owner, project, token string, iid int, autoMerge bool, maxRetry int) error {
+	git, err := g.newClient(token)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+
+	projectPath := fmt.Sprintf("%s/%s", owner, project)
+	for count := 0; ; count++ {
+		mr, _, err := git.MergeRequests.GetMergeRequest(projectPath, iid, nil, gitlab.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("error getting merge request %d: %w", iid, err)
+		}
+		switch mr.State {
+		case "merged":
+			utils.Printf(ctx, "merge request %s merged\n", mr.WebURL)
+			return nil
+		case "closed":
+			return fmt.Errorf("merge request %s was closed without being merged", mr.WebURL)
+		}
+		if autoMerge && mr.DetailedMergeStatus == "mergeable" {
+			_, _, err := git.MergeRequests.AcceptMergeRequest(projectPath, iid, &gitlab.AcceptMergeRequestOptions{}, gitlab.WithContext(ctx))
+			if err == nil {
+				utils.Printf(ctx, "merge request %s merged\n", mr.WebURL)
+				return nil
+			}
+			utils.Printf(ctx, "failed to merge merge request %s: %v\n", mr.WebURL, err)
+		}
+		if count >= maxRetry {
+			return fmt.Errorf("timeout waiting for merge request %s to be merged", mr.WebURL)
+		}
+		utils.Printf(ctx, "waiting for merge request %s to be merged. Merge status is %s\n", mr.WebURL, mr.DetailedMergeStatus)
+		if err := utils.Sleep(ctx, g.sleepTime*time.Second); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jenkins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+const (
+	StatusSuccess  = "SUCCESS"
+	StatusFailure  = "FAILURE"
+	StatusAborted  = "ABORTED"
+	StatusUnstable = "UNSTABLE"
+	StatusNotBuilt = "NOT_BUILT"
+	// StatusRunning is used for builds that are still running and do not have a result yet
+	StatusRunning = "RUNNING"
+
+	buildTree = "number,url,building,result,timestamp,actions[lastBuiltRevision[SHA1]]"
+)
+
+// errNoBuild is returned when the branch does not have a build of the commit yet
+var errNoBuild = errors.New("no builds found")
+
+type Revision struct {
+	SHA1 string `json:"SHA1"`
+}
+
+type Action struct {
+	LastBuiltRevision *Revision `json:"lastBuiltRevision"`
+}
+
+type Build struct {
+	Number    int      `json:"number"`
+	URL       string   `json:"url"`
+	Building  bool     `json:"building"`
+	Result    string   `json:"result"`
+	Timestamp int64    `json:"timestamp"`
+	Actions   []Action `json:"actions"`
+}
+
+// Status returns the result of the build or StatusRunning if the build is not finished
+func (b Build) Status() string {
+	if b.Building || b.Result == "" {
+		return StatusRunning
+	}
+	return b.Result
+}
+
+// CommitSha returns the commit SHA built by the build
+func (b Build) CommitSha() string {
+	for _, a := range b.Actions {
+		if a.LastBuiltRevision != nil {
+			return a.LastBuiltRevision.SHA1
+		}
+	}
+	return ""
+}
+
+type Job struct {
+	Name   string  `json:"name"`
+	URL    string  `json:"url"`
+	Builds []Build `json:"builds"`
+}
+
+type QueueItem struct {
+	Cancelled  bool `json:"cancelled"`
+	Executable *struct {
+		Number int    `json:"number"`
+		URL    string `json:"url"`
+	} `json:"executable"`
+}
+
+type JK struct {
+	url         string
+	user        string
+	token       string
+	client      *http.Client
+	sleepTime   time.Duration
+	initialWait time.Duration
+}
+
+// NewJK creates a new Jenkins wrapper for the Jenkins REST API of the given controller
+func NewJK(url, user, token string) JK {
+	return JK{
+		url:         strings.TrimSuffix(url, "/"),
+		user:        user,
+		token:       token,
+		client:      &http.Client{Timeout: 60 * time.Second},
+		sleepTime:   20,
+		initialWait: 30,
+	}
+}
+
+// buildNumber returns the number of the build from its URL
+func buildNumber(buildURL string) string {
+	return path.Base(strings.TrimSuffix(buildURL, "/"))
+}
+
+// JobURL returns the URL of a job given its full name. Example: "foundation/gcp-org"
+func (j JK) JobURL(job string) string {
+	return JobURL(j.url, job)
+}
+
+// JobURL returns the URL of a job in the given Jenkins Controller given its full name.
+func JobURL(jenkinsURL, job string) string {
+	return fmt.Sprintf("%s/job/%s/", strings.TrimSuffix(jenkinsURL, "/"), strings.Join(strings.Split(strings.Trim(job, "/"), "/"), "/job/"))
+}
+
+// do executes a request in the Jenkins API and returns the response if the status is successful
+func (j JK) do(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(j.user, j.token)
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %w", url, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error Status: %d, failed to read response body: %v", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("error Status: %d\n body: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+// getJSON reads the response of a GET request in the Jenkins API into the provided value
+func (j JK) getJSON(ctx context.Context, url string, val interface{}) error {
+	resp, err := j.do(ctx, http.MethodGet, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(val)
+}
+
+// GetLastBuildForSHA finds the latest build of a branch of a multibranch pipeline job for a specific commit SHA.
+// The environment branches are created from the plan branch, so other branches can have builds of the same commit.
+func (j JK) GetLastBuildForSHA(t testing.TB, ctx context.Context, job, branch, sha string) (Build, error) {
+	var data struct {
+		Jobs []Job `json:"jobs"`
+	}
+	url := fmt.Sprintf("%sapi/json?tree=jobs[name,url,builds[%s]{0,20}]", j.JobURL(job), buildTree)
+	err := j.getJSON(ctx, url, &data)
+	if err != nil {
+		return Build{}, fmt.Errorf("error listing builds of job '%s': %w", job, err)
+	}
+	var last Build
+	for _, branchJob := range data.Jobs {
+		if branchJob.Name != branch {
+			continue
+		}
+		for _, b := range branchJob.Builds {
+			if b.CommitSha() == sha && b.Timestamp >= last.Timestamp {
+				last = b
+			}
+		}
+	}
+	if last.URL == "" {
+		return Build{}, fmt.Errorf("%w for branch '%s' of job '%s' at SHA '%s'", errNoBuild, branch, job, sha)
+	}
+	return last, nil
+}
+
+// GetBuild returns the given build
+func (j JK) GetBuild(t testing.TB, ctx context.Context, buildURL string) (Build, error) {
+	var b Build
+	err := j.getJSON(ctx, fmt.Sprintf("%sapi/json?tree=%s", buildURL, buildTree), &b)
+	if err != nil {
+		return Build{}, fmt.Errorf("error getting build %s: %w", buildURL, err)
+	}
+	return b, nil
+}
+
+// GetBuildLogs returns the console logs of the given build
+func (j JK) GetBuildLogs(t testing.TB, ctx context.Context, buildURL string) (string, error) {
+	resp, err := j.do(ctx, http.MethodGet, fmt.Sprintf("%sconsoleText", buildURL))
+	if err != nil {
+		return "", fmt.Errorf("error getting build %s console logs: %w", buildURL, err)
+	}
+	defer resp.Body.Close()
+	logs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read build %s console logs: %v", buildURL, err)
+	}
+	return string(logs), nil
+}
+
+// GetFinalBuildStatus returns the final status of a running build
+func (j JK) GetFinalBuildStatus(t testing.TB, ctx context.Context, buildURL string, maxBuildRetry int) (string, error) {
+	count := 0
+	utils.Printf(ctx, "waiting for build %s execution.\n", buildURL)
+	b, err := j.GetBuild(t, ctx, buildURL)
+	if err != nil {
+		return "", err
+	}
+	status := b.Status()
+	for status == StatusRunning {
+		utils.Printf(ctx, "build status is %s\n", status)
+		if count >= maxBuildRetry {
+			return "", fmt.Errorf("timeout waiting for build '%s' execution", buildURL)
+		}
+		count = count + 1
+		if err := utils.Sleep(ctx, j.sleepTime*time.Second); err != nil {
+			return "", err
+		}
+		b, err = j.GetBuild(t, ctx, buildURL)
+		if err != nil {
+			return "", err
+		}
+		status = b.Status()
+	}
+	utils.Printf(ctx, "final build status is %s\n", status)
+	return status, nil
+}
+
+// TriggerNewBuild triggers a new build of the branch job of the given build and returns the URL of the new build
+func (j JK) TriggerNewBuild(t testing.TB, ctx context.Context, buildURL string, maxBuildRetry int) (string, error) {
+	branchURL := buildURL[:strings.LastIndex(strings.TrimSuffix(buildURL, "/"), "/")+1]
+	resp, err := j.do(ctx, http.MethodPost, fmt.Sprintf("%sbuild", branchURL))
+	if err != nil {
+		return "", fmt.Errorf("error triggering build: %w", err)
+	}
+	resp.Body.Close()
+	queueURL := resp.Header.Get("Location")
+	if queueURL == "" {
+		return "", fmt.Errorf("no queue item returned when triggering a build of %s", branchURL)
+	}
+	queueURL = strings.TrimSuffix(queueURL, "/") + "/"
+
+	// wait for the queued build to start
+	for i := 0; i <= maxBuildRetry; i++ {
+		var item QueueItem
+		err = j.getJSON(ctx, fmt.Sprintf("%sapi/json", queueURL), &item)
+		if err != nil {
+			return "", fmt.Errorf("error getting queue item %s: %w", queueURL, err)
+		}
+		if item.Cancelled {
+			return "", fmt.Errorf("queued build of %s was cancelled", branchURL)
+		}
+		if item.Executable != nil && item.Executable.URL != "" {
+			return item.Executable.URL, nil
+		}
+		if err := utils.Sleep(ctx, j.sleepTime*time.Second); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("timeout waiting for queued build of %s to start", branchURL)
+}
+
+// WaitBuildSuccess waits for the build of a commit in a branch of a multibranch pipeline job to finish.
+func (j JK) WaitBuildSuccess(t testing.TB, ctx context.Context, job, branch, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+	// wait for the new build to be created and appear in the API results
+	// after the code being pushed to the repository
+	if err := utils.Sleep(ctx, j.initialWait*time.Second); err != nil {
+		return err
+	}
+
+	b, err := j.GetLastBuildForSHA(t, ctx, job, branch, commitSha)
+	for i := 0; errors.Is(err, errNoBuild) && i < maxBuildRetry; i++ {
+		utils.Printf(ctx, "waiting for the build of branch %s at SHA %s to be created.\n", branch, commitSha)
+		if err := utils.Sleep(ctx, j.sleepTime*time.Second); err != nil {
+			return err
+		}
+		b, err = j.GetLastBuildForSHA(t, ctx, job, branch, commitSha)
+	}
+	if err != nil {
+		return err
+	}
+	buildURL := b.URL
+	status := b.Status()
+	for i := 0; i < maxErrorRetries; i++ {
+		var start time.Time
+		if status == StatusRunning {
+			start = time.Now()
+			events.Build(ctx, events.BuildStarted, buildNumber(buildURL), buildURL, status, time.Time{})
+			status, err = j.GetFinalBuildStatus(t, ctx, buildURL, maxBuildRetry)
+			if err != nil {
+				return err
+			}
+		}
+		events.Build(ctx, events.BuildFinished, buildNumber(buildURL), buildURL, status, start)
+
+		if status != StatusSuccess {
+			logs, err := j.GetBuildLogs(t, ctx, buildURL)
+			if err != nil {
+				return err
+			}
+			if !utils.IsRetryableError(t, logs) {
+				return fmt.Errorf("%s\nSee:\n%sconsole\nfor details", failureMsg, buildURL)
+			}
+			utils.Println(ctx, "build failed with retryable error. a new build will be triggered.")
+		} else {
+			return nil // build succeeded
+		}
+
+		// Trigger a new build
+		buildURL, err = j.TriggerNewBuild(t, ctx, buildURL, maxBuildRetry)
+		if err != nil {
+			return fmt.Errorf("failed to trigger new build (attempt %d/%d): %w", i+1, maxErrorRetries, err)
+		}
+		status = StatusRunning
+		utils.Printf(ctx, "triggered new build %s (attempt %d/%d)\n", buildURL, i+1, maxErrorRetries)
+		events.Retry(ctx, buildNumber(buildURL), buildURL, i+1, maxErrorRetries)
+		if i < maxErrorRetries-1 {
+			if err := utils.Sleep(ctx, timeBetweenErrorRetries); err != nil { // Wait before retrying
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("%s build failed after %d retries", failureMsg, maxErrorRetries)
+}
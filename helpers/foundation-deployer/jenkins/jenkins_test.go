@@ -0,0 +1,217 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jenkins
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	gotest "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSha = "a1b2c3d4"
+)
+
+// jenkinsStub is a minimal Jenkins controller with a multibranch pipeline "foundation/gcp-org".
+// The production branch has a successful build of the same commit as the plan branch.
+type jenkinsStub struct {
+	server     *httptest.Server
+	builds     map[int][]string // sequence of results returned for each build of the plan branch
+	calls      map[int]int
+	hidden     int // number of listings of the job before the plan build appears
+	listings   int
+	logs       string
+	triggered  int
+	authFailed bool
+}
+
+func newJenkinsStub(t *gotest.T, logs string, builds map[int][]string) *jenkinsStub {
+	s := &jenkinsStub{
+		builds: builds,
+		calls:  map[int]int{},
+		logs:   logs,
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *jenkinsStub) buildJSON(n int) string {
+	results := s.builds[n]
+	result := results[min(s.calls[n], len(results)-1)]
+	s.calls[n]++
+	building := result == ""
+	r := "null"
+	if !building {
+		r = fmt.Sprintf("%q", result)
+	}
+	return fmt.Sprintf(`{"number":%d,"url":"%s/job/foundation/job/gcp-org/job/plan/%d/","building":%t,"result":%s,"timestamp":%d,"actions":[{},{"lastBuiltRevision":{"SHA1":"%s"}}]}`,
+		n, s.server.URL, n, building, r, 1000+n, testSha)
+}
+
+func (s *jenkinsStub) handle(w http.ResponseWriter, r *http.Request) {
+	user, token, ok := r.BasicAuth()
+	if !ok || user != "admin" || token != "api-token" {
+		s.authFailed = true
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	prefix := "/job/foundation/job/gcp-org/"
+	switch {
+	case r.URL.Path == prefix+"api/json":
+		s.listings++
+		planBuilds := ""
+		if s.listings > s.hidden {
+			planBuilds = s.buildJSON(1)
+		}
+		fmt.Fprintf(w, `{"jobs":[{"name":"production","url":"%s%sjob/production/","builds":[{"number":1,"url":"%s%sjob/production/1/","building":false,"result":"SUCCESS","timestamp":5000,"actions":[{"lastBuiltRevision":{"SHA1":"%s"}}]}]},{"name":"plan","url":"%s%sjob/plan/","builds":[%s]}]}`,
+			s.server.URL, prefix, s.server.URL, prefix, testSha, s.server.URL, prefix, planBuilds)
+	case r.URL.Path == prefix+"job/plan/build" && r.Method == http.MethodPost:
+		s.triggered++
+		w.Header().Set("Location", fmt.Sprintf("%s/queue/item/7/", s.server.URL))
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == "/queue/item/7/api/json":
+		fmt.Fprintf(w, `{"cancelled":false,"executable":{"number":2,"url":"%s%sjob/plan/2/"}}`, s.server.URL, prefix)
+	case strings.HasSuffix(r.URL.Path, "/consoleText"):
+		fmt.Fprint(w, s.logs)
+	case r.URL.Path == prefix+"job/plan/1/api/json":
+		fmt.Fprint(w, s.buildJSON(1))
+	case r.URL.Path == prefix+"job/plan/2/api/json":
+		fmt.Fprint(w, s.buildJSON(2))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testJK(url string) JK {
+	jk := NewJK(url, "admin", "api-token")
+	jk.sleepTime = 0
+	jk.initialWait = 0
+	return jk
+}
+
+func TestJobURL(t *gotest.T) {
+	jk := NewJK("https://jenkins.example.com/", "admin", "api-token")
+	assert.Equal(t, "https://jenkins.example.com/job/gcp-org/", jk.JobURL("gcp-org"))
+	assert.Equal(t, "https://jenkins.example.com/job/foundation/job/gcp-org/", jk.JobURL("foundation/gcp-org"))
+	assert.Equal(t, "https://jenkins.example.com/job/foundation/job/gcp-org/", JobURL("https://jenkins.example.com", "/foundation/gcp-org/"))
+}
+
+func TestGetLastBuildForSHA(t *gotest.T) {
+	stub := newJenkinsStub(t, "", map[int][]string{1: {"SUCCESS"}})
+	jk := testJK(stub.server.URL)
+
+	b, err := jk.GetLastBuildForSHA(t, context.Background(), "foundation/gcp-org", "plan", testSha)
+	assert.NoError(t, err)
+	assert.Equal(t, stub.server.URL+"/job/foundation/job/gcp-org/job/plan/1/", b.URL, "builds of other branches at the same SHA should be ignored")
+	assert.Equal(t, StatusSuccess, b.Status())
+
+	b, err = jk.GetLastBuildForSHA(t, context.Background(), "foundation/gcp-org", "production", testSha)
+	assert.NoError(t, err)
+	assert.Equal(t, stub.server.URL+"/job/foundation/job/gcp-org/job/production/1/", b.URL)
+
+	_, err = jk.GetLastBuildForSHA(t, context.Background(), "foundation/gcp-org", "plan", "missing")
+	assert.ErrorContains(t, err, "no builds found for branch 'plan' of job 'foundation/gcp-org' at SHA 'missing'")
+	_, err = jk.GetLastBuildForSHA(t, context.Background(), "foundation/gcp-org", "development", testSha)
+	assert.ErrorIs(t, err, errNoBuild)
+
+	unauthorized := NewJK(stub.server.URL, "admin", "wrong")
+	_, err = unauthorized.GetLastBuildForSHA(t, context.Background(), "foundation/gcp-org", "plan", testSha)
+	assert.ErrorContains(t, err, "error Status: 401")
+}
+
+func TestWaitBuildSuccess(t *gotest.T) {
+	tests := []struct {
+		name      string
+		branch    string
+		hidden    int
+		logs      string
+		builds    map[int][]string
+		err       []string
+		triggered int
+		polls     int
+	}{
+		{
+			name:   "running build",
+			branch: "plan",
+			builds: map[int][]string{1: {"", "", "SUCCESS"}},
+			polls:  3,
+		},
+		{
+			name:   "build created after the push",
+			branch: "plan",
+			hidden: 2,
+			builds: map[int][]string{1: {"", "SUCCESS"}},
+			polls:  2,
+		},
+		{
+			name:   "build of the same commit in another branch",
+			branch: "development",
+			builds: map[int][]string{1: {"SUCCESS"}},
+			err:    []string{"no builds found for branch 'development' of job 'foundation/gcp-org' at SHA 'a1b2c3d4'"},
+		},
+		{
+			name:   "failure links the build console",
+			branch: "plan",
+			logs:   "Error: Invalid value for input variable",
+			builds: map[int][]string{1: {"FAILURE"}},
+			err:    []string{"failed_test_for_WaitBuildSuccess", "/job/foundation/job/gcp-org/job/plan/1/console"},
+		},
+		{
+			name:      "retry queues a new build",
+			branch:    "plan",
+			logs:      "a\nError 403. Compute Engine API has not been used in project\nz",
+			builds:    map[int][]string{1: {"FAILURE"}, 2: {"", "SUCCESS"}},
+			triggered: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *gotest.T) {
+			stub := newJenkinsStub(t, tt.logs, tt.builds)
+			stub.hidden = tt.hidden
+			jk := testJK(stub.server.URL)
+
+			err := jk.WaitBuildSuccess(t, context.Background(), "foundation/gcp-org", tt.branch, testSha, "failed_test_for_WaitBuildSuccess", 40, 2, time.Millisecond)
+			if len(tt.err) == 0 {
+				assert.NoError(t, err)
+			}
+			for _, e := range tt.err {
+				assert.ErrorContains(t, err, e)
+			}
+			assert.Equal(t, tt.triggered, stub.triggered)
+			if tt.polls > 0 {
+				assert.Equal(t, tt.polls, stub.calls[1], "the build of the branch should be polled until it finishes")
+			}
+			assert.False(t, stub.authFailed)
+		})
+	}
+}
+
+func TestWaitBuildCancelled(t *gotest.T) {
+	stub := newJenkinsStub(t, "", map[int][]string{1: {""}})
+	jk := testJK(stub.server.URL)
+	jk.initialWait = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := jk.WaitBuildSuccess(t, ctx, "foundation/gcp-org", "plan", testSha, "failed_test_for_WaitBuildSuccess", 40, 2, time.Millisecond)
+	assert.ErrorIs(t, err, context.Canceled, "the wait must be aborted when the context is cancelled")
+}
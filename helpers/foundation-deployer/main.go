@@ -15,18 +15,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	gotest "testing"
 	"time"
 
 	"github.com/mitchellh/go-testing-interface"
 
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/gcp"
-	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/msg"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/stages"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
@@ -41,13 +46,28 @@ var (
 type cfg struct {
 	tfvarsFile    string
 	stepsFile     string
+	stepsBackend  string
 	resetStep     string
+	recursive     bool
+	resetFrom     string
 	quiet         bool
 	help          bool
 	listSteps     bool
 	disablePrompt bool
 	validate      bool
+	preflight     bool
 	destroy       bool
+	planOnly      bool
+	drift         bool
+	upgrade       bool
+	promotion     string
+	autoMerge     bool
+	parallelEnvs  int
+	stages        string
+	fromStage     string
+	toStage       string
+	output        string
+	format        string
 }
 
 func parseFlags() cfg {
@@ -55,18 +75,139 @@ func parseFlags() cfg {
 
 	flag.StringVar(&c.tfvarsFile, "tfvars_file", "", "Full path to the Terraform .tfvars `file` with the configuration to be used.")
 	flag.StringVar(&c.stepsFile, "steps_file", ".steps.json", "Path to the steps `file` to be used to save progress.")
+	flag.StringVar(&c.stepsBackend, "steps_backend", "", "Steps state `location`. Use gs://bucket/path to save progress in a Google Cloud Storage object instead of the -steps_file.")
 	flag.StringVar(&c.resetStep, "reset_step", "", "Name of a `step` to be reset. The step will be marked as pending.")
+	flag.BoolVar(&c.recursive, "recursive", false, "If true, the nested steps of the -reset_step step are also reset.")
+	flag.StringVar(&c.resetFrom, "reset_from", "", "Name of a `stage` to be reset. The steps of the stage and of the stages that depend on it, including their nested steps, will be marked as pending.")
 	flag.BoolVar(&c.quiet, "quiet", false, "If true, additional output is suppressed.")
 	flag.BoolVar(&c.help, "help", false, "Prints this help text and exits.")
-	flag.BoolVar(&c.listSteps, "list_steps", false, "List the existing steps.")
+	flag.BoolVar(&c.listSteps, "list_steps", false, "List the existing steps as a tree with their status, duration, builds, errors, and previous attempts.")
 	flag.BoolVar(&c.disablePrompt, "disable_prompt", false, "Disable interactive prompt.")
-	flag.BoolVar(&c.validate, "validate", false, "Validate tfvars file inputs.")
+	flag.BoolVar(&c.validate, "validate", false, "Validate tfvars file inputs. Exits with a non-zero code if an error is found.")
+	flag.BoolVar(&c.preflight, "preflight", false, "Check the versions of the required tools, the credentials, the quota project, and the IAM roles of the user, and exit.")
 	flag.BoolVar(&c.destroy, "destroy", false, "Destroy the deployment.")
+	flag.BoolVar(&c.planOnly, "plan_only", false, "Run terraform plan for all the stages without applying changes or saving progress.")
+	flag.BoolVar(&c.upgrade, "upgrade", false, "Upgrade the deployed stages to the foundation code in the foundation path, merging it with the changes made in the stage repositories, and plan and apply it in each environment.")
+	flag.StringVar(&c.promotion, "promotion", stages.PromotionPush, "How the code is promoted to the environment branches: push or pull_request. With pull_request the helper opens a GitHub pull request or a GitLab merge request from the plan branch to each environment branch and waits for it to be merged.")
+	flag.BoolVar(&c.autoMerge, "auto_merge", false, "Merge the pull or merge requests of the -promotion pull_request mode as soon as the branch rules allow it.")
+	flag.IntVar(&c.parallelEnvs, "parallel_envs", 1, "Maximum number of environments of a stage applied or destroyed at the same time. Environments with a promotion gate are not applied in parallel with the next environments.")
+	flag.BoolVar(&c.drift, "drift", false, "Run terraform plan in the environments of the deployed stages and report the resources changed outside of the foundation code. Exits with code 4 if drift is found.")
+	flag.StringVar(&c.stages, "stages", "", "Comma separated `list` of stages to be executed. Example: 2-environments,3-networks")
+	flag.StringVar(&c.fromStage, "from", "", "First `stage` of a contiguous range of stages to be executed.")
+	flag.StringVar(&c.toStage, "to", "", "Last `stage` of a contiguous range of stages to be executed.")
+	flag.StringVar(&c.output, "output", "text", "Output `format`: text or json. In json mode progress events are written to stdout, one JSON object per line, and the text output is written to stderr.")
+	flag.StringVar(&c.format, "format", "text", "Output `format` of -list_steps, -validate, and -drift: text or json. The -validate flag also supports sarif.")
 
 	flag.Parse()
 	return c
 }
 
+// interruptContext returns a context that is cancelled on the first SIGINT or SIGTERM.
+// A second signal terminates the helper immediately.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Printf("\n# Received %s. Stopping after the current command. Send it again to exit immediately.\n", sig)
+		signal.Stop(sigs)
+		cancel()
+	}()
+	return ctx
+}
+
+// emitDeploymentFinished emits the final status of the deployment.
+func emitDeploymentFinished(ctx context.Context, mode string, start time.Time, err error) {
+	e := events.Event{Type: events.DeploymentFinished, Mode: mode, Status: "succeeded", Duration: events.Since(start)}
+	switch {
+	case ctx.Err() != nil:
+		e.Status = "interrupted"
+	case err != nil:
+		e.Status = "failed"
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	events.Emit(e)
+}
+
+// exitIfInterrupted exits if the execution was interrupted.
+func exitIfInterrupted(ctx context.Context, s steps.Steps) {
+	if ctx.Err() == nil {
+		return
+	}
+	fmt.Printf("# Execution interrupted. Progress was saved in the steps file %s, run the helper again to resume.\n", s.File)
+	exit(s, 130)
+}
+
+// printValidation writes the validation issues in the given format.
+func printValidation(w io.Writer, format, tfvarsFile string, issues []stages.ValidationIssue) error {
+	var b []byte
+	var err error
+	switch format {
+	case "json":
+		b, err = json.MarshalIndent(issues, "", "  ")
+	case "sarif":
+		b, err = stages.ValidationSARIF(issues, tfvarsFile)
+	default:
+		if len(issues) == 0 {
+			_, err = fmt.Fprintln(w, "# No issues found.")
+			return err
+		}
+		for _, i := range issues {
+			if _, err = fmt.Fprintf(w, "# %s\n", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// printDrift writes the drift detection results in the given format.
+func printDrift(w io.Writer, format string, results []stages.DriftResult) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+	if len(results) == 0 {
+		_, err := fmt.Fprintln(w, "# No deployed stages found.")
+		return err
+	}
+	for _, l := range stages.DriftReport(results) {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// useColor checks if the output is a terminal that supports colors.
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// exit releases the lock of the steps file and exits with the given code.
+func exit(s steps.Steps, code int) {
+	if err := s.Unlock(); err != nil {
+		fmt.Printf("# failed to unlock steps file %s. Error: %s\n", s.File, err.Error())
+	}
+	os.Exit(code)
+}
+
 func main() {
 
 	cfg := parseFlags()
@@ -76,6 +217,89 @@ func main() {
 		return
 	}
 
+	if cfg.output != "text" && cfg.output != "json" {
+		fmt.Printf("# Invalid output format '%s'. Must be one of: text, json\n", cfg.output)
+		os.Exit(1)
+	}
+
+	switch {
+	case cfg.format == "text" || cfg.format == "json":
+	case cfg.format == "sarif" && cfg.validate:
+	default:
+		fmt.Printf("# Invalid format '%s'. Must be one of: text, json, or sarif with -validate\n", cfg.format)
+		os.Exit(1)
+	}
+
+	// the events and the reports cannot share stdout
+	if cfg.output == "json" && cfg.format != "text" {
+		fmt.Printf("# Flag 'output' json cannot be used together with flag 'format' %s.\n", cfg.format)
+		os.Exit(1)
+	}
+
+	// the events and the reports are written to stdout.
+	// The text output of the helper and of the terraform commands is sent to stderr when stdout is used by one of them.
+	report := os.Stdout
+	if cfg.output == "json" {
+		events.SetOutput(os.Stdout)
+	}
+	if cfg.output == "json" || (cfg.format != "text" && (cfg.validate || cfg.drift || cfg.listSteps)) {
+		os.Stdout = os.Stderr
+	}
+
+	if cfg.planOnly && cfg.destroy {
+		fmt.Println("# Flags 'plan_only' and 'destroy' cannot be used together.")
+		os.Exit(1)
+	}
+
+	if cfg.drift && (cfg.planOnly || cfg.destroy) {
+		fmt.Println("# Flag 'drift' cannot be used together with flags 'plan_only' or 'destroy'.")
+		os.Exit(1)
+	}
+
+	if cfg.promotion != stages.PromotionPush && cfg.promotion != stages.PromotionPullRequest {
+		fmt.Printf("# Invalid promotion '%s'. Must be one of: %s, %s\n", cfg.promotion, stages.PromotionPush, stages.PromotionPullRequest)
+		os.Exit(1)
+	}
+
+	if cfg.autoMerge && cfg.promotion != stages.PromotionPullRequest {
+		fmt.Printf("# Flag 'auto_merge' can only be used with flag 'promotion' %s.\n", stages.PromotionPullRequest)
+		os.Exit(1)
+	}
+
+	if cfg.parallelEnvs < 1 {
+		fmt.Printf("# Invalid parallel_envs '%d'. Must be at least 1.\n", cfg.parallelEnvs)
+		os.Exit(1)
+	}
+
+	if cfg.upgrade && (cfg.drift || cfg.destroy) {
+		fmt.Println("# Flag 'upgrade' cannot be used together with flags 'drift' or 'destroy'.")
+		os.Exit(1)
+	}
+
+	if cfg.resetStep != "" && cfg.resetFrom != "" {
+		fmt.Println("# Flags 'reset_step' and 'reset_from' cannot be used together.")
+		os.Exit(1)
+	}
+
+	if cfg.recursive && cfg.resetStep == "" {
+		fmt.Println("# Flag 'recursive' can only be used with flag 'reset_step'.")
+		os.Exit(1)
+	}
+
+	registry := stages.DefaultRegistry
+	selected, err := registry.Select(cfg.stages, cfg.fromStage, cfg.toStage)
+	if err != nil {
+		fmt.Printf("# Failed to select stages. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if cfg.resetFrom != "" {
+		if _, err := registry.Dependents(cfg.resetFrom); err != nil {
+			fmt.Printf("# Failed to select stage to reset. Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	// load tfvars
 	globalTFVars, err := stages.ReadGlobalTFVars(cfg.tfvarsFile)
 	if err != nil {
@@ -90,9 +314,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	if cfg.preflight {
+		checks := stages.NewPreflight(globalTFVars).Checks()
+		fmt.Print(stages.PreflightTable(checks))
+		if !stages.PreflightPassed(checks) {
+			fmt.Println("# Preflight checks failed.")
+			os.Exit(1)
+		}
+		return
+	}
+
 	// init infra
 	gotest.Init()
 	t := &testing.RuntimeT{}
+	ctx := interruptContext()
 
 	// validate gcloud components
 	err = stages.ValidateComponents(t)
@@ -101,16 +336,43 @@ func main() {
 		os.Exit(1)
 	}
 
+	gates, err := globalTFVars.GetPromotionGates()
+	if err != nil {
+		fmt.Printf("# Invalid promotion gates. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	for env, gate := range gates {
+		if cfg.disablePrompt && gate.Approval && !gate.HasApprovalHook() {
+			fmt.Printf("# Promotion gate of environment %s requires 'approval_file' or 'approval_url' with flag 'disable_prompt'.\n", env)
+			os.Exit(1)
+		}
+	}
+
 	conf := stages.CommonConf{
 		FoundationPath:    globalTFVars.FoundationCodePath,
 		CheckoutPath:      globalTFVars.CodeCheckoutPath,
 		PolicyPath:        filepath.Join(globalTFVars.FoundationCodePath, "policy-library"),
 		BuildType:         globalTFVars.BuildType,
 		EnableHubAndSpoke: globalTFVars.EnableHubAndSpoke,
+		BusinessUnits:     globalTFVars.GetBusinessUnits(),
+		Envs:              globalTFVars.GetEnvironments(),
+		ProductionEnv:     globalTFVars.GetProductionEnvironment(),
+		GitBaseURL:        globalTFVars.GetGitBaseURL(),
+		GitAPIURL:         globalTFVars.GetGitAPIURL(),
 		DisablePrompt:     cfg.disablePrompt,
+		PlanOnly:          cfg.planOnly,
+		Promotion:         cfg.promotion,
+		AutoMerge:         cfg.autoMerge,
+		ParallelEnvs:      cfg.parallelEnvs,
+		Gates:             gates,
 		Logger:            utils.GetLogger(cfg.quiet),
 	}
 
+	if cfg.promotion == stages.PromotionPullRequest && globalTFVars.BuildType != stages.BuildTypeGiHub && globalTFVars.BuildType != stages.BuildTypeGitLab {
+		fmt.Printf("# Promotion %s is only supported for build types %s and %s.\n", stages.PromotionPullRequest, stages.BuildTypeGiHub, stages.BuildTypeGitLab)
+		os.Exit(1)
+	}
+
 	// validate git configuration for GitHub and GitLab
 	if globalTFVars.BuildType == stages.BuildTypeGiHub || globalTFVars.BuildType == stages.BuildTypeGitLab {
 		token := os.Getenv("GIT_TOKEN")
@@ -124,6 +386,49 @@ func main() {
 		}
 		conf.GitToken = token
 	}
+	// validate Jenkins configuration
+	if globalTFVars.BuildType == stages.BuildTypeJenkins {
+		token := os.Getenv("JENKINS_API_TOKEN")
+		if token == "" {
+			fmt.Println("# JENKINS_API_TOKEN environment variable not set. It is required for Jenkins.")
+			os.Exit(1)
+		}
+		if globalTFVars.GitRepos == nil || globalTFVars.Jenkins == nil {
+			fmt.Printf("# for build type %s variables 'git_repos' and 'jenkins' are required\n", globalTFVars.BuildType)
+			os.Exit(1)
+		}
+		conf.JenkinsToken = token
+	}
+	// validate Terraform Cloud configuration
+	if globalTFVars.BuildType == stages.BuildTypeTFC {
+		token := os.Getenv("TFC_TOKEN")
+		gitToken := os.Getenv("GIT_TOKEN")
+		if token == "" || gitToken == "" {
+			fmt.Println("# TFC_TOKEN and GIT_TOKEN environment variables must be set. They are required for Terraform Cloud.")
+			os.Exit(1)
+		}
+		if globalTFVars.GitRepos == nil || globalTFVars.TFC == nil {
+			fmt.Printf("# for build type %s variables 'git_repos' and 'tfc' are required\n", globalTFVars.BuildType)
+			os.Exit(1)
+		}
+		if globalTFVars.TFC.VCSProvider != stages.BuildTypeGiHub && globalTFVars.TFC.VCSProvider != stages.BuildTypeGitLab {
+			fmt.Printf("# invalid tfc vcs_provider '%s'. Must be one of: %s, %s\n", globalTFVars.TFC.VCSProvider, stages.BuildTypeGiHub, stages.BuildTypeGitLab)
+			os.Exit(1)
+		}
+		// used by terraform to access the states and the outputs in Terraform Cloud
+		for k, v := range map[string]string{
+			"TF_TOKEN_app_terraform_io": token,
+			"TF_CLOUD_ORGANIZATION":     globalTFVars.TFC.Organization,
+			"TF_VAR_tfc_org_name":       globalTFVars.TFC.Organization,
+		} {
+			if err := os.Setenv(k, v); err != nil {
+				fmt.Printf("# failed to set environment variable %s. Error: %s\n", k, err.Error())
+				os.Exit(1)
+			}
+		}
+		conf.TFCToken = token
+		conf.GitToken = gitToken
+	}
 	// only enable services if they are not already enabled
 	if globalTFVars.HasValidatorProj() {
 		conf.ValidatorProject = *globalTFVars.ValidatorProjectID
@@ -142,7 +447,10 @@ func main() {
 			gcpConf.EnableAPIs(t, *globalTFVars.ValidatorProjectID, apis)
 			fmt.Println("# waiting for API propagation")
 			for i := 0; i < 20; i++ {
-				time.Sleep(10 * time.Second)
+				if err := utils.Sleep(ctx, 10*time.Second); err != nil {
+					fmt.Println("# Interrupted while waiting for API propagation.")
+					os.Exit(130)
+				}
 				fmt.Println("# waiting for API propagation")
 			}
 		}
@@ -150,20 +458,46 @@ func main() {
 
 	// validate inputs
 	if cfg.validate {
-		stages.ValidateBasicFields(t, globalTFVars)
-		stages.ValidateDestroyFlags(t, globalTFVars)
+		fmt.Println("# Validating tfvar file.")
+		issues := append(stages.ValidateBasicFields(t, globalTFVars), stages.ValidateDestroyFlags(t, globalTFVars)...)
+		if err := printValidation(report, cfg.format, cfg.tfvarsFile, issues); err != nil {
+			fmt.Printf("# Failed to report validation issues. Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if stages.HasErrors(issues) {
+			os.Exit(1)
+		}
 		return
 	}
 
-	s, err := steps.LoadSteps(cfg.stepsFile)
+	stepsLocation := cfg.stepsFile
+	if cfg.stepsBackend != "" {
+		stepsLocation = cfg.stepsBackend
+	}
+	store, err := steps.NewStore(ctx, stepsLocation)
 	if err != nil {
-		fmt.Printf("# failed to load state file %s. Error: %s\n", cfg.stepsFile, err.Error())
+		fmt.Printf("# failed to open state %s. Error: %s\n", stepsLocation, err.Error())
 		os.Exit(2)
 	}
 
 	if cfg.listSteps {
+		// the steps state is not locked, so the steps of a running deployment can be listed
+		s, err := steps.ReadStepsFromStore(store)
+		if err != nil {
+			fmt.Printf("# failed to read state file %s. Error: %s\n", stepsLocation, err.Error())
+			os.Exit(2)
+		}
+		if cfg.format == "json" {
+			b, err := json.MarshalIndent(s.Tree(), "", "  ")
+			if err != nil {
+				fmt.Printf("# failed to list steps. Error: %s\n", err.Error())
+				os.Exit(2)
+			}
+			fmt.Fprintln(report, string(b))
+			return
+		}
 		fmt.Println("# Executed steps:")
-		e := s.ListSteps()
+		e := s.ListStepsTree(useColor(os.Stdout))
 		if len(e) == 0 {
 			fmt.Println("# No steps executed")
 			return
@@ -174,185 +508,135 @@ func main() {
 		return
 	}
 
+	s, err := steps.LoadStepsFromStore(store)
+	if err != nil {
+		fmt.Printf("# failed to load state file %s. Error: %s\n", stepsLocation, err.Error())
+		os.Exit(2)
+	}
+	defer func() {
+		if err := s.Unlock(); err != nil {
+			fmt.Printf("# failed to unlock steps file %s. Error: %s\n", s.File, err.Error())
+		}
+	}()
+
 	if cfg.resetStep != "" {
-		if err := s.ResetStep(cfg.resetStep); err != nil {
+		reset := s.ResetStep
+		if cfg.recursive {
+			reset = s.ResetStepRecursive
+		}
+		if err := reset(cfg.resetStep); err != nil {
 			fmt.Printf("# Reset step failed. Error: %s\n", err.Error())
-			os.Exit(3)
+			exit(s, 3)
+		}
+		return
+	}
+
+	if cfg.resetFrom != "" {
+		if err := registry.ResetFrom(s, conf, cfg.resetFrom); err != nil {
+			fmt.Printf("# Reset stage failed. Error: %s\n", err.Error())
+			exit(s, 3)
 		}
 		return
 	}
 
+	if cfg.planOnly {
+		fmt.Println("# Running in plan only mode. No changes will be applied and progress will not be saved.")
+		s.PlanOnly = true
+	}
+
 	var envVars map[string]string
 
 	switch conf.BuildType {
 	case stages.BuildTypeGiHub:
-		envVars = map[string]string{
-			"TF_VAR_gh_token": conf.GitToken,
-		}
+		envVars = conf.GitProviderEnvVars(stages.BuildTypeGiHub)
+		envVars["TF_VAR_gh_token"] = conf.GitToken
 	case stages.BuildTypeGitLab:
+		envVars = conf.GitProviderEnvVars(stages.BuildTypeGitLab)
+		envVars["TF_VAR_gitlab_token"] = conf.GitToken
+	case stages.BuildTypeTFC:
 		envVars = map[string]string{
-			"TF_VAR_gitlab_token": conf.GitToken,
+			"TF_VAR_tfc_token":          conf.TFCToken,
+			"TF_VAR_vcs_oauth_token_id": globalTFVars.TFC.VCSOauthTokenID,
 		}
 	}
-	// destroy stages
-	if cfg.destroy {
-		// Note: destroy is only terraform destroy, local directories are not deleted.
-		if conf.BuildType == stages.BuildTypeCBCSR {
-			// 5-app-infra
-			msg.PrintStageMsg("Destroying 5-app-infra stage")
-			err = s.RunDestroyStep("bu1-example-app", func() error {
-				io := stages.GetInfraPipelineOutputs(t, conf.CheckoutPath, "bu1-example-app")
-				return stages.DestroyExampleAppStage(t, s, io, conf)
-			})
-			if err != nil {
-				fmt.Printf("# Example app step destroy failed. Error: %s\n", err.Error())
-				os.Exit(3)
+
+	runConf := stages.NewRunConf(globalTFVars, conf, envVars)
+
+	if cfg.drift {
+		results, err := registry.Drift(t, ctx, s, runConf, selected)
+		exitIfInterrupted(ctx, s)
+		if err != nil {
+			fmt.Printf("# Drift detection failed. Error: %s\n", err.Error())
+			exit(s, 3)
+		}
+		if err := printDrift(report, cfg.format, results); err != nil {
+			fmt.Printf("# Failed to report drift. Error: %s\n", err.Error())
+			exit(s, 3)
+		}
+		for _, r := range results {
+			if r.Error != "" {
+				exit(s, 3)
 			}
 		}
-		// 4-projects
-		msg.PrintStageMsg("Destroying 4-projects stage")
-		err = s.RunDestroyStep("gcp-projects", func() error {
-			bo := stages.GetBootstrapStepOutputs(t, conf.FoundationPath, conf.BuildType)
-			return stages.DestroyProjectsStage(t, s, bo, conf)
-		})
-		if err != nil {
-			fmt.Printf("# Projects step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+		if stages.HasDrift(results) {
+			exit(s, 4)
 		}
+		return
+	}
 
-		// 3-networks
-		msg.PrintStageMsg("Destroying 3-networks stage")
-		err = s.RunDestroyStep("gcp-networks", func() error {
-			bo := stages.GetBootstrapStepOutputs(t, conf.FoundationPath, conf.BuildType)
-			return stages.DestroyNetworksStage(t, s, bo, conf)
-		})
-		if err != nil {
-			fmt.Printf("# Networks step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
-		}
+	mode := "deploy"
+	switch {
+	case cfg.destroy:
+		mode = "destroy"
+	case cfg.planOnly:
+		mode = "plan_only"
+	case cfg.upgrade:
+		mode = "upgrade"
+	}
+	start := time.Now()
+	events.Emit(events.Event{Type: events.DeploymentStarted, Mode: mode})
 
-		// 2-environments
-		msg.PrintStageMsg("Destroying 2-environments stage")
-		err = s.RunDestroyStep("gcp-environments", func() error {
-			bo := stages.GetBootstrapStepOutputs(t, conf.FoundationPath, conf.BuildType)
-			return stages.DestroyEnvStage(t, s, bo, conf)
-		})
+	// destroy stages
+	if cfg.destroy {
+		// Note: destroy is only terraform destroy, local directories are not deleted.
+		err = registry.Destroy(t, ctx, s, runConf, selected)
+		emitDeploymentFinished(ctx, mode, start, err)
+		exitIfInterrupted(ctx, s)
 		if err != nil {
-			fmt.Printf("# Environments step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+			fmt.Printf("# Destroy failed. Error: %s\n", err.Error())
+			exit(s, 3)
 		}
 
-		// 1-org
-		msg.PrintStageMsg("Destroying 1-org stage")
-		err = s.RunDestroyStep("gcp-org", func() error {
-			bo := stages.GetBootstrapStepOutputs(t, conf.FoundationPath, conf.BuildType)
-			return stages.DestroyOrgStage(t, s, bo, conf)
-		})
-		if err != nil {
-			fmt.Printf("# Org step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+		// clean up the steps file only when the whole deployment was destroyed
+		if len(selected) < len(registry.Names()) {
+			return
 		}
-
-		// 0-bootstrap
-		msg.PrintStageMsg("Destroying 0-bootstrap stage")
-		err = s.RunDestroyStep("gcp-bootstrap", func() error {
-			return stages.DestroyBootstrapStage(t, s, conf, envVars)
-		})
+		err = s.Delete()
 		if err != nil {
-			fmt.Printf("# Bootstrap step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+			fmt.Printf("# failed to delete state file %s. Error: %s\n", s.File, err.Error())
+			exit(s, 3)
 		}
+		return
+	}
 
-		// clean up the steps file
-		err = steps.DeleteStepsFile(cfg.stepsFile)
+	// upgrade stages
+	if cfg.upgrade {
+		err = registry.Upgrade(t, ctx, s, runConf, selected)
+		emitDeploymentFinished(ctx, mode, start, err)
+		exitIfInterrupted(ctx, s)
 		if err != nil {
-			fmt.Printf("# failed to delete state file %s. Error: %s\n", cfg.stepsFile, err.Error())
-			os.Exit(3)
+			fmt.Printf("# Upgrade failed. Error: %s\n", err.Error())
+			exit(s, 3)
 		}
 		return
 	}
 
 	// deploy stages
-
-	// 0-bootstrap
-	msg.PrintStageMsg("Deploying 0-bootstrap stage")
-	skipInnerBuildMsg := s.IsStepComplete("gcp-bootstrap")
-	err = s.RunStep("gcp-bootstrap", func() error {
-		return stages.DeployBootstrapStage(t, s, globalTFVars, conf)
-
-	})
+	err = registry.Deploy(t, ctx, s, runConf, selected)
+	emitDeploymentFinished(ctx, mode, start, err)
+	exitIfInterrupted(ctx, s)
 	if err != nil {
-		fmt.Printf("# Bootstrap step failed. Error: %s\n", err.Error())
-		os.Exit(3)
+		fmt.Printf("# Deploy failed. Error: %s\n", err.Error())
+		exit(s, 3)
 	}
-
-	bo := stages.GetBootstrapStepOutputs(t, conf.FoundationPath, conf.BuildType)
-
-	if skipInnerBuildMsg {
-		msg.PrintBuildMsg(bo.CICDProject, bo.DefaultRegion, conf.DisablePrompt)
-	}
-	msg.PrintQuotaMsg(bo.ProjectsSA, conf.DisablePrompt)
-	if globalTFVars.HasGroupsCreation() {
-		msg.PrintAdminGroupPermissionMsg(bo.BootstrapSA, conf.DisablePrompt)
-	}
-
-	// 1-org
-	msg.PrintStageMsg("Deploying 1-org stage")
-	err = s.RunStep("gcp-org", func() error {
-		return stages.DeployOrgStage(t, s, globalTFVars, bo, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Org step failed. Error: %s\n", err.Error())
-		os.Exit(3)
-	}
-
-	// 2-environments
-	msg.PrintStageMsg("Deploying 2-environments stage")
-	err = s.RunStep("gcp-environments", func() error {
-		return stages.DeployEnvStage(t, s, globalTFVars, bo, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Environments step failed. Error: %s\n", err.Error())
-		os.Exit(3)
-	}
-
-	// 3-networks
-	msg.PrintStageMsg("Deploying 3-networks stage")
-	err = s.RunStep("gcp-networks", func() error {
-		return stages.DeployNetworksStage(t, s, globalTFVars, bo, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Networks step failed. Error: %s\n", err.Error())
-		os.Exit(3)
-	}
-
-	// 4-projects
-	msg.PrintStageMsg("Deploying 4-projects stage")
-	msg.ConfirmQuota(bo.ProjectsSA, conf.DisablePrompt)
-
-	err = s.RunStep("gcp-projects", func() error {
-		return stages.DeployProjectsStage(t, s, globalTFVars, bo, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Projects step failed. Error: %s\n", err.Error())
-		os.Exit(3)
-	}
-
-	if conf.BuildType == stages.BuildTypeCBCSR {
-		// 5-app-infra
-		msg.PrintStageMsg("Deploying 5-app-infra stage")
-		io := stages.GetInfraPipelineOutputs(t, conf.CheckoutPath, "bu1-example-app")
-		io.RemoteStateBucket = bo.RemoteStateBucketProjects
-
-		msg.PrintBuildMsg(io.InfraPipeProj, io.DefaultRegion, conf.DisablePrompt)
-
-		err = s.RunStep("bu1-example-app", func() error {
-			return stages.DeployExampleAppStage(t, s, globalTFVars, io, conf)
-		})
-		if err != nil {
-			fmt.Printf("# Example app step failed. Error: %s\n", err.Error())
-			os.Exit(3)
-		}
-	}
-
 }
@@ -22,15 +22,17 @@ import (
 )
 
 const (
-	size            = 70
-	readmeURL       = "https://github.com/terraform-google-modules/terraform-example-foundation/blob/master/%s/README.md"
-	cloudBuildURL   = "https://console.cloud.google.com/cloud-build/builds;region=%s?project=%s"
-	githubActionURL = "https://github.com/%s/%s/actions"
-	gitlabJobURL    = "https://gitlab.com/%s/%s/-/jobs?kind=BUILD"
-	buildErrorURL   = "https://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s"
-	quotaURL        = "https://support.google.com/code/contact/billing_quota_increase"
-	troubleQuotaURL = "https://github.com/terraform-google-modules/terraform-example-foundation/blob/master/docs/TROUBLESHOOTING.md#billing-quota-exceeded"
-	groupAdminURL   = "https://cloud.google.com/identity/docs/how-to/setup#assigning_an_admin_role_to_the_service_account"
+	size             = 70
+	readmeURL        = "https://github.com/terraform-google-modules/terraform-example-foundation/blob/master/%s/README.md"
+	cloudBuildURL    = "https://console.cloud.google.com/cloud-build/builds;region=%s?project=%s"
+	githubActionURL  = "%s/%s/%s/actions"
+	gitlabJobURL     = "%s/%s/%s/-/jobs?kind=BUILD"
+	buildErrorURL    = "https://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s"
+	quotaURL         = "https://support.google.com/code/contact/billing_quota_increase"
+	troubleQuotaURL  = "https://github.com/terraform-google-modules/terraform-example-foundation/blob/master/docs/TROUBLESHOOTING.md#billing-quota-exceeded"
+	groupAdminURL    = "https://cloud.google.com/identity/docs/how-to/setup#assigning_an_admin_role_to_the_service_account"
+	jenkinsReadmeURL = "https://github.com/terraform-google-modules/terraform-example-foundation/blob/master/0-bootstrap/README-Jenkins.md#iii-configure-vpn-connection"
+	tfcWorkspaceURL  = "https://app.terraform.io/app/%s/workspaces/%s/runs"
 )
 
 var (
@@ -58,11 +60,15 @@ func pad(msg string, size int) string {
 func CloudBuildURL(project, region string) string {
 	return fmt.Sprintf(cloudBuildURL, region, project)
 }
-func GitHubActionURL(owner, repo string) string {
-	return fmt.Sprintf(githubActionURL, owner, repo)
+func GitHubActionURL(baseURL, owner, repo string) string {
+	return fmt.Sprintf(githubActionURL, strings.TrimSuffix(baseURL, "/"), owner, repo)
 }
-func GitLabJobURL(owner, repo string) string {
-	return fmt.Sprintf(gitlabJobURL, owner, repo)
+func GitLabJobURL(baseURL, owner, repo string) string {
+	return fmt.Sprintf(gitlabJobURL, strings.TrimSuffix(baseURL, "/"), owner, repo)
+}
+
+func TFCWorkspaceURL(org, workspace string) string {
+	return fmt.Sprintf(tfcWorkspaceURL, org, workspace)
 }
 
 func BuildErrorURL(project, region, build string) string {
@@ -87,26 +93,63 @@ func PrintBuildMsg(project, region string, disablePrompt bool) {
 	}
 }
 
-func PrintGHActionMsg(owner, repo string, disablePrompt bool) {
+func PrintGHActionMsg(baseURL, owner, repo string, disablePrompt bool) {
 	fmt.Println("")
 	fmt.Println("# Follow workflow execution and check results in the GitHub:")
-	fmt.Printf("# %s\n", GitHubActionURL(owner, repo))
+	fmt.Printf("# %s\n", GitHubActionURL(baseURL, owner, repo))
 	if !disablePrompt {
 		PressEnter("# Press Enter to continue at any time")
 		fmt.Println("")
 	}
 }
 
-func PrintGLJobsMsg(owner, repo string, disablePrompt bool) {
+func PrintGLJobsMsg(baseURL, owner, repo string, disablePrompt bool) {
 	fmt.Println("")
 	fmt.Println("# Follow job execution and check results in the GitLab:")
-	fmt.Printf("# %s\n", GitLabJobURL(owner, repo))
+	fmt.Printf("# %s\n", GitLabJobURL(baseURL, owner, repo))
 	if !disablePrompt {
 		PressEnter("# Press Enter to continue at any time")
 		fmt.Println("")
 	}
 }
 
+func PrintJenkinsJobMsg(jobURL string, disablePrompt bool) {
+	fmt.Println("")
+	fmt.Println("# Follow pipeline execution and check results in the Jenkins Controller:")
+	fmt.Printf("# %s\n", jobURL)
+	if !disablePrompt {
+		PressEnter("# Press Enter to continue at any time")
+		fmt.Println("")
+	}
+}
+
+func PrintTFCRunsMsg(org string, workspaces []string, disablePrompt bool) {
+	fmt.Println("")
+	fmt.Println("# Follow the runs execution and check results in the Terraform Cloud workspaces:")
+	for _, w := range workspaces {
+		fmt.Printf("# %s\n", TFCWorkspaceURL(org, w))
+	}
+	if !disablePrompt {
+		PressEnter("# Press Enter to continue at any time")
+		fmt.Println("")
+	}
+}
+
+func PrintJenkinsSetupMsg(cicdProject string, jobs []string, disablePrompt bool) {
+	fmt.Println("")
+	fmt.Println("# Before continuing, configure the VPN connection between the Jenkins Controller")
+	fmt.Printf("# and the Jenkins Agent in the CI/CD project %s\n", cicdProject)
+	fmt.Println("# and create the Multibranch Pipelines in the Jenkins Controller for the repositories:")
+	for _, j := range jobs {
+		fmt.Printf("# - %s\n", j)
+	}
+	fmt.Printf("# See: %s\n", jenkinsReadmeURL)
+	fmt.Println("")
+	if !disablePrompt {
+		PressEnter("")
+	}
+}
+
 func PrintQuotaMsg(sa string, disablePrompt bool) {
 	fmt.Println("")
 	fmt.Println("# Request a billing quota increase for the service account of stage 4-projects")
@@ -15,15 +15,21 @@
 package stages
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
+	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/mitchellh/go-testing-interface"
 
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/gcp"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/gitlab"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/jenkins"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/msg"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
@@ -31,10 +37,10 @@ import (
 	"github.com/terraform-google-modules/terraform-example-foundation/test/integration/testutils"
 )
 
-func buildGitLabCICDImage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
-	gl := gitlab.NewGL()
+func buildGitLabCICDImage(t testing.TB, ctx context.Context, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
+	gl := gitlab.NewGL(c.GitBaseURL, c.GitAPIURL)
 	cicdPath := filepath.Join(c.CheckoutPath, "gcp-cicd-runner")
-	repoURL := utils.BuildGitLabURL(tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner, c.GitToken)
+	repoURL := utils.BuildGitLabURL(c.GitBaseURL, tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner, c.GitToken)
 
 	conf := utils.GitClone(t, tfvars.BuildType, "", repoURL, cicdPath, "", c.Logger)
 	err := conf.CheckoutBranch("image")
@@ -66,10 +72,10 @@ func buildGitLabCICDImage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 		return err
 	}
 
-	msg.PrintGLJobsMsg(tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner, c.DisablePrompt)
+	msg.PrintGLJobsMsg(c.GitBaseURL, tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner, c.DisablePrompt)
 
 	failureMsg := fmt.Sprintf("CI/CD runner image job failed %s/%s repository.", tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner)
-	err = gl.WaitBuildSuccess(t, tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner, c.GitToken, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+	err = gl.WaitBuildSuccess(t, ctx, tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner, c.GitToken, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
 	if err != nil {
 		return err
 	}
@@ -84,7 +90,7 @@ func buildGitLabCICDImage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 	return gl.AddProjectsToJobTokenScope(t, tfvars.GitRepos.Owner, *tfvars.GitRepos.CICDRunner, c.GitToken, projectsToAdd)
 }
 
-func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
+func DeployBootstrapStage(t testing.TB, ctx context.Context, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
 
 	var err error
 	var envVars map[string]string
@@ -117,9 +123,8 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 			Networks:     tfvars.GitRepos.Networks,
 			Projects:     tfvars.GitRepos.Projects,
 		}
-		envVars = map[string]string{
-			"TF_VAR_gh_token": c.GitToken,
-		}
+		envVars = c.GitProviderEnvVars(BuildTypeGiHub)
+		envVars["TF_VAR_gh_token"] = c.GitToken
 	}
 
 	if tfvars.BuildType == BuildTypeGitLab {
@@ -132,8 +137,48 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 			Projects:     tfvars.GitRepos.Projects,
 			CICDRunner:   *tfvars.GitRepos.CICDRunner,
 		}
+		envVars = c.GitProviderEnvVars(BuildTypeGitLab)
+		envVars["TF_VAR_gitlab_token"] = c.GitToken
+	}
+
+	if tfvars.BuildType == BuildTypeJenkins {
+		j := tfvars.Jenkins
+		bootstrapTfvars.JenkinsAgentGCESubnetworkCIDRRange = &j.AgentGCESubnetworkCIDRRange
+		bootstrapTfvars.JenkinsAgentGCEPrivateIPAddress = &j.AgentGCEPrivateIPAddress
+		bootstrapTfvars.JenkinsAgentGCESSHPubKey = &j.AgentGCESSHPubKey
+		bootstrapTfvars.JenkinsAgentSAEmail = &j.AgentSAEmail
+		bootstrapTfvars.JenkinsControllerSubnetworkCIDRRange = &j.ControllerSubnetworkCIDRRange
+		bootstrapTfvars.NatBGPASN = &j.NatBGPASN
+		bootstrapTfvars.VPNSharedSecret = &j.VPNSharedSecret
+		bootstrapTfvars.OnPremVPNPublicIPAddress = &j.OnPremVPNPublicIPAddress
+		bootstrapTfvars.OnPremVPNPublicIPAddress2 = &j.OnPremVPNPublicIPAddress2
+		bootstrapTfvars.RouterASN = &j.RouterASN
+		bootstrapTfvars.BGPPeerASN = &j.BGPPeerASN
+		bootstrapTfvars.Tunnel0BGPPeerAddress = &j.Tunnel0BGPPeerAddress
+		bootstrapTfvars.Tunnel0BGPSessionRange = &j.Tunnel0BGPSessionRange
+		bootstrapTfvars.Tunnel1BGPPeerAddress = &j.Tunnel1BGPPeerAddress
+		bootstrapTfvars.Tunnel1BGPSessionRange = &j.Tunnel1BGPSessionRange
+	}
+
+	if tfvars.BuildType == BuildTypeTFC {
+		tfVersion, err := terraformVersion(t)
+		if err != nil {
+			return err
+		}
+		owner := tfvars.GitRepos.Owner
+		bootstrapTfvars.VCSRepos = &VCSRepos{
+			Owner:        owner,
+			Bootstrap:    fmt.Sprintf("%s/%s", owner, tfvars.GitRepos.Bootstrap),
+			Organization: fmt.Sprintf("%s/%s", owner, tfvars.GitRepos.Organization),
+			Environments: fmt.Sprintf("%s/%s", owner, tfvars.GitRepos.Environments),
+			Networks:     fmt.Sprintf("%s/%s", owner, tfvars.GitRepos.Networks),
+			Projects:     fmt.Sprintf("%s/%s", owner, tfvars.GitRepos.Projects),
+		}
+		bootstrapTfvars.TFCOrgName = &tfvars.TFC.Organization
+		bootstrapTfvars.TFCTerraformVersion = &tfVersion
 		envVars = map[string]string{
-			"TF_VAR_gitlab_token": c.GitToken,
+			"TF_VAR_tfc_token":          c.TFCToken,
+			"TF_VAR_vcs_oauth_token_id": tfvars.TFC.VCSOauthTokenID,
 		}
 	}
 
@@ -159,19 +204,42 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 	}
 
 	// terraform deploy
-	err = applyLocal(t, options, "", c.PolicyPath, c.ValidatorProject)
+	err = applyLocal(t, options, "", c.PolicyPath, c.ValidatorProject, c.PlanOnly)
 	if err != nil {
 		return err
 	}
 
 	// read bootstrap outputs
-	defaultRegion := terraform.OutputMap(t, options, "common_config")["default_region"]
-	backendBucket := terraform.Output(t, options, "gcs_bucket_tfstate")
-	backendBucketProjects := terraform.Output(t, options, "projects_gcs_bucket_tfstate")
+	commonConfig, err := terraform.OutputMapE(t, options, "common_config")
+	if err != nil {
+		return bootstrapOutputsError(c, err)
+	}
+	defaultRegion := commonConfig["default_region"]
+	backendBucket, err := terraform.OutputE(t, options, "gcs_bucket_tfstate")
+	if err != nil {
+		return bootstrapOutputsError(c, err)
+	}
+	backendBucketProjects, err := terraform.OutputE(t, options, "projects_gcs_bucket_tfstate")
+	if err != nil {
+		return bootstrapOutputsError(c, err)
+	}
 
 	// replace backend and terraform init migrate
 	err = s.RunStep("gcp-bootstrap.migrate-state", func() error {
+		if c.PlanOnly {
+			fmt.Println("# plan only mode, skipping terraform state migration")
+			return nil
+		}
 		options.MigrateState = true
+		if tfvars.BuildType == BuildTypeTFC {
+			// the Terraform Cloud backend replaces the GCS backend in all the stages
+			err = utils.SetTFCBackendAndRemote(c.FoundationPath)
+			if err != nil {
+				return err
+			}
+			_, err := terraform.InitE(t, options)
+			return err
+		}
 		err = utils.CopyFile(filepath.Join(options.TerraformDir, "backend.tf.example"), filepath.Join(options.TerraformDir, "backend.tf"))
 		if err != nil {
 			return err
@@ -189,21 +257,7 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 
 	// replace all backend files
 	err = s.RunStep("gcp-bootstrap.replace-backend-files", func() error {
-		files, err := utils.FindFiles(c.FoundationPath, "backend.tf")
-		if err != nil {
-			return err
-		}
-		for _, file := range files {
-			err = utils.ReplaceStringInFile(file, "UPDATE_ME", backendBucket)
-			if err != nil {
-				return err
-			}
-			err = utils.ReplaceStringInFile(file, "UPDATE_PROJECTS_BACKEND", backendBucketProjects)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+		return replaceBackendBuckets(c.FoundationPath, backendBucket, backendBucketProjects)
 	})
 	if err != nil {
 		return err
@@ -223,7 +277,7 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 
 		// Check if image build was successful.
 		buildTFBuilderExecutor := NewGCPExecutor(cbProjectID, defaultRegion, "tf-cloudbuilder")
-		err = buildTFBuilderExecutor.WaitBuildSuccess(t, "", "Terraform Image builder Build Failed for tf-cloudbuilder repository.")
+		err = buildTFBuilderExecutor.WaitBuildSuccess(t, ctx, "", "", "Terraform Image builder Build Failed for tf-cloudbuilder repository.")
 		if err != nil {
 			return err
 		}
@@ -234,6 +288,10 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 		policiesBranch := "main"
 
 		err = s.RunStep("gcp-bootstrap.gcp-policies", func() error {
+			if c.PlanOnly {
+				fmt.Println("# plan only mode, skipping policies repository push")
+				return nil
+			}
 			return preparePoliciesRepo(policiesConf, policiesBranch, c.FoundationPath, gcpPoliciesPath)
 		})
 		if err != nil {
@@ -245,9 +303,9 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 	}
 
 	if tfvars.BuildType == BuildTypeGiHub {
-		msg.PrintGHActionMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.DisablePrompt)
-		executor = NewGitHubExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
-		repoURL := utils.BuildGitHubURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
+		msg.PrintGHActionMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.DisablePrompt)
+		executor = NewGitHubExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
+		repoURL := utils.BuildGitHubURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
 		bootstrapConf = utils.GitClone(t, tfvars.BuildType, "", repoURL, gcpBootstrapPath, cbProjectID, c.Logger)
 
 	}
@@ -256,16 +314,35 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 
 		// build the image to be used in the CI/CD pipelines for all the stages
 		err = s.RunStep("gcp-bootstrap.build-cicd-runner", func() error {
-			return buildGitLabCICDImage(t, s, tfvars, c)
+			if c.PlanOnly {
+				fmt.Println("# plan only mode, skipping CI/CD runner image build")
+				return nil
+			}
+			return buildGitLabCICDImage(t, ctx, s, tfvars, c)
 		})
 		if err != nil {
 			return err
 		}
 
-		msg.PrintGLJobsMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.DisablePrompt)
-		executor = NewGitLabExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
-		repoURL := utils.BuildGitLabURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
+		msg.PrintGLJobsMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.DisablePrompt)
+		executor = NewGitLabExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
+		repoURL := utils.BuildGitLabURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
+		bootstrapConf = utils.GitClone(t, tfvars.BuildType, "", repoURL, gcpBootstrapPath, cbProjectID, c.Logger)
+	}
+
+	if tfvars.BuildType == BuildTypeJenkins {
+		cbProjectID = terraform.Output(t, options, CICDProjectIdOutput)
+		repoURL := utils.BuildGitURL(tfvars.Jenkins.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap)
+		bootstrapConf = utils.GitClone(t, tfvars.BuildType, "", repoURL, gcpBootstrapPath, cbProjectID, c.Logger)
+	}
+
+	if tfvars.BuildType == BuildTypeTFC {
+		cbProjectID = terraform.Output(t, options, CICDProjectIdOutput)
+		workspaces := TFCWorkspaces(BootstrapStep, c.DeployEnvs(), c.BusinessUnits)
+		msg.PrintTFCRunsMsg(tfvars.TFC.Organization, workspaces, c.DisablePrompt)
+		repoURL := tfvars.TFC.RepoURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Bootstrap, c.GitToken)
 		bootstrapConf = utils.GitClone(t, tfvars.BuildType, "", repoURL, gcpBootstrapPath, cbProjectID, c.Logger)
+		executor = NewTFCExecutor(tfvars.TFC.Organization, c.TFCToken, workspaces)
 	}
 
 	stageConf = StageConf{
@@ -286,10 +363,11 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 	// service account is granted Group Admin role in the
 	// Google Workspace by a Super Admin.
 	// https://github.com/terraform-google-modules/terraform-google-group/blob/main/README.md#google-workspace-formerly-known-as-g-suite-roles
-	if tfvars.HasGroupsCreation() {
+	// there is no pipeline for the bootstrap repository when using Jenkins.
+	if tfvars.HasGroupsCreation() || tfvars.BuildType == BuildTypeJenkins {
 		err = saveBootstrapCodeOnly(t, stageConf, s, c)
 	} else {
-		err = deployStage(t, stageConf, s, c)
+		err = deployStage(t, ctx, stageConf, s, c)
 	}
 
 	if err != nil {
@@ -313,12 +391,19 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 	if err != nil {
 		return err
 	}
+	if tfvars.BuildType == BuildTypeJenkins {
+		jobs := []string{}
+		for _, r := range []string{tfvars.GitRepos.Organization, tfvars.GitRepos.Environments, tfvars.GitRepos.Networks, tfvars.GitRepos.Projects} {
+			jobs = append(jobs, tfvars.Jenkins.JobName(r))
+		}
+		msg.PrintJenkinsSetupMsg(cbProjectID, jobs, c.DisablePrompt)
+	}
 	fmt.Println("end of bootstrap deploy")
 
 	return nil
 }
 
-func DeployOrgStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
+func DeployOrgStage(t testing.TB, ctx context.Context, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
 
 	createACMAPolicy := testutils.GetOrgACMPolicyID(t, tfvars.OrgID) == ""
 
@@ -367,15 +452,27 @@ func DeployOrgStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs Bo
 
 	switch c.BuildType {
 	case BuildTypeGiHub:
-		msg.PrintGHActionMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.DisablePrompt)
-		executor = NewGitHubExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
-		repoURL := utils.BuildGitHubURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
+		msg.PrintGHActionMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.DisablePrompt)
+		executor = NewGitHubExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
+		repoURL := utils.BuildGitHubURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, OrgRepo), "", c.Logger)
 	case BuildTypeGitLab:
-		msg.PrintGLJobsMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.DisablePrompt)
-		executor = NewGitLabExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
-		repoURL := utils.BuildGitLabURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
+		msg.PrintGLJobsMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.DisablePrompt)
+		executor = NewGitLabExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
+		repoURL := utils.BuildGitLabURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, OrgRepo), "", c.Logger)
+	case BuildTypeJenkins:
+		job := tfvars.Jenkins.JobName(tfvars.GitRepos.Organization)
+		msg.PrintJenkinsJobMsg(jenkins.JobURL(tfvars.Jenkins.URL, job), c.DisablePrompt)
+		executor = NewJenkinsExecutor(tfvars.Jenkins.URL, tfvars.Jenkins.User, c.JenkinsToken, job)
+		repoURL := utils.BuildGitURL(tfvars.Jenkins.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, OrgRepo), "", c.Logger)
+	case BuildTypeTFC:
+		workspaces := TFCWorkspaces(OrgStep, c.DeployEnvs(), c.BusinessUnits)
+		msg.PrintTFCRunsMsg(tfvars.TFC.Organization, workspaces, c.DisablePrompt)
+		repoURL := tfvars.TFC.RepoURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Organization, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, OrgRepo), "", c.Logger)
+		executor = NewTFCExecutor(tfvars.TFC.Organization, c.TFCToken, workspaces)
 	default:
 		executor = NewGCPExecutor(outputs.CICDProject, outputs.DefaultRegion, OrgRepo)
 		conf = utils.GitClone(t, "CSR", OrgRepo, "", filepath.Join(c.CheckoutPath, OrgRepo), outputs.CICDProject, c.Logger)
@@ -383,20 +480,22 @@ func DeployOrgStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs Bo
 
 	stageConf := StageConf{
 		Stage:         OrgRepo,
+		StageSA:       outputs.OrgSA,
 		CICDProject:   outputs.CICDProject,
 		DefaultRegion: outputs.DefaultRegion,
 		Step:          OrgStep,
 		Repo:          OrgRepo,
 		GitConf:       conf,
 		Envs:          []string{"shared"},
+		StateBucket:   outputs.RemoteStateBucket,
 		BuildType:     c.BuildType,
 		Executor:      executor,
 	}
 
-	return deployStage(t, stageConf, s, c)
+	return deployStage(t, ctx, stageConf, s, c)
 }
 
-func DeployEnvStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
+func DeployEnvStage(t testing.TB, ctx context.Context, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
 
 	envsTfvars := EnvsTfvars{
 		RemoteStateBucket:        outputs.RemoteStateBucket,
@@ -413,15 +512,27 @@ func DeployEnvStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs Bo
 
 	switch c.BuildType {
 	case BuildTypeGiHub:
-		msg.PrintGHActionMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.DisablePrompt)
-		executor = NewGitHubExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
-		repoURL := utils.BuildGitHubURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
+		msg.PrintGHActionMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.DisablePrompt)
+		executor = NewGitHubExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
+		repoURL := utils.BuildGitHubURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, EnvironmentsRepo), "", c.Logger)
 	case BuildTypeGitLab:
-		msg.PrintGLJobsMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.DisablePrompt)
-		executor = NewGitLabExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
-		repoURL := utils.BuildGitLabURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
+		msg.PrintGLJobsMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.DisablePrompt)
+		executor = NewGitLabExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
+		repoURL := utils.BuildGitLabURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, EnvironmentsRepo), "", c.Logger)
+	case BuildTypeJenkins:
+		job := tfvars.Jenkins.JobName(tfvars.GitRepos.Environments)
+		msg.PrintJenkinsJobMsg(jenkins.JobURL(tfvars.Jenkins.URL, job), c.DisablePrompt)
+		executor = NewJenkinsExecutor(tfvars.Jenkins.URL, tfvars.Jenkins.User, c.JenkinsToken, job)
+		repoURL := utils.BuildGitURL(tfvars.Jenkins.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, EnvironmentsRepo), "", c.Logger)
+	case BuildTypeTFC:
+		workspaces := TFCWorkspaces(EnvironmentsStep, c.DeployEnvs(), c.BusinessUnits)
+		msg.PrintTFCRunsMsg(tfvars.TFC.Organization, workspaces, c.DisablePrompt)
+		repoURL := tfvars.TFC.RepoURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Environments, c.GitToken)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, EnvironmentsRepo), "", c.Logger)
+		executor = NewTFCExecutor(tfvars.TFC.Organization, c.TFCToken, workspaces)
 	default:
 		executor = NewGCPExecutor(outputs.CICDProject, outputs.DefaultRegion, EnvironmentsRepo)
 		conf = utils.GitClone(t, "CSR", EnvironmentsRepo, "", filepath.Join(c.CheckoutPath, EnvironmentsRepo), outputs.CICDProject, c.Logger)
@@ -429,29 +540,31 @@ func DeployEnvStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs Bo
 
 	stageConf := StageConf{
 		Stage:         EnvironmentsRepo,
+		StageSA:       outputs.EnvsSA,
 		CICDProject:   outputs.CICDProject,
 		DefaultRegion: outputs.DefaultRegion,
 		Step:          EnvironmentsStep,
 		Repo:          EnvironmentsRepo,
 		GitConf:       conf,
-		Envs:          []string{"production", "nonproduction", "development"},
+		Envs:          c.DeployEnvs(),
+		StateBucket:   outputs.RemoteStateBucket,
 		BuildType:     c.BuildType,
 		Executor:      executor,
 	}
 
-	return deployStage(t, stageConf, s, c)
+	return deployStage(t, ctx, stageConf, s, c)
 }
 
-func DeployNetworksStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
+func DeployNetworksStage(t testing.TB, ctx context.Context, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
 
 	step := GetNetworkStep(c.EnableHubAndSpoke)
 
 	var localStep []string
 
 	if c.EnableHubAndSpoke {
-		localStep = []string{"shared"}
+		localStep = []string{SharedEnv}
 	} else {
-		localStep = []string{"shared", "production"}
+		localStep = []string{SharedEnv, c.ProductionEnvironment()}
 	}
 
 	// shared
@@ -466,7 +579,7 @@ func DeployNetworksStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outpu
 	productionTfvars := NetProductionTfvars{
 		TargetNameServerAddresses: tfvars.TargetNameServerAddresses,
 	}
-	err = utils.WriteTfvars(filepath.Join(c.FoundationPath, step, "production.auto.tfvars"), productionTfvars)
+	err = utils.WriteTfvars(filepath.Join(c.FoundationPath, step, fmt.Sprintf("%s.auto.tfvars", c.ProductionEnvironment())), productionTfvars)
 	if err != nil {
 		return err
 	}
@@ -497,15 +610,27 @@ func DeployNetworksStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outpu
 
 	switch c.BuildType {
 	case BuildTypeGiHub:
-		msg.PrintGHActionMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.DisablePrompt)
-		executor = NewGitHubExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
-		repoURL := utils.BuildGitHubURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
+		msg.PrintGHActionMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.DisablePrompt)
+		executor = NewGitHubExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
+		repoURL := utils.BuildGitHubURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, NetworksRepo), "", c.Logger)
 	case BuildTypeGitLab:
-		msg.PrintGLJobsMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.DisablePrompt)
-		executor = NewGitLabExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
-		repoURL := utils.BuildGitLabURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
+		msg.PrintGLJobsMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.DisablePrompt)
+		executor = NewGitLabExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
+		repoURL := utils.BuildGitLabURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, NetworksRepo), "", c.Logger)
+	case BuildTypeJenkins:
+		job := tfvars.Jenkins.JobName(tfvars.GitRepos.Networks)
+		msg.PrintJenkinsJobMsg(jenkins.JobURL(tfvars.Jenkins.URL, job), c.DisablePrompt)
+		executor = NewJenkinsExecutor(tfvars.Jenkins.URL, tfvars.Jenkins.User, c.JenkinsToken, job)
+		repoURL := utils.BuildGitURL(tfvars.Jenkins.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, NetworksRepo), "", c.Logger)
+	case BuildTypeTFC:
+		workspaces := TFCWorkspaces(step, c.DeployEnvs(), c.BusinessUnits)
+		msg.PrintTFCRunsMsg(tfvars.TFC.Organization, workspaces, c.DisablePrompt)
+		repoURL := tfvars.TFC.RepoURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Networks, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, NetworksRepo), "", c.Logger)
+		executor = NewTFCExecutor(tfvars.TFC.Organization, c.TFCToken, workspaces)
 	default:
 		executor = NewGCPExecutor(outputs.CICDProject, outputs.DefaultRegion, NetworksRepo)
 		conf = utils.GitClone(t, "CSR", NetworksRepo, "", filepath.Join(c.CheckoutPath, NetworksRepo), outputs.CICDProject, c.Logger)
@@ -522,14 +647,15 @@ func DeployNetworksStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outpu
 		HasLocalStep:  true,
 		LocalSteps:    localStep,
 		GroupingUnits: []string{"envs"},
-		Envs:          []string{"production", "nonproduction", "development"},
+		Envs:          c.DeployEnvs(),
+		StateBucket:   outputs.RemoteStateBucket,
 		BuildType:     c.BuildType,
 		Executor:      executor,
 	}
-	return deployStage(t, stageConf, s, c)
+	return deployStage(t, ctx, stageConf, s, c)
 }
 
-func DeployProjectsStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
+func DeployProjectsStage(t testing.TB, ctx context.Context, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
 
 	// shared
 	sharedTfvars := ProjSharedTfvars{
@@ -555,11 +681,8 @@ func DeployProjectsStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outpu
 		FolderDeletionProtection: tfvars.FolderDeletionProtection,
 		ProjectDeletionPolicy:    tfvars.ProjectDeletionPolicy,
 	}
-	for _, envfile := range []string{
-		"development.auto.tfvars",
-		"nonproduction.auto.tfvars",
-		"production.auto.tfvars"} {
-		err = utils.WriteTfvars(filepath.Join(c.FoundationPath, ProjectsStep, envfile), envTfvars)
+	for _, env := range c.DeployEnvs() {
+		err = utils.WriteTfvars(filepath.Join(c.FoundationPath, ProjectsStep, fmt.Sprintf("%s.auto.tfvars", env)), envTfvars)
 		if err != nil {
 			return err
 		}
@@ -570,15 +693,27 @@ func DeployProjectsStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outpu
 
 	switch c.BuildType {
 	case BuildTypeGiHub:
-		msg.PrintGHActionMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.DisablePrompt)
-		executor = NewGitHubExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
-		repoURL := utils.BuildGitHubURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
+		msg.PrintGHActionMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.DisablePrompt)
+		executor = NewGitHubExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
+		repoURL := utils.BuildGitHubURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, ProjectsRepo), "", c.Logger)
 	case BuildTypeGitLab:
-		msg.PrintGLJobsMsg(tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.DisablePrompt)
-		executor = NewGitLabExecutor(tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
-		repoURL := utils.BuildGitLabURL(tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
+		msg.PrintGLJobsMsg(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.DisablePrompt)
+		executor = NewGitLabExecutor(c.GitBaseURL, c.GitAPIURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
+		repoURL := utils.BuildGitLabURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
 		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, ProjectsRepo), "", c.Logger)
+	case BuildTypeJenkins:
+		job := tfvars.Jenkins.JobName(tfvars.GitRepos.Projects)
+		msg.PrintJenkinsJobMsg(jenkins.JobURL(tfvars.Jenkins.URL, job), c.DisablePrompt)
+		executor = NewJenkinsExecutor(tfvars.Jenkins.URL, tfvars.Jenkins.User, c.JenkinsToken, job)
+		repoURL := utils.BuildGitURL(tfvars.Jenkins.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, ProjectsRepo), "", c.Logger)
+	case BuildTypeTFC:
+		workspaces := TFCWorkspaces(ProjectsStep, c.DeployEnvs(), c.BusinessUnits)
+		msg.PrintTFCRunsMsg(tfvars.TFC.Organization, workspaces, c.DisablePrompt)
+		repoURL := tfvars.TFC.RepoURL(c.GitBaseURL, tfvars.GitRepos.Owner, tfvars.GitRepos.Projects, c.GitToken)
+		conf = utils.GitClone(t, tfvars.BuildType, "", repoURL, filepath.Join(c.CheckoutPath, ProjectsRepo), "", c.Logger)
+		executor = NewTFCExecutor(tfvars.TFC.Organization, c.TFCToken, workspaces)
 	default:
 		executor = NewGCPExecutor(outputs.CICDProject, outputs.DefaultRegion, ProjectsRepo)
 		conf = utils.GitClone(t, "CSR", ProjectsRepo, "", filepath.Join(c.CheckoutPath, ProjectsRepo), outputs.CICDProject, c.Logger)
@@ -593,18 +728,19 @@ func DeployProjectsStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outpu
 		Repo:          ProjectsRepo,
 		GitConf:       conf,
 		HasLocalStep:  true,
-		LocalSteps:    []string{"shared"},
-		GroupingUnits: []string{"business_unit_1"},
-		Envs:          []string{"production", "nonproduction", "development"},
+		LocalSteps:    []string{SharedEnv},
+		GroupingUnits: GroupingUnits(c.BusinessUnits),
+		Envs:          c.DeployEnvs(),
+		StateBucket:   outputs.RemoteStateBucket,
 		BuildType:     c.BuildType,
 		Executor:      executor,
 	}
 
-	return deployStage(t, stageConf, s, c)
+	return deployStage(t, ctx, stageConf, s, c)
 
 }
 
-func DeployExampleAppStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs InfraPipelineOutputs, c CommonConf) error {
+func DeployExampleAppStage(t testing.TB, ctx context.Context, s steps.Steps, tfvars GlobalTFVars, bu BusinessUnit, outputs InfraPipelineOutputs, c CommonConf) error {
 	digest, err := gcp.NewGCP().GetDockerImageDigest(t, outputs.BootstrapCloudbuildProjectID, outputs.ImageName)
 	if err != nil {
 		return err
@@ -620,48 +756,75 @@ func DeployExampleAppStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, out
 		return err
 	}
 	// update backend bucket
-	for _, e := range []string{"production", "nonproduction", "development"} {
-		err = utils.ReplaceStringInFile(filepath.Join(c.FoundationPath, AppInfraStep, "business_unit_1", e, "backend.tf"), "UPDATE_APP_INFRA_BUCKET", outputs.StateBucket)
+	for _, e := range c.DeployEnvs() {
+		err = utils.ReplaceStringInFile(filepath.Join(c.FoundationPath, AppInfraStep, bu.Name, e, "backend.tf"), "UPDATE_APP_INFRA_BUCKET", outputs.StateBucket)
 		if err != nil {
 			return err
 		}
 	}
-	gcpPoliciesPath := filepath.Join(c.CheckoutPath, "gcp-policies-app-infra")
+	gcpPoliciesPath := filepath.Join(c.CheckoutPath, appInfraPoliciesRepo(bu))
 	policiesConf := utils.GitClone(t, "CSR", PoliciesRepo, "", gcpPoliciesPath, outputs.InfraPipeProj, c.Logger)
 	policiesBranch := "main"
-	err = s.RunStep("bu1-example-app.gcp-policies-app-infra", func() error {
+	err = s.RunStep(fmt.Sprintf("%s.%s", bu.AppInfraRepo, AppInfraPoliciesRepo), func() error {
+		if c.PlanOnly {
+			fmt.Println("# plan only mode, skipping policies repository push")
+			return nil
+		}
 		return preparePoliciesRepo(policiesConf, policiesBranch, c.FoundationPath, gcpPoliciesPath)
 	})
 	if err != nil {
 		return err
 	}
 
-	executor := NewGCPExecutor(outputs.InfraPipeProj, outputs.DefaultRegion, AppInfraRepo)
-	conf := utils.GitClone(t, "CSR", AppInfraRepo, "", filepath.Join(c.CheckoutPath, AppInfraRepo), outputs.InfraPipeProj, c.Logger)
+	executor := NewGCPExecutor(outputs.InfraPipeProj, outputs.DefaultRegion, bu.AppInfraRepo)
+	conf := utils.GitClone(t, "CSR", bu.AppInfraRepo, "", filepath.Join(c.CheckoutPath, bu.AppInfraRepo), outputs.InfraPipeProj, c.Logger)
 	stageConf := StageConf{
-		Stage:         AppInfraRepo,
+		Stage:         bu.AppInfraRepo,
 		CICDProject:   outputs.InfraPipeProj,
 		DefaultRegion: outputs.DefaultRegion,
 		Step:          AppInfraStep,
-		Repo:          AppInfraRepo,
+		Repo:          bu.AppInfraRepo,
+		ExcludeDirs:   otherBusinessUnits(c.BusinessUnits, bu),
 		GitConf:       conf,
-		Envs:          []string{"production", "nonproduction", "development"},
+		Envs:          c.DeployEnvs(),
 		BuildType:     c.BuildType,
 		Executor:      executor,
 	}
 
-	return deployStage(t, stageConf, s, c)
+	return deployStage(t, ctx, stageConf, s, c)
+}
+
+// appInfraPoliciesRepo returns the directory of the policies repository of the infra pipeline of the business unit
+func appInfraPoliciesRepo(bu BusinessUnit) string {
+	if bu.Name == DefaultBusinessUnit {
+		return AppInfraPoliciesRepo
+	}
+	return fmt.Sprintf("%s-%s", AppInfraPoliciesRepo, bu.Name)
+}
+
+// otherBusinessUnits returns the names of the business units different from the given one
+func otherBusinessUnits(bus []BusinessUnit, bu BusinessUnit) []string {
+	others := []string{}
+	for _, b := range bus {
+		if b.Name != bu.Name {
+			others = append(others, b.Name)
+		}
+	}
+	return others
 }
 
-func deployStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error {
+func deployStage(t testing.TB, ctx context.Context, sc StageConf, s steps.Steps, c CommonConf) error {
 
 	err := sc.GitConf.CheckoutBranch("plan")
 	if err != nil {
 		return err
 	}
 
-	err = s.RunStep(fmt.Sprintf("%s.copy-code", sc.Stage), func() error {
-		return copyStepCode(t, sc.GitConf, c.FoundationPath, c.CheckoutPath, sc.Repo, sc.Step, sc.CustomTargetDirPath, sc.BuildType)
+	err = runCopyCodeStep(s, sc, c, func() error {
+		if c.Upgrade {
+			return mergeStepCode(t, sc, c)
+		}
+		return copyStageCode(t, sc, c)
 	})
 	if err != nil {
 		return err
@@ -684,7 +847,7 @@ func deployStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error
 			}
 
 			err := s.RunStep(fmt.Sprintf("%s.%s.apply-%s", sc.Stage, bu, localStep), func() error {
-				return applyLocal(t, buOptions, sc.StageSA, c.PolicyPath, c.ValidatorProject)
+				return applyLocal(t, buOptions, sc.StageSA, c.PolicyPath, c.ValidatorProject, c.PlanOnly)
 			})
 			if err != nil {
 				return err
@@ -693,19 +856,51 @@ func deployStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error
 	}
 
 	err = s.RunStep(fmt.Sprintf("%s.plan", sc.Stage), func() error {
-		return planStage(t, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, sc.Executor)
+		return planStage(t, ctx, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, sc.Executor)
 	})
 	if err != nil {
 		return err
 	}
 
-	for _, env := range sc.Envs {
-		err = s.RunStep(fmt.Sprintf("%s.%s", sc.Stage, env), func() error {
-			aEnv := env
-			if env == "shared" {
-				aEnv = "production"
-			}
-			return applyEnv(t, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, aEnv, sc.Executor)
+	if c.PlanOnly {
+		fmt.Printf("# plan only mode, skipping apply of environments %v\n", sc.Envs)
+		fmt.Println("end of", sc.Step, "plan")
+		return nil
+	}
+
+	// the environments share the repository of the stage, only the waits for their builds run in parallel
+	var repoMu sync.Mutex
+	for _, batch := range envBatches(sc.Envs, c.Gates) {
+		err = runParallel(ctx, c.ParallelEnvs, batch, func(ctx context.Context, env string) error {
+			step := fmt.Sprintf("%s.%s", sc.Stage, env)
+			return s.RunStep(step, func() error {
+				repoMu.Lock()
+				defer repoMu.Unlock()
+				ctx := events.WithStep(ctx, step)
+				sc := envStageConf(t, ctx, sc, c, &repoMu)
+				aEnv := c.envBranch(env)
+				if c.Promotion == PromotionPullRequest {
+					return promoteEnv(t, ctx, sc, aEnv, c.AutoMerge)
+				}
+				if c.Upgrade {
+					err := promoteBranch(sc.GitConf, "plan", aEnv)
+					if err != nil {
+						return err
+					}
+				}
+				return applyEnv(t, ctx, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, aEnv, sc.Executor)
+			})
+		})
+		if err != nil {
+			return err
+		}
+		env := batch[len(batch)-1]
+		gate, ok := c.Gates[env]
+		if !ok || env == sc.Envs[len(sc.Envs)-1] {
+			continue
+		}
+		err = s.RunStep(fmt.Sprintf("%s.%s.gate", sc.Stage, env), func() error {
+			return NewGateChecker(c.DisablePrompt).Check(ctx, sc.Stage, env, gate)
 		})
 		if err != nil {
 			return err
@@ -716,6 +911,46 @@ func deployStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error
 	return nil
 }
 
+// copyStageCode copies the code of the stage to its repository.
+// runCopyCodeStep runs the step that copies the foundation code into the repository of the stage.
+// In plan only mode the code of a stage already copied is not copied again,
+// so the changes made in the repository are kept and the code in the repository is planned.
+func runCopyCodeStep(s steps.Steps, sc StageConf, c CommonConf, f func() error) error {
+	step := fmt.Sprintf("%s.copy-code", sc.Stage)
+	if c.PlanOnly && s.IsStepComplete(step) {
+		fmt.Printf("# plan only mode, skipping step '%s', the code in repository %s is planned\n", step, sc.Repo)
+		return nil
+	}
+	return s.RunStep(step, f)
+}
+
+func copyStageCode(t testing.TB, sc StageConf, c CommonConf) error {
+	err := copyStepCode(t, sc.GitConf, c.FoundationPath, c.CheckoutPath, sc.Repo, sc.Step, sc.CustomTargetDirPath, sc.BuildType, sc.ExcludeDirs)
+	if err != nil || sc.BuildType != BuildTypeJenkins {
+		return err
+	}
+	return updateJenkinsfile(filepath.Join(c.CheckoutPath, sc.Repo, "Jenkinsfile"), sc.StageSA, sc.StateBucket, sc.CICDProject)
+}
+
+// replaceBackendBuckets replaces the placeholders of the state buckets in the backend files of the foundation code.
+func replaceBackendBuckets(foundationPath, backendBucket, backendBucketProjects string) error {
+	files, err := utils.FindFiles(foundationPath, "backend.tf")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = utils.ReplaceStringInFile(file, "UPDATE_ME", backendBucket)
+		if err != nil {
+			return err
+		}
+		err = utils.ReplaceStringInFile(file, "UPDATE_PROJECTS_BACKEND", backendBucketProjects)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func preparePoliciesRepo(policiesConf utils.GitRepo, policiesBranch, foundationPath, gcpPoliciesPath string) error {
 	err := policiesConf.CheckoutBranch(policiesBranch)
 	if err != nil {
@@ -732,14 +967,14 @@ func preparePoliciesRepo(policiesConf utils.GitRepo, policiesBranch, foundationP
 	return policiesConf.PushBranch(policiesBranch, "origin")
 }
 
-func copyStepCode(t testing.TB, conf utils.GitRepo, foundationPath, checkoutPath, repo, step, customPath, buildType string) error {
+func copyStepCode(t testing.TB, conf utils.GitRepo, foundationPath, checkoutPath, repo, step, customPath, buildType string, excludeDirs []string) error {
 	gcpPath := filepath.Join(checkoutPath, repo)
 	targetDir := gcpPath
 	if customPath != "" {
 		targetDir = filepath.Join(gcpPath, customPath)
 	}
 
-	err := utils.CopyDirectory(filepath.Join(foundationPath, step), targetDir)
+	err := utils.CopyDirectoryExcluding(filepath.Join(foundationPath, step), targetDir, excludeDirs)
 	if err != nil {
 		return err
 	}
@@ -784,6 +1019,13 @@ func copyCICDConfig(t testing.TB, conf utils.GitRepo, foundationPath, checkoutPa
 		if err != nil {
 			return err
 		}
+	case BuildTypeJenkins:
+		err = utils.CopyFile(filepath.Join(foundationPath, "build/Jenkinsfile"), filepath.Join(gcpPath, "Jenkinsfile"))
+		if err != nil {
+			return err
+		}
+	case BuildTypeTFC:
+		// Terraform Cloud runs are triggered by the workspaces VCS connection
 	default: //BuildTypeCBCSR
 		err = utils.CopyFile(filepath.Join(foundationPath, "build/cloudbuild-tf-apply.yaml"), filepath.Join(gcpPath, "cloudbuild-tf-apply.yaml"))
 		if err != nil {
@@ -798,9 +1040,24 @@ func copyCICDConfig(t testing.TB, conf utils.GitRepo, foundationPath, checkoutPa
 	return utils.CopyFile(filepath.Join(foundationPath, "build/tf-wrapper.sh"), filepath.Join(gcpPath, "tf-wrapper.sh"))
 }
 
-func planStage(t testing.TB, conf utils.GitRepo, project, region, repo string, buildExecutor Executor) error {
+// updateJenkinsfile sets the values of the environment section of the Jenkinsfile of a repository
+func updateJenkinsfile(jenkinsfile, serviceAccount, stateBucket, cicdProject string) error {
+	for old, new := range map[string]string{
+		"TERRAFORM_SA_EMAIL":        serviceAccount,
+		"BACKEND_STATE_BUCKET_NAME": stateBucket,
+		"CICD_PROJECT_ID":           cicdProject,
+	} {
+		err := utils.ReplaceStringInFile(jenkinsfile, old, new)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func planStage(t testing.TB, ctx context.Context, conf utils.GitRepo, project, region, repo string, buildExecutor Executor) error {
 
-	err := conf.CommitFiles(fmt.Sprintf("Initialize %s repo", repo))
+	err := conf.CommitFiles(initCommitMsg(repo))
 	if err != nil {
 		return err
 	}
@@ -814,7 +1071,7 @@ func planStage(t testing.TB, conf utils.GitRepo, project, region, repo string, b
 		return err
 	}
 
-	return buildExecutor.WaitBuildSuccess(t, commitSha, fmt.Sprintf("Terraform %s plan build Failed.", repo))
+	return buildExecutor.WaitBuildSuccess(t, ctx, "plan", commitSha, fmt.Sprintf("Terraform %s plan build Failed.", repo))
 }
 
 func saveBootstrapCodeOnly(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error {
@@ -824,15 +1081,15 @@ func saveBootstrapCodeOnly(t testing.TB, sc StageConf, s steps.Steps, c CommonCo
 		return err
 	}
 
-	err = s.RunStep(fmt.Sprintf("%s.copy-code", sc.Stage), func() error {
-		return copyStepCode(t, sc.GitConf, c.FoundationPath, c.CheckoutPath, sc.Repo, sc.Step, sc.CustomTargetDirPath, sc.BuildType)
+	err = runCopyCodeStep(s, sc, c, func() error {
+		return copyStepCode(t, sc.GitConf, c.FoundationPath, c.CheckoutPath, sc.Repo, sc.Step, sc.CustomTargetDirPath, sc.BuildType, sc.ExcludeDirs)
 	})
 	if err != nil {
 		return err
 	}
 
 	err = s.RunStep(fmt.Sprintf("%s.plan", sc.Stage), func() error {
-		err := sc.GitConf.CommitFiles(fmt.Sprintf("Initialize %s repo", sc.Repo))
+		err := sc.GitConf.CommitFiles(initCommitMsg(sc.Repo))
 		if err != nil {
 			return err
 		}
@@ -843,12 +1100,14 @@ func saveBootstrapCodeOnly(t testing.TB, sc StageConf, s steps.Steps, c CommonCo
 		return err
 	}
 
+	if c.PlanOnly {
+		fmt.Printf("# plan only mode, skipping push of environments %v\n", sc.Envs)
+		return nil
+	}
+
 	for _, env := range sc.Envs {
 		err = s.RunStep(fmt.Sprintf("%s.%s", sc.Stage, env), func() error {
-			aEnv := env
-			if env == "shared" {
-				aEnv = "production"
-			}
+			aEnv := c.envBranch(env)
 			err := sc.GitConf.CheckoutBranch(aEnv)
 			if err != nil {
 				return err
@@ -864,7 +1123,7 @@ func saveBootstrapCodeOnly(t testing.TB, sc StageConf, s steps.Steps, c CommonCo
 	return nil
 }
 
-func applyEnv(t testing.TB, conf utils.GitRepo, project, region, repo, environment string, buildExecutor Executor) error {
+func applyEnv(t testing.TB, ctx context.Context, conf utils.GitRepo, project, region, repo, environment string, buildExecutor Executor) error {
 	err := conf.CheckoutBranch(environment)
 	if err != nil {
 		return err
@@ -878,10 +1137,78 @@ func applyEnv(t testing.TB, conf utils.GitRepo, project, region, repo, environme
 		return err
 	}
 
-	return buildExecutor.WaitBuildSuccess(t, commitSha, fmt.Sprintf("Terraform %s apply %s build Failed.", repo, environment))
+	return buildExecutor.WaitBuildSuccess(t, ctx, environment, commitSha, fmt.Sprintf("Terraform %s apply %s build Failed.", repo, environment))
 }
 
-func applyLocal(t testing.TB, options *terraform.Options, serviceAccount, policyPath, validatorProjectID string) error {
+// promoteEnv opens a pull or merge request from the plan branch to the environment branch, waits for it to be merged,
+// and waits for the apply build of the merged code.
+// Environment branches that do not exist in the remote repository yet are created pushing them.
+func promoteEnv(t testing.TB, ctx context.Context, sc StageConf, env string, autoMerge bool) error {
+	conf := sc.GitConf
+	exists, err := conf.HasRemoteBranch(env, "origin")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		utils.Printf(ctx, "# branch %s does not exist in repository %s, pushing it\n", env, sc.Repo)
+		return applyEnv(t, ctx, conf, sc.CICDProject, sc.DefaultRegion, sc.Repo, env, sc.Executor)
+	}
+	promoter, ok := sc.Executor.(Promoter)
+	if !ok {
+		return fmt.Errorf("promotion with pull requests is not supported for build type %s", sc.BuildType)
+	}
+	err = conf.Fetch("origin")
+	if err != nil {
+		return err
+	}
+	merged, err := conf.IsMerged("plan", "origin/"+env)
+	if err != nil {
+		return err
+	}
+	if merged {
+		utils.Printf(ctx, "# branch plan is already merged into branch %s in repository %s\n", env, sc.Repo)
+	} else {
+		err = promoter.Promote(t, ctx, "plan", env, fmt.Sprintf("Deploy %s to %s", sc.Repo, env), autoMerge)
+		if err != nil {
+			return err
+		}
+		err = conf.Fetch("origin")
+		if err != nil {
+			return err
+		}
+	}
+	// keep the local environment branch in sync with the merged code
+	err = conf.CheckoutRemoteBranch(env, "origin")
+	if err != nil {
+		return err
+	}
+	commitSha, err := conf.GetCommitSha()
+	if err != nil {
+		return err
+	}
+	return sc.Executor.WaitBuildSuccess(t, ctx, env, commitSha, fmt.Sprintf("Terraform %s apply %s build Failed.", sc.Repo, env))
+}
+
+// terraformVersion returns the version of the local Terraform CLI.
+// It is used in the Terraform Cloud workspaces so that the state migration works.
+func terraformVersion(t testing.TB) (string, error) {
+	out, err := terraform.RunTerraformCommandAndGetStdoutE(t, &terraform.Options{Logger: logger.Discard}, "version", "-json")
+	if err != nil {
+		return "", err
+	}
+	var v struct {
+		TerraformVersion string `json:"terraform_version"`
+	}
+	err = json.Unmarshal([]byte(out), &v)
+	if err != nil {
+		return "", fmt.Errorf("failed to read terraform version: %w", err)
+	}
+	return v.TerraformVersion, nil
+}
+
+// applyLocal runs terraform init, plan, and apply in the given directory.
+// If planOnly is true the apply is not executed.
+func applyLocal(t testing.TB, options *terraform.Options, serviceAccount, policyPath, validatorProjectID string, planOnly bool) error {
 	var err error
 
 	if serviceAccount != "" {
@@ -908,9 +1235,11 @@ func applyLocal(t testing.TB, options *terraform.Options, serviceAccount, policy
 		}
 	}
 
-	_, err = terraform.ApplyE(t, options)
-	if err != nil {
-		return err
+	if !planOnly {
+		_, err = terraform.ApplyE(t, options)
+		if err != nil {
+			return err
+		}
 	}
 
 	if serviceAccount != "" {
@@ -0,0 +1,158 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/git"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+// fakeBuilds records the builds waited for.
+type fakeBuilds struct {
+	builds []string
+}
+
+func (e *fakeBuilds) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	e.builds = append(e.builds, commitSha)
+	return nil
+}
+
+// fakePromoter merges the branches in the origin repository.
+type fakePromoter struct {
+	fakeBuilds
+	origin   utils.GitRepo
+	promoted []string
+}
+
+func (e *fakePromoter) Promote(t testing.TB, ctx context.Context, from, env, title string, autoMerge bool) error {
+	e.promoted = append(e.promoted, from+"->"+env)
+	err := e.origin.CheckoutBranch(env)
+	if err != nil {
+		return err
+	}
+	return e.origin.MergeBranch(from, title)
+}
+
+func TestPromoteEnv(t *gotest.T) {
+	originPath := filepath.Join(t.TempDir(), "origin")
+	assert.NoError(t, os.MkdirAll(originPath, 0755))
+	originConf := git.NewCmdConfig(t, git.WithDir(originPath))
+	originConf.Init()
+	assert.NoError(t, os.WriteFile(filepath.Join(originPath, "README.md"), []byte("# Testing\n"), 0644))
+	originConf.AddAll()
+	originConf.CommitWithMsg("Initial commit", nil)
+	origin := utils.GetRepoOnly(t, originPath, logger.Discard)
+
+	repo := filepath.Join(t.TempDir(), OrgRepo)
+	conf := utils.GitClone(t, "Generic", OrgRepo, "file://"+originPath, repo, "", logger.Discard)
+	commitPlan := func(content string) {
+		assert.NoError(t, conf.CheckoutBranch("plan"))
+		assert.NoError(t, os.WriteFile(filepath.Join(repo, "main.tf"), []byte(content), 0644))
+		assert.NoError(t, conf.CommitFiles(content))
+		assert.NoError(t, conf.PushBranch("plan", "origin"))
+	}
+
+	executor := &fakePromoter{origin: origin}
+	sc := StageConf{Repo: OrgRepo, GitConf: conf, Executor: executor}
+
+	commitPlan("first")
+	assert.NoError(t, promoteEnv(t, context.Background(), sc, "development", false))
+	assert.Empty(t, executor.promoted, "missing environment branches should be pushed")
+	assert.Len(t, executor.builds, 1)
+
+	commitPlan("second")
+	assert.NoError(t, promoteEnv(t, context.Background(), sc, "development", false))
+	assert.Equal(t, []string{"plan->development"}, executor.promoted)
+	originSha, err := origin.GetCommitSha()
+	assert.NoError(t, err)
+	assert.Len(t, executor.builds, 2)
+	assert.Equal(t, originSha, executor.builds[1], "the build of the merged code should be waited for")
+	branch, err := conf.GetCurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "development", branch)
+	b, err := os.ReadFile(filepath.Join(repo, "main.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(b), "local branch should have the merged code")
+
+	assert.NoError(t, promoteEnv(t, context.Background(), sc, "development", false))
+	assert.Len(t, executor.promoted, 1, "merged branches should not be promoted again")
+
+	commitPlan("third")
+	sc.Executor = &fakeBuilds{}
+	sc.BuildType = BuildTypeCBCSR
+	err = promoteEnv(t, context.Background(), sc, "development", false)
+	assert.ErrorContains(t, err, "not supported for build type cb")
+}
+
+func TestDeployStagePlanOnly(t *gotest.T) {
+	foundation := t.TempDir()
+	for _, f := range []string{"build/cloudbuild-tf-apply.yaml", "build/cloudbuild-tf-plan.yaml", "build/tf-wrapper.sh"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(foundation, f)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(foundation, f), []byte(f), 0644))
+	}
+	mainTF := filepath.Join(foundation, OrgStep, "envs", "shared", "main.tf")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(mainTF), 0755))
+	assert.NoError(t, os.WriteFile(mainTF, []byte("foundation"), 0644))
+
+	originPath := filepath.Join(t.TempDir(), "origin")
+	assert.NoError(t, os.MkdirAll(originPath, 0755))
+	originConf := git.NewCmdConfig(t, git.WithDir(originPath))
+	originConf.Init()
+	assert.NoError(t, os.WriteFile(filepath.Join(originPath, "README.md"), []byte("# Testing\n"), 0644))
+	originConf.AddAll()
+	originConf.CommitWithMsg("Initial commit", nil)
+
+	checkout := t.TempDir()
+	repo := filepath.Join(checkout, OrgRepo)
+	conf := utils.GitClone(t, "Generic", OrgRepo, "file://"+originPath, repo, "", logger.Discard)
+	repoTF := filepath.Join(repo, "envs", "shared", "main.tf")
+	assert.NoError(t, conf.CheckoutBranch("plan"))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(repoTF), 0755))
+	assert.NoError(t, os.WriteFile(repoTF, []byte("team change"), 0644))
+	assert.NoError(t, conf.CommitFiles("team change"))
+
+	s, err := steps.LoadSteps(filepath.Join(t.TempDir(), "steps.json"))
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, s.Unlock()) }()
+	assert.NoError(t, s.CompleteStep(OrgRepo+".copy-code"))
+	s.PlanOnly = true
+
+	executor := &fakeBuilds{}
+	sc := StageConf{Stage: OrgRepo, Step: OrgStep, Repo: OrgRepo, GitConf: conf, Executor: executor, BuildType: BuildTypeCBCSR}
+	c := CommonConf{FoundationPath: foundation, CheckoutPath: checkout, PlanOnly: true, Logger: logger.Discard}
+	assert.NoError(t, deployStage(t, context.Background(), sc, s, c))
+	b, err := os.ReadFile(repoTF)
+	assert.NoError(t, err)
+	assert.Equal(t, "team change", string(b), "the code of a stage already copied should not be overwritten")
+	assert.Len(t, executor.builds, 1, "the code in the repository should be planned")
+
+	s.PlanOnly = false
+	assert.NoError(t, s.ResetStepRecursive(OrgRepo+".copy-code"))
+	s.PlanOnly = true
+	assert.NoError(t, deployStage(t, context.Background(), sc, s, c))
+	b, err = os.ReadFile(repoTF)
+	assert.NoError(t, err)
+	assert.Equal(t, "foundation", string(b), "the code of a stage not copied yet should be copied")
+}
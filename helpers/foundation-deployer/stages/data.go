@@ -16,9 +16,13 @@ package stages
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gruntwork-io/terratest/modules/logger"
@@ -36,6 +40,9 @@ const (
 	NetworksRepo              = "gcp-networks"
 	ProjectsRepo              = "gcp-projects"
 	AppInfraRepo              = "bu1-example-app"
+	DefaultBusinessUnit       = "business_unit_1"
+	AppInfraPoliciesRepo      = "gcp-policies-app-infra"
+	SharedEnv                 = "shared"
 	BootstrapStep             = "0-bootstrap"
 	OrgStep                   = "1-org"
 	EnvironmentsStep          = "2-environments"
@@ -46,11 +53,16 @@ const (
 	MaxErrorRetries           = 2
 	TimeBetweenErrorRetries   = 2 * time.Minute
 	MaxBuildRetries           = 40
+	MaxReviewRetries          = 540
 	BuildTypeCBCSR            = "cb"
 	BuildTypeGiHub            = "github"
 	BuildTypeGitLab           = "gitlab"
+	BuildTypeJenkins          = "jenkins"
+	BuildTypeTFC              = "terraform_cloud"
 	CloudBuildProjectIdOutput = "cloudbuild_project_id"
 	CICDProjectIdOutput       = "cicd_project_id"
+	PromotionPush             = "push"
+	PromotionPullRequest      = "pull_request"
 )
 
 type CommonConf struct {
@@ -61,8 +73,84 @@ type CommonConf struct {
 	BuildType         string
 	EnableHubAndSpoke bool
 	DisablePrompt     bool
+	PlanOnly          bool
+	Upgrade           bool
+	Promotion         string
+	AutoMerge         bool
+	Gates             map[string]PromotionGate
+	ParallelEnvs      int
 	Logger            *logger.Logger
 	GitToken          string
+	GitBaseURL        string
+	GitAPIURL         string
+	JenkinsToken      string
+	TFCToken          string
+	BusinessUnits     []BusinessUnit
+	Envs              []string
+	ProductionEnv     string
+}
+
+var (
+	// DefaultEnvironments are the environments of the foundation in deploy order.
+	DefaultEnvironments = []string{"production", "nonproduction", "development"}
+	// DefaultProductionEnvironment is the environment with the production networks.
+	DefaultProductionEnvironment = "production"
+)
+
+// SharedEnvBranch returns the branch used to apply the shared environment.
+// It is the branch of the production environment.
+func (c CommonConf) SharedEnvBranch() string {
+	return c.ProductionEnvironment()
+}
+
+// ProductionEnvironment returns the environment with the production networks, "production" by default.
+func (c CommonConf) ProductionEnvironment() string {
+	if c.ProductionEnv == "" {
+		return DefaultProductionEnvironment
+	}
+	return c.ProductionEnv
+}
+
+// DeployEnvs returns the environments in deploy order.
+func (c CommonConf) DeployEnvs() []string {
+	if len(c.Envs) == 0 {
+		return append([]string{}, DefaultEnvironments...)
+	}
+	return append([]string{}, c.Envs...)
+}
+
+// DestroyEnvs returns the environments in destroy order, the reverse of the deploy order.
+func (c CommonConf) DestroyEnvs() []string {
+	envs := c.DeployEnvs()
+	slices.Reverse(envs)
+	return envs
+}
+
+// GitProviderEnvVars returns the environment variables that configure the Terraform GitHub or GitLab provider
+// used in 0-bootstrap to manage the repositories of a self-hosted instance.
+func (c CommonConf) GitProviderEnvVars(provider string) map[string]string {
+	envVars := map[string]string{}
+	switch {
+	case provider == BuildTypeGiHub && (c.GitAPIURL != "" || (c.GitBaseURL != "" && c.GitBaseURL != utils.DefaultGitHubURL)):
+		envVars["GITHUB_BASE_URL"] = c.GitAPIURL
+		if c.GitAPIURL == "" {
+			envVars["GITHUB_BASE_URL"] = c.GitBaseURL + "/"
+		}
+	case provider == BuildTypeGitLab && (c.GitAPIURL != "" || (c.GitBaseURL != "" && c.GitBaseURL != utils.DefaultGitLabURL)):
+		envVars["GITLAB_BASE_URL"] = c.GitAPIURL
+		if c.GitAPIURL == "" {
+			envVars["GITLAB_BASE_URL"] = c.GitBaseURL + "/api/v4/"
+		}
+	}
+	return envVars
+}
+
+// envBranch returns the branch used to apply the given environment.
+func (c CommonConf) envBranch(env string) string {
+	if env == SharedEnv {
+		return c.SharedEnvBranch()
+	}
+	return env
 }
 
 type StageConf struct {
@@ -73,11 +161,13 @@ type StageConf struct {
 	Step                string
 	Repo                string
 	CustomTargetDirPath string
+	ExcludeDirs         []string
 	GitConf             utils.GitRepo
 	HasLocalStep        bool
 	GroupingUnits       []string
 	Envs                []string
 	LocalSteps          []string
+	StateBucket         string
 	BuildType           string
 	Executor            Executor
 }
@@ -152,6 +242,71 @@ type GitRepos struct {
 	CICDRunner   *string `cty:"cicd_runner"`
 }
 
+// BusinessUnit is the element for BusinessUnits
+type BusinessUnit struct {
+	Name         string `cty:"name"`
+	AppInfraRepo string `cty:"app_infra_repo"`
+}
+
+// TFCConf is the configuration of the Terraform Cloud organization used to deploy the foundation
+type TFCConf struct {
+	Organization    string `cty:"organization"`
+	VCSProvider     string `cty:"vcs_provider"`
+	VCSOauthTokenID string `cty:"vcs_oauth_token_id"`
+}
+
+// RepoURL returns the URL of a repository in the VCS provider connected to Terraform Cloud
+func (c TFCConf) RepoURL(baseURL, owner, repo, token string) string {
+	if c.VCSProvider == BuildTypeGitLab {
+		return utils.BuildGitLabURL(baseURL, owner, repo, token)
+	}
+	return utils.BuildGitHubURL(baseURL, owner, repo, token)
+}
+
+// TFCWorkspaces returns the Terraform Cloud workspaces created in 0-bootstrap for the given step.
+func TFCWorkspaces(step string, envs []string, bus []BusinessUnit) []string {
+	switch step {
+	case BootstrapStep:
+		return []string{"0-shared"}
+	case OrgStep:
+		return []string{"1-shared"}
+	case EnvironmentsStep:
+		return tfcEnvWorkspaces("2", envs, false)
+	case HubAndSpokeStep, SvpcStep:
+		return tfcEnvWorkspaces("3", envs, true)
+	case ProjectsStep:
+		ws := []string{}
+		for _, bu := range bus {
+			ws = append(ws, tfcEnvWorkspaces(fmt.Sprintf("4-%s", strings.Replace(bu.Name, "business_unit_", "bu", 1)), envs, true)...)
+		}
+		return ws
+	}
+	return nil
+}
+
+// tfcEnvWorkspaces returns the names of the workspaces of each environment with the given prefix
+func tfcEnvWorkspaces(prefix string, envs []string, shared bool) []string {
+	ws := []string{}
+	if shared {
+		ws = append(ws, fmt.Sprintf("%s-%s", prefix, SharedEnv))
+	}
+	for _, e := range envs {
+		ws = append(ws, fmt.Sprintf("%s-%s", prefix, e))
+	}
+	return ws
+}
+
+// VCSRepos is the configuration of the repositories connected to the Terraform Cloud workspaces.
+// The repositories are in the format REPO-OWNER/REPO-NAME
+type VCSRepos struct {
+	Owner        string `cty:"owner"`
+	Bootstrap    string `cty:"bootstrap"`
+	Organization string `cty:"organization"`
+	Environments string `cty:"environments"`
+	Networks     string `cty:"networks"`
+	Projects     string `cty:"projects"`
+}
+
 type GitHubRepos struct {
 	Owner        string `cty:"owner"`
 	Bootstrap    string `cty:"bootstrap"`
@@ -171,6 +326,37 @@ type GitLabRepos struct {
 	CICDRunner   string `cty:"cicd_runner"`
 }
 
+// JenkinsConf is the configuration of the Jenkins Controller and of the Jenkins Agent created in 0-bootstrap
+type JenkinsConf struct {
+	URL                           string   `cty:"url"`
+	User                          string   `cty:"user"`
+	GitBaseURL                    string   `cty:"git_base_url"`
+	JobsFolder                    string   `cty:"jobs_folder"`
+	AgentGCESubnetworkCIDRRange   string   `cty:"agent_gce_subnetwork_cidr_range"`
+	AgentGCEPrivateIPAddress      string   `cty:"agent_gce_private_ip_address"`
+	AgentGCESSHPubKey             string   `cty:"agent_gce_ssh_pub_key"`
+	AgentSAEmail                  string   `cty:"agent_sa_email"`
+	ControllerSubnetworkCIDRRange []string `cty:"controller_subnetwork_cidr_range"`
+	NatBGPASN                     int      `cty:"nat_bgp_asn"`
+	VPNSharedSecret               string   `cty:"vpn_shared_secret"`
+	OnPremVPNPublicIPAddress      string   `cty:"on_prem_vpn_public_ip_address"`
+	OnPremVPNPublicIPAddress2     string   `cty:"on_prem_vpn_public_ip_address2"`
+	RouterASN                     int      `cty:"router_asn"`
+	BGPPeerASN                    int      `cty:"bgp_peer_asn"`
+	Tunnel0BGPPeerAddress         string   `cty:"tunnel0_bgp_peer_address"`
+	Tunnel0BGPSessionRange        string   `cty:"tunnel0_bgp_session_range"`
+	Tunnel1BGPPeerAddress         string   `cty:"tunnel1_bgp_peer_address"`
+	Tunnel1BGPSessionRange        string   `cty:"tunnel1_bgp_session_range"`
+}
+
+// JobName returns the full name of the multibranch pipeline job of the given repository
+func (j JenkinsConf) JobName(repo string) string {
+	if j.JobsFolder == "" {
+		return repo
+	}
+	return fmt.Sprintf("%s/%s", strings.Trim(j.JobsFolder, "/"), repo)
+}
+
 // GlobalTFVars contains all the configuration for the deploy
 type GlobalTFVars struct {
 	OrgID                                 string          `hcl:"org_id"`
@@ -210,6 +396,47 @@ type GlobalTFVars struct {
 	ProjectDeletionPolicy                 string          `hcl:"project_deletion_policy"`
 	BuildType                             string          `hcl:"build_type"`
 	GitRepos                              *GitRepos       `hcl:"git_repos"`
+	GitBaseURL                            *string         `hcl:"git_base_url"`
+	GitAPIURL                             *string         `hcl:"git_api_url"`
+	BusinessUnits                         *[]BusinessUnit `hcl:"business_units"`
+	Environments                          *[]string       `hcl:"environments"`
+	ProductionEnvironment                 *string         `hcl:"production_environment"`
+	Jenkins                               *JenkinsConf    `hcl:"jenkins"`
+	TFC                                   *TFCConf        `hcl:"tfc"`
+	PromotionGates                        *GateInputs     `hcl:"promotion_gates"`
+}
+
+// GitProvider returns the provider hosting the git repositories, "github" or "gitlab".
+// It is empty for build types that do not use GitHub or GitLab.
+func (g GlobalTFVars) GitProvider() string {
+	if g.BuildType == BuildTypeTFC && g.TFC != nil {
+		return g.TFC.VCSProvider
+	}
+	if g.BuildType == BuildTypeGiHub || g.BuildType == BuildTypeGitLab {
+		return g.BuildType
+	}
+	return ""
+}
+
+// GetGitBaseURL returns the URL of the GitHub or GitLab instance hosting the repositories.
+// The default is github.com or gitlab.com based on the git provider.
+func (g GlobalTFVars) GetGitBaseURL() string {
+	if g.GitBaseURL != nil && *g.GitBaseURL != "" {
+		return strings.TrimSuffix(*g.GitBaseURL, "/")
+	}
+	if g.GitProvider() == BuildTypeGitLab {
+		return utils.DefaultGitLabURL
+	}
+	return utils.DefaultGitHubURL
+}
+
+// GetGitAPIURL returns the API URL of the GitHub or GitLab instance.
+// If empty, the API URL is derived from the base URL.
+func (g GlobalTFVars) GetGitAPIURL() string {
+	if g.GitAPIURL == nil {
+		return ""
+	}
+	return *g.GitAPIURL
 }
 
 // HasValidatorProj checks if a Validator Project was provided
@@ -232,14 +459,117 @@ func (g GlobalTFVars) HasOptionalGroupsCreation() bool {
 	return (*g.Groups.CreateOptionalGroups)
 }
 
-// CheckString checks if any of the string fields in the GlobalTFVars has the given string
-func (g GlobalTFVars) CheckString(s string) {
-	f := reflect.ValueOf(g)
-	for i := 0; i < f.NumField(); i++ {
-		if f.Field(i).Kind() == reflect.String && f.Field(i).Interface() == s {
-			fmt.Printf("# Replace value '%s' for input '%s'\n", s, f.Type().Field(i).Tag.Get("hcl"))
+// GetBusinessUnits returns the configured business units or the default business unit if none was provided
+func (g GlobalTFVars) GetBusinessUnits() []BusinessUnit {
+	if g.BusinessUnits == nil || len(*g.BusinessUnits) == 0 {
+		return []BusinessUnit{
+			{
+				Name:         DefaultBusinessUnit,
+				AppInfraRepo: AppInfraRepo,
+			},
+		}
+	}
+	return *g.BusinessUnits
+}
+
+// GetEnvironments returns the configured environments or the default environments if none was provided
+func (g GlobalTFVars) GetEnvironments() []string {
+	if g.Environments == nil || len(*g.Environments) == 0 {
+		return append([]string{}, DefaultEnvironments...)
+	}
+	return *g.Environments
+}
+
+// GetPromotionGates returns the gates of the promotion_gates input by environment.
+func (g GlobalTFVars) GetPromotionGates() (map[string]PromotionGate, error) {
+	gates := map[string]PromotionGate{}
+	if g.PromotionGates == nil {
+		return gates, nil
+	}
+	envs := g.GetEnvironments()
+	for _, env := range slices.Sorted(maps.Keys(*g.PromotionGates)) {
+		if !slices.Contains(envs, env) {
+			return nil, fmt.Errorf("promotion gate of unknown environment '%s', environments are: %s", env, strings.Join(envs, ", "))
+		}
+		gate, err := ParsePromotionGate((*g.PromotionGates)[env])
+		if err != nil {
+			return nil, fmt.Errorf("promotion gate of environment '%s': %w", env, err)
+		}
+		gates[env] = gate
+	}
+	return gates, nil
+}
+
+// GetProductionEnvironment returns the configured production environment or the default production environment if none was provided
+func (g GlobalTFVars) GetProductionEnvironment() string {
+	if g.ProductionEnvironment == nil || *g.ProductionEnvironment == "" {
+		return DefaultProductionEnvironment
+	}
+	return *g.ProductionEnvironment
+}
+
+// CheckString returns an issue with the HCL path of each string in the GlobalTFVars that contains the given string,
+// including the strings in nested blocks, lists, and maps.
+func (g GlobalTFVars) CheckString(s string) []ValidationIssue {
+	issues := []ValidationIssue{}
+	WalkStrings(g, func(path, value string) {
+		if strings.Contains(value, s) {
+			issues = append(issues, replaceIssue(path, s))
+		}
+	})
+	return issues
+}
+
+// WalkStrings calls f with the HCL path and the value of each string in v,
+// following pointers, structs, slices, and maps. Example path: "groups.required_groups.group_org_admins".
+// Struct fields without a hcl or cty tag are skipped.
+func WalkStrings(v interface{}, f func(path, value string)) {
+	walkStrings(reflect.ValueOf(v), "", f)
+}
+
+func walkStrings(v reflect.Value, path string, f func(path, value string)) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), path, f)
+		}
+	case reflect.String:
+		f(path, v.String())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := hclName(v.Type().Field(i))
+			if name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			walkStrings(v.Field(i), name, f)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), f)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			walkStrings(v.MapIndex(k), fmt.Sprintf("%s[%q]", path, fmt.Sprint(k)), f)
+		}
+	}
+}
+
+// hclName returns the name of the struct field in the hcl or cty tag.
+func hclName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	for _, tag := range []string{"hcl", "cty"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" && name != "-" {
+			return name
 		}
 	}
+	return ""
 }
 
 type BootstrapTfvars struct {
@@ -261,6 +591,26 @@ type BootstrapTfvars struct {
 	ProjectDeletionPolicy        string       `hcl:"project_deletion_policy"`
 	GitHubRepos                  *GitHubRepos `hcl:"gh_repos"`
 	GitLabRepos                  *GitLabRepos `hcl:"gl_repos"`
+	// Jenkins Agent inputs
+	JenkinsAgentGCESubnetworkCIDRRange   *string   `hcl:"jenkins_agent_gce_subnetwork_cidr_range"`
+	JenkinsAgentGCEPrivateIPAddress      *string   `hcl:"jenkins_agent_gce_private_ip_address"`
+	JenkinsAgentGCESSHPubKey             *string   `hcl:"jenkins_agent_gce_ssh_pub_key"`
+	JenkinsAgentSAEmail                  *string   `hcl:"jenkins_agent_sa_email"`
+	JenkinsControllerSubnetworkCIDRRange *[]string `hcl:"jenkins_controller_subnetwork_cidr_range"`
+	NatBGPASN                            *int      `hcl:"nat_bgp_asn"`
+	VPNSharedSecret                      *string   `hcl:"vpn_shared_secret"`
+	OnPremVPNPublicIPAddress             *string   `hcl:"on_prem_vpn_public_ip_address"`
+	OnPremVPNPublicIPAddress2            *string   `hcl:"on_prem_vpn_public_ip_address2"`
+	RouterASN                            *int      `hcl:"router_asn"`
+	BGPPeerASN                           *int      `hcl:"bgp_peer_asn"`
+	Tunnel0BGPPeerAddress                *string   `hcl:"tunnel0_bgp_peer_address"`
+	Tunnel0BGPSessionRange               *string   `hcl:"tunnel0_bgp_session_range"`
+	Tunnel1BGPPeerAddress                *string   `hcl:"tunnel1_bgp_peer_address"`
+	Tunnel1BGPSessionRange               *string   `hcl:"tunnel1_bgp_session_range"`
+	// Terraform Cloud inputs
+	VCSRepos            *VCSRepos `hcl:"vcs_repos"`
+	TFCOrgName          *string   `hcl:"tfc_org_name"`
+	TFCTerraformVersion *string   `hcl:"tfc_terraform_version"`
 }
 
 type OrgTfvars struct {
@@ -328,7 +678,8 @@ type AppInfraCommonTfvars struct {
 	ImageDigest       string `hcl:"confidential_image_digest"`
 }
 
-func GetBootstrapStepOutputs(t testing.TB, foundationPath string, buildType string) BootstrapOutputs {
+// GetBootstrapStepOutputs reads the outputs of the 0-bootstrap stage from its state.
+func GetBootstrapStepOutputs(t testing.TB, foundationPath string, buildType string) (BootstrapOutputs, error) {
 	options := &terraform.Options{
 		TerraformDir: filepath.Join(foundationPath, "0-bootstrap"),
 		Logger:       logger.Discard,
@@ -339,31 +690,61 @@ func GetBootstrapStepOutputs(t testing.TB, foundationPath string, buildType stri
 		cicdProjectIDOutput = CICDProjectIdOutput
 	}
 
-	return BootstrapOutputs{
-		CICDProject:               terraform.Output(t, options, cicdProjectIDOutput),
-		RemoteStateBucket:         terraform.Output(t, options, "gcs_bucket_tfstate"),
-		RemoteStateBucketProjects: terraform.Output(t, options, "projects_gcs_bucket_tfstate"),
-		DefaultRegion:             terraform.OutputMap(t, options, "common_config")["default_region"],
-		NetworkSA:                 terraform.Output(t, options, "networks_step_terraform_service_account_email"),
-		ProjectsSA:                terraform.Output(t, options, "projects_step_terraform_service_account_email"),
-		EnvsSA:                    terraform.Output(t, options, "environment_step_terraform_service_account_email"),
-		OrgSA:                     terraform.Output(t, options, "organization_step_terraform_service_account_email"),
-		BootstrapSA:               terraform.Output(t, options, "bootstrap_step_terraform_service_account_email"),
-		RequiredGroups:            terraform.OutputMap(t, options, "required_groups"),
+	var err error
+	output := func(name string) string {
+		if err != nil {
+			return ""
+		}
+		var value string
+		value, err = terraform.OutputE(t, options, name)
+		return value
 	}
+	outputMap := func(name string) map[string]string {
+		if err != nil {
+			return nil
+		}
+		var value map[string]string
+		value, err = terraform.OutputMapE(t, options, name)
+		return value
+	}
+	bo := BootstrapOutputs{
+		CICDProject:               output(cicdProjectIDOutput),
+		RemoteStateBucket:         output("gcs_bucket_tfstate"),
+		RemoteStateBucketProjects: output("projects_gcs_bucket_tfstate"),
+		DefaultRegion:             outputMap("common_config")["default_region"],
+		NetworkSA:                 output("networks_step_terraform_service_account_email"),
+		ProjectsSA:                output("projects_step_terraform_service_account_email"),
+		EnvsSA:                    output("environment_step_terraform_service_account_email"),
+		OrgSA:                     output("organization_step_terraform_service_account_email"),
+		BootstrapSA:               output("bootstrap_step_terraform_service_account_email"),
+		RequiredGroups:            outputMap("required_groups"),
+	}
+	if err != nil {
+		return BootstrapOutputs{}, err
+	}
+	return bo, nil
 }
 
-func GetInfraPipelineOutputs(t testing.TB, checkoutPath, workspace string) InfraPipelineOutputs {
+// bootstrapOutputsError explains an error reading the outputs of the 0-bootstrap stage.
+// In plan only mode the outputs are missing if the 0-bootstrap stage was never applied.
+func bootstrapOutputsError(c CommonConf, err error) error {
+	if c.PlanOnly {
+		return fmt.Errorf("the outputs of the %s stage are not available, plan only mode can only preview the stages after %s once %s is applied: %w", BootstrapStep, BootstrapStep, BootstrapStep, err)
+	}
+	return fmt.Errorf("error reading the outputs of the %s stage: %w", BootstrapStep, err)
+}
+
+func GetInfraPipelineOutputs(t testing.TB, checkoutPath, businessUnit, workspace string) InfraPipelineOutputs {
 	options := &terraform.Options{
-		TerraformDir: filepath.Join(checkoutPath, "gcp-projects", "business_unit_1", "shared"),
+		TerraformDir: filepath.Join(checkoutPath, ProjectsRepo, businessUnit, "shared"),
 		Logger:       logger.Discard,
 		NoColor:      true,
 	}
 	return InfraPipelineOutputs{
 		InfraPipeProj:                terraform.Output(t, options, "cloudbuild_project_id"),
 		DefaultRegion:                terraform.Output(t, options, "default_region"),
-		TerraformSA:                  terraform.OutputMap(t, options, "terraform_service_accounts")["bu1-example-app"],
-		StateBucket:                  terraform.OutputMap(t, options, "state_buckets")["bu1-example-app"],
+		TerraformSA:                  terraform.OutputMap(t, options, "terraform_service_accounts")[workspace],
+		StateBucket:                  terraform.OutputMap(t, options, "state_buckets")[workspace],
 		ImageName:                    terraform.Output(t, options, "image_name"),
 		BootstrapCloudbuildProjectID: terraform.Output(t, options, "bootstrap_cloudbuild_project_id"),
 	}
@@ -386,6 +767,15 @@ func ReadGlobalTFVars(file string) (GlobalTFVars, error) {
 	return globalTfvars, nil
 }
 
+// GroupingUnits returns the names of the business units to be used as grouping units
+func GroupingUnits(bus []BusinessUnit) []string {
+	units := []string{}
+	for _, bu := range bus {
+		units = append(units, bu.Name)
+	}
+	return units
+}
+
 func GetNetworkStep(enableHubAndSpoke bool) string {
 	if enableHubAndSpoke {
 		return HubAndSpokeStep
@@ -15,9 +15,12 @@
 package stages
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/mitchellh/go-testing-interface"
@@ -31,7 +34,7 @@ var (
 	emptyEnvVars = map[string]string{}
 )
 
-func DestroyBootstrapStage(t testing.TB, s steps.Steps, c CommonConf, envVars map[string]string) error {
+func DestroyBootstrapStage(t testing.TB, ctx context.Context, s steps.Steps, c CommonConf, envVars map[string]string) error {
 
 	if err := forceBackendMigration(t, BootstrapRepo, "envs", "shared", c, envVars); err != nil {
 		return err
@@ -44,7 +47,7 @@ func DestroyBootstrapStage(t testing.TB, s steps.Steps, c CommonConf, envVars ma
 		GroupingUnits: []string{"envs"},
 		Envs:          []string{"shared"},
 	}
-	return destroyStage(t, stageConf, s, c, envVars)
+	return destroyStage(t, ctx, stageConf, s, c, envVars)
 }
 
 // forceBackendMigration removes backend.tf file to force migration of the
@@ -89,7 +92,7 @@ func forceBackendMigration(t testing.TB, repo, groupUnit, env string, c CommonCo
 	return nil
 }
 
-func DestroyOrgStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
+func DestroyOrgStage(t testing.TB, ctx context.Context, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
 	stageConf := StageConf{
 		Stage:         OrgRepo,
 		StageSA:       outputs.OrgSA,
@@ -99,10 +102,10 @@ func DestroyOrgStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs, c Co
 		GroupingUnits: []string{"envs"},
 		Envs:          []string{"shared"},
 	}
-	return destroyStage(t, stageConf, s, c, emptyEnvVars)
+	return destroyStage(t, ctx, stageConf, s, c, emptyEnvVars)
 }
 
-func DestroyEnvStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
+func DestroyEnvStage(t testing.TB, ctx context.Context, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
 	stageConf := StageConf{
 		Stage:         EnvironmentsRepo,
 		StageSA:       outputs.EnvsSA,
@@ -110,12 +113,12 @@ func DestroyEnvStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs, c Co
 		Step:          EnvironmentsStep,
 		Repo:          EnvironmentsRepo,
 		GroupingUnits: []string{"envs"},
-		Envs:          []string{"development", "nonproduction", "production"},
+		Envs:          c.DestroyEnvs(),
 	}
-	return destroyStage(t, stageConf, s, c, emptyEnvVars)
+	return destroyStage(t, ctx, stageConf, s, c, emptyEnvVars)
 }
 
-func DestroyNetworksStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
+func DestroyNetworksStage(t testing.TB, ctx context.Context, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
 	step := GetNetworkStep(c.EnableHubAndSpoke)
 	stageConf := StageConf{
 		Stage:         NetworksRepo,
@@ -125,12 +128,12 @@ func DestroyNetworksStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs,
 		Repo:          NetworksRepo,
 		HasLocalStep:  true,
 		GroupingUnits: []string{"envs"},
-		Envs:          []string{"development", "nonproduction", "production"},
+		Envs:          c.DestroyEnvs(),
 	}
-	return destroyStage(t, stageConf, s, c, emptyEnvVars)
+	return destroyStage(t, ctx, stageConf, s, c, emptyEnvVars)
 }
 
-func DestroyProjectsStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
+func DestroyProjectsStage(t testing.TB, ctx context.Context, s steps.Steps, outputs BootstrapOutputs, c CommonConf) error {
 	stageConf := StageConf{
 		Stage:         ProjectsRepo,
 		StageSA:       outputs.ProjectsSA,
@@ -138,58 +141,79 @@ func DestroyProjectsStage(t testing.TB, s steps.Steps, outputs BootstrapOutputs,
 		Step:          ProjectsStep,
 		Repo:          ProjectsRepo,
 		HasLocalStep:  true,
-		GroupingUnits: []string{"business_unit_1"},
-		Envs:          []string{"development", "nonproduction", "production"},
+		GroupingUnits: GroupingUnits(c.BusinessUnits),
+		Envs:          c.DestroyEnvs(),
 	}
-	return destroyStage(t, stageConf, s, c, emptyEnvVars)
+	return destroyStage(t, ctx, stageConf, s, c, emptyEnvVars)
 }
 
-func DestroyExampleAppStage(t testing.TB, s steps.Steps, outputs InfraPipelineOutputs, c CommonConf) error {
+func DestroyExampleAppStage(t testing.TB, ctx context.Context, s steps.Steps, bu BusinessUnit, outputs InfraPipelineOutputs, c CommonConf) error {
 	stageConf := StageConf{
-		Stage:         AppInfraRepo,
+		Stage:         bu.AppInfraRepo,
 		StageSA:       outputs.TerraformSA,
 		CICDProject:   outputs.InfraPipeProj,
 		Step:          AppInfraStep,
-		Repo:          AppInfraRepo,
-		GroupingUnits: []string{"business_unit_1"},
-		Envs:          []string{"development", "nonproduction", "production"},
+		Repo:          bu.AppInfraRepo,
+		GroupingUnits: []string{bu.Name},
+		Envs:          c.DestroyEnvs(),
 	}
-	return destroyStage(t, stageConf, s, c, emptyEnvVars)
+	return destroyStage(t, ctx, stageConf, s, c, emptyEnvVars)
 }
 
-func destroyStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf, envVars map[string]string) error {
+func destroyStage(t testing.TB, ctx context.Context, sc StageConf, s steps.Steps, c CommonConf, envVars map[string]string) error {
 	gcpPath := filepath.Join(c.CheckoutPath, sc.Repo)
-	for _, e := range sc.Envs {
-		err := s.RunDestroyStep(fmt.Sprintf("%s.%s", sc.Repo, e), func() error {
+	// environments destroyed in parallel use their own working tree of the repository
+	var repoMu sync.Mutex
+	err := runParallel(ctx, c.ParallelEnvs, sc.Envs, func(ctx context.Context, e string) error {
+		return s.RunDestroyStep(fmt.Sprintf("%s.%s", sc.Repo, e), func() error {
+			logger := utils.PrefixLogger(c.Logger, utils.LogPrefix(ctx))
+			conf := utils.GetRepoOnly(t, gcpPath, logger)
+			envPath := gcpPath
+			if c.ParallelEnvs > 1 {
+				envPath = filepath.Join(c.CheckoutPath, worktreesDir, sc.Repo, e)
+				repoMu.Lock()
+				err := conf.AddWorktree(envPath, c.envBranch(e))
+				repoMu.Unlock()
+				if err != nil {
+					return err
+				}
+				defer func() {
+					repoMu.Lock()
+					defer repoMu.Unlock()
+					if err := conf.RemoveWorktree(envPath); err != nil {
+						utils.Printf(ctx, "# failed to remove working tree %s: %v\n", envPath, err)
+					}
+				}()
+			}
 			for _, g := range sc.GroupingUnits {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
 				options := &terraform.Options{
-					TerraformDir:             filepath.Join(gcpPath, g, e),
-					Logger:                   c.Logger,
+					TerraformDir:             filepath.Join(envPath, g, e),
+					Logger:                   logger,
 					NoColor:                  true,
 					RetryableTerraformErrors: testutils.RetryableTransientErrors,
 					MaxRetries:               MaxErrorRetries,
 					TimeBetweenRetries:       TimeBetweenErrorRetries,
 					EnvVars:                  envVars,
 				}
-				conf := utils.GetRepoOnly(t, gcpPath, c.Logger)
-				branch := e
-				if branch == "shared" {
-					branch = "production"
-				}
-				err := conf.CheckoutBranch(branch)
-				if err != nil {
-					return err
+				if c.ParallelEnvs <= 1 {
+					err := conf.CheckoutBranch(c.envBranch(e))
+					if err != nil {
+						return err
+					}
 				}
-				err = destroyEnv(t, options, sc.StageSA)
+				err := destroyEnv(t, options, sc.StageSA)
 				if err != nil {
 					return err
 				}
 			}
 			return nil
 		})
-		if err != nil {
-			return err
-		}
+	})
+	if err != nil {
+		return err
 	}
 	groupingUnits := []string{}
 	if sc.HasLocalStep {
@@ -207,7 +231,7 @@ func destroyStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf, envVa
 				EnvVars:                  envVars,
 			}
 			conf := utils.GetRepoOnly(t, gcpPath, c.Logger)
-			err := conf.CheckoutBranch("production")
+			err := conf.CheckoutBranch(c.SharedEnvBranch())
 			if err != nil {
 				return err
 			}
@@ -222,30 +246,23 @@ func destroyStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf, envVa
 	return nil
 }
 
+// destroyEnv runs terraform init and destroy impersonating the service account.
+// The service account is set in the environment variables of the terraform commands,
+// not in the environment of the helper, so environments can be destroyed in parallel.
 func destroyEnv(t testing.TB, options *terraform.Options, serviceAccount string) error {
-	var err error
-
 	if serviceAccount != "" {
-		err = os.Setenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT", serviceAccount)
-		if err != nil {
-			return err
+		envVars := maps.Clone(options.EnvVars)
+		if envVars == nil {
+			envVars = map[string]string{}
 		}
+		envVars["GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"] = serviceAccount
+		options.EnvVars = envVars
 	}
 
-	_, err = terraform.InitE(t, options)
+	_, err := terraform.InitE(t, options)
 	if err != nil {
 		return err
 	}
 	_, err = terraform.DestroyE(t, options)
-	if err != nil {
-		return err
-	}
-
-	if serviceAccount != "" {
-		err = os.Unsetenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT")
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return err
 }
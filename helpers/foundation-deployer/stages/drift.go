@@ -0,0 +1,287 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/msg"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+	"github.com/terraform-google-modules/terraform-example-foundation/test/integration/testutils"
+)
+
+const (
+	// planNoChanges is the terraform plan -detailed-exitcode exit code when there are no changes.
+	planNoChanges = 0
+	// planHasChanges is the terraform plan -detailed-exitcode exit code when there are changes.
+	planHasChanges = 2
+)
+
+// ResourceChange is a change of a resource in a terraform plan.
+type ResourceChange struct {
+	Address string   `json:"address"`
+	Actions []string `json:"actions"`
+}
+
+// DriftResult is the result of the drift detection in a terraform directory of a stage.
+type DriftResult struct {
+	Stage   string           `json:"stage"`
+	Repo    string           `json:"repo"`
+	Env     string           `json:"env"`
+	Dir     string           `json:"dir"`
+	Drifted bool             `json:"drifted"`
+	Changes []ResourceChange `json:"changes,omitempty"`
+	// ChangesUnavailable is true if drift was found but the resource changes are not known,
+	// Terraform Cloud does not save the plans of remote runs.
+	ChangesUnavailable bool   `json:"changes_unavailable,omitempty"`
+	Error              string `json:"error,omitempty"`
+}
+
+// Summary returns the number of resources to be added, changed, and destroyed to remove the drift.
+func (d DriftResult) Summary() string {
+	if d.ChangesUnavailable {
+		return "no resource summary available"
+	}
+	var add, change, destroy int
+	for _, c := range d.Changes {
+		for _, a := range c.Actions {
+			switch a {
+			case "create":
+				add++
+			case "update":
+				change++
+			case "delete":
+				destroy++
+			}
+		}
+	}
+	return fmt.Sprintf("%d to add, %d to change, %d to destroy", add, change, destroy)
+}
+
+// HasDrift checks if drift was found in any of the results.
+func HasDrift(results []DriftResult) bool {
+	for _, r := range results {
+		if r.Drifted {
+			return true
+		}
+	}
+	return false
+}
+
+// driftPlanFunc runs terraform plan in the directory of an environment of a stage checked out in the given branch
+// and returns the resource changes and if the plan has changes.
+// The resource changes are nil if the plan has changes that cannot be listed.
+type driftPlanFunc func(t testing.TB, c CommonConf, sc StageConf, dir, branch string, envVars map[string]string) ([]ResourceChange, bool, error)
+
+// Drift runs terraform plan in the environments of the completed steps of the selected stages in deploy order
+// and reports the resources changed outside of the foundation code.
+// A failed plan is reported in the result of its directory and does not stop the drift detection.
+func (r *Registry) Drift(t testing.TB, ctx context.Context, s steps.Steps, rc *RunConf, selected map[string]bool) ([]DriftResult, error) {
+	return r.drift(t, ctx, s, rc, selected, planDrift)
+}
+
+func (r *Registry) drift(t testing.TB, ctx context.Context, s steps.Steps, rc *RunConf, selected map[string]bool, plan driftPlanFunc) ([]DriftResult, error) {
+	order, err := r.DeployOrder()
+	if err != nil {
+		return nil, err
+	}
+	results := []DriftResult{}
+	for _, st := range order {
+		if !selected[st.Name] || !st.enabled(rc) || st.Drift == nil {
+			continue
+		}
+		// the stage configurations may read the outputs of the deployment
+		if !anyStepComplete(s, st.steps(rc.Common)) {
+			fmt.Printf("# Skipping %s stage. It is not deployed.\n", st.Name)
+			continue
+		}
+		msg.PrintStageMsg(fmt.Sprintf("Checking drift of %s stage", st.Name))
+		for _, sc := range st.Drift(t, rc) {
+			// the step of each stage configuration is named after its repository
+			if !s.IsStepComplete(sc.Repo) {
+				fmt.Printf("# Skipping %s. Step %s is not completed.\n", sc.Repo, sc.Repo)
+				continue
+			}
+			for _, d := range driftDirs(rc.Common, sc) {
+				if ctx.Err() != nil {
+					return results, ctx.Err()
+				}
+				result := DriftResult{
+					Stage: st.Name,
+					Repo:  sc.Repo,
+					Env:   d.env,
+					Dir:   filepath.Join(sc.Repo, d.groupingUnit, d.env),
+				}
+				fmt.Printf("# Running terraform plan in %s\n", result.Dir)
+				changes, drifted, err := plan(t, rc.Common, sc, filepath.Join(rc.Common.CheckoutPath, result.Dir), d.branch, rc.EnvVars)
+				if err != nil {
+					result.Error = err.Error()
+				}
+				result.Drifted = drifted
+				result.Changes = changes
+				result.ChangesUnavailable = err == nil && drifted && changes == nil
+				results = append(results, result)
+			}
+		}
+	}
+	return results, nil
+}
+
+// anyStepComplete checks if any of the given steps is completed.
+func anyStepComplete(s steps.Steps, names []string) bool {
+	for _, n := range names {
+		if s.IsStepComplete(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// driftDir is a terraform directory of a stage checked for drift.
+type driftDir struct {
+	groupingUnit string
+	env          string
+	branch       string
+}
+
+// driftDirs returns the terraform directories of a stage in deploy order:
+// the shared environment of each grouping unit, when the stage has a local step, and then each environment.
+func driftDirs(c CommonConf, sc StageConf) []driftDir {
+	dirs := []driftDir{}
+	if sc.HasLocalStep {
+		for _, g := range sc.GroupingUnits {
+			dirs = append(dirs, driftDir{groupingUnit: g, env: SharedEnv, branch: c.SharedEnvBranch()})
+		}
+	}
+	for _, e := range sc.Envs {
+		for _, g := range sc.GroupingUnits {
+			dirs = append(dirs, driftDir{groupingUnit: g, env: e, branch: c.envBranch(e)})
+		}
+	}
+	return dirs
+}
+
+// planDrift checks out the environment branch and runs terraform plan with the detailed exit code
+// impersonating the stage service account.
+func planDrift(t testing.TB, c CommonConf, sc StageConf, dir, branch string, envVars map[string]string) ([]ResourceChange, bool, error) {
+	conf := utils.GetRepoOnly(t, filepath.Join(c.CheckoutPath, sc.Repo), c.Logger)
+	err := conf.CheckoutBranch(branch)
+	if err != nil {
+		return nil, false, err
+	}
+	options := &terraform.Options{
+		TerraformDir:             dir,
+		Logger:                   c.Logger,
+		NoColor:                  true,
+		RetryableTerraformErrors: testutils.RetryableTransientErrors,
+		MaxRetries:               MaxErrorRetries,
+		TimeBetweenRetries:       TimeBetweenErrorRetries,
+		EnvVars:                  envVars,
+	}
+	// Terraform Cloud does not support saving plans of remote runs
+	if c.BuildType != BuildTypeTFC {
+		tmp, err := os.MkdirTemp("", "drift")
+		if err != nil {
+			return nil, false, err
+		}
+		defer os.RemoveAll(tmp)
+		options.PlanFilePath = filepath.Join(tmp, "drift.tfplan")
+	}
+
+	if sc.StageSA != "" {
+		err = os.Setenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT", sc.StageSA)
+		if err != nil {
+			return nil, false, err
+		}
+		defer os.Unsetenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT")
+	}
+
+	_, err = terraform.InitE(t, options)
+	if err != nil {
+		return nil, false, err
+	}
+	exitCode, err := terraform.PlanExitCodeE(t, options)
+	if err != nil {
+		return nil, false, err
+	}
+	switch exitCode {
+	case planNoChanges:
+		return nil, false, nil
+	case planHasChanges:
+	default:
+		return nil, false, fmt.Errorf("terraform plan failed in %s with exit code %d", dir, exitCode)
+	}
+	if options.PlanFilePath == "" {
+		return nil, true, nil
+	}
+	plan, err := terraform.ShowWithStructE(t, options)
+	if err != nil {
+		return nil, true, err
+	}
+	return resourceChanges(plan), true, nil
+}
+
+// resourceChanges returns the changes of the plan sorted by resource address.
+// Resources without changes and data sources that are only read are ignored.
+func resourceChanges(plan *terraform.PlanStruct) []ResourceChange {
+	changes := []ResourceChange{}
+	for address, rc := range plan.ResourceChangesMap {
+		if rc.Change == nil {
+			continue
+		}
+		actions := []string{}
+		for _, a := range rc.Change.Actions {
+			if a == "no-op" || a == "read" {
+				continue
+			}
+			actions = append(actions, string(a))
+		}
+		if len(actions) > 0 {
+			changes = append(changes, ResourceChange{Address: address, Actions: actions})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Address < changes[j].Address
+	})
+	return changes
+}
+
+// DriftReport returns the text report of the drift detection results.
+func DriftReport(results []DriftResult) []string {
+	lines := []string{}
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			lines = append(lines, fmt.Sprintf("# %s %s: plan failed: %s", r.Stage, r.Dir, r.Error))
+		case r.Drifted:
+			lines = append(lines, fmt.Sprintf("# %s %s: drift detected, %s", r.Stage, r.Dir, r.Summary()))
+			for _, c := range r.Changes {
+				lines = append(lines, fmt.Sprintf("#   %s %s", strings.Join(c.Actions, ","), c.Address))
+			}
+		default:
+			lines = append(lines, fmt.Sprintf("# %s %s: no drift", r.Stage, r.Dir))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,110 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+)
+
+func TestRegistryDrift(t *gotest.T) {
+	executed := []string{}
+	r := NewRegistry()
+	org := testStage("org", &executed)
+	org.Drift = func(t testing.TB, r *RunConf) []StageConf {
+		return []StageConf{{Repo: "step-org", StageSA: "org-sa", GroupingUnits: []string{"envs"}, Envs: []string{SharedEnv}}}
+	}
+	assert.NoError(t, r.Register(org))
+	projects := testStage("projects", &executed, "org")
+	projects.Drift = func(t testing.TB, r *RunConf) []StageConf {
+		return []StageConf{{Repo: "step-projects", HasLocalStep: true, GroupingUnits: []string{"bu1"}, Envs: []string{"development", "production"}}}
+	}
+	assert.NoError(t, r.Register(projects))
+	notDeployed := testStage("apps", &executed, "projects")
+	notDeployed.Drift = func(t testing.TB, r *RunConf) []StageConf {
+		assert.Fail(t, "stages not deployed should not be checked")
+		return nil
+	}
+	assert.NoError(t, r.Register(notDeployed))
+
+	s, err := steps.LoadSteps(filepath.Join(t.TempDir(), "drift.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.CompleteStep("step-org"))
+	assert.NoError(t, s.CompleteStep("step-projects"))
+	c := CommonConf{CheckoutPath: "/checkout", Envs: []string{"development", "production"}}
+	rc := NewRunConf(GlobalTFVars{}, c, nil)
+
+	planned := []string{}
+	plan := func(t testing.TB, c CommonConf, sc StageConf, dir, branch string, envVars map[string]string) ([]ResourceChange, bool, error) {
+		planned = append(planned, fmt.Sprintf("%s@%s", dir, branch))
+		switch dir {
+		case "/checkout/step-org/envs/shared":
+			assert.Equal(t, "org-sa", sc.StageSA)
+			return []ResourceChange{{Address: "google_project.a", Actions: []string{"update"}}}, true, nil
+		case "/checkout/step-projects/bu1/development":
+			return nil, true, nil
+		case "/checkout/step-projects/bu1/production":
+			return nil, false, fmt.Errorf("plan failed")
+		}
+		return nil, false, nil
+	}
+	all, err := r.Select("", "", "")
+	assert.NoError(t, err)
+	results, err := r.drift(t, context.Background(), s, rc, all, plan)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/checkout/step-org/envs/shared@production",
+		"/checkout/step-projects/bu1/shared@production",
+		"/checkout/step-projects/bu1/development@development",
+		"/checkout/step-projects/bu1/production@production",
+	}, planned)
+	assert.True(t, HasDrift(results))
+	assert.Equal(t, []string{
+		"# org step-org/envs/shared: drift detected, 0 to add, 1 to change, 0 to destroy",
+		"#   update google_project.a",
+		"# projects step-projects/bu1/shared: no drift",
+		"# projects step-projects/bu1/development: drift detected, no resource summary available",
+		"# projects step-projects/bu1/production: plan failed: plan failed",
+	}, DriftReport(results))
+	assert.NoError(t, s.Unlock())
+}
+
+func TestResourceChanges(t *gotest.T) {
+	plan, err := terraform.ParsePlanJSON(`{
+  "format_version": "1.2",
+  "resource_changes": [
+    {"address": "google_project.b", "change": {"actions": ["delete", "create"]}},
+    {"address": "google_project.a", "change": {"actions": ["update"]}},
+    {"address": "google_folder.c", "change": {"actions": ["no-op"]}},
+    {"address": "data.google_folder.d", "change": {"actions": ["read"]}}
+  ]
+}`)
+	assert.NoError(t, err)
+	changes := resourceChanges(plan)
+	assert.Equal(t, []ResourceChange{
+		{Address: "google_project.a", Actions: []string{"update"}},
+		{Address: "google_project.b", Actions: []string{"delete", "create"}},
+	}, changes)
+	assert.Equal(t, "1 to add, 1 to change, 1 to destroy", DriftResult{Changes: changes}.Summary())
+	assert.Equal(t, "no resource summary available", DriftResult{Drifted: true, ChangesUnavailable: true}.Summary())
+}
@@ -15,14 +15,28 @@
 package stages
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/mitchellh/go-testing-interface"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/gcp"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/github"
 	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/gitlab"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/jenkins"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/tfc"
 )
 
+// Executor waits for the builds of the commits pushed to the branches of a repository.
 type Executor interface {
-	WaitBuildSuccess(t testing.TB, commitSha, failureMsg string) error
+	WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error
+}
+
+// Promoter promotes the code of a branch to an environment branch with a pull or merge request.
+type Promoter interface {
+	// Promote opens a pull or merge request from a branch to an environment branch and waits for it to be merged.
+	// If autoMerge is true the request is merged by the helper as soon as the branch rules allow it.
+	Promote(t testing.TB, ctx context.Context, from, env, title string, autoMerge bool) error
 }
 
 type GCPExecutor struct {
@@ -32,8 +46,8 @@ type GCPExecutor struct {
 	repo     string
 }
 
-func (e *GCPExecutor) WaitBuildSuccess(t testing.TB, commitSha, failureMsg string) error {
-	return e.executor.WaitBuildSuccess(t, e.project, e.region, e.repo, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+func (e *GCPExecutor) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	return e.executor.WaitBuildSuccess(t, ctx, e.project, e.region, e.repo, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
 }
 
 func NewGCPExecutor(project, region, repo string) *GCPExecutor {
@@ -52,17 +66,26 @@ type GitHubExecutor struct {
 	token    string
 }
 
-func NewGitHubExecutor(owner, repo, token string) *GitHubExecutor {
+func NewGitHubExecutor(baseURL, apiURL, owner, repo, token string) *GitHubExecutor {
 	return &GitHubExecutor{
-		executor: github.NewGH(),
+		executor: github.NewGH(baseURL, apiURL),
 		owner:    owner,
 		repo:     repo,
 		token:    token,
 	}
 }
 
-func (e *GitHubExecutor) WaitBuildSuccess(t testing.TB, commitSha, failureMsg string) error {
-	return e.executor.WaitBuildSuccess(t, e.owner, e.repo, e.token, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+func (e *GitHubExecutor) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	return e.executor.WaitBuildSuccess(t, ctx, e.owner, e.repo, e.token, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+}
+
+func (e *GitHubExecutor) Promote(t testing.TB, ctx context.Context, from, env, title string, autoMerge bool) error {
+	number, url, err := e.executor.CreatePullRequest(t, ctx, e.owner, e.repo, e.token, from, env, title)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# Pull request to promote %s to %s: %s\n", from, env, url)
+	return e.executor.WaitPullRequestMerged(t, ctx, e.owner, e.repo, e.token, number, autoMerge, MaxReviewRetries)
 }
 
 type GitLabExecutor struct {
@@ -72,15 +95,63 @@ type GitLabExecutor struct {
 	token    string
 }
 
-func NewGitLabExecutor(owner, project, token string) *GitLabExecutor {
+func NewGitLabExecutor(baseURL, apiURL, owner, project, token string) *GitLabExecutor {
 	return &GitLabExecutor{
-		executor: gitlab.NewGL(),
+		executor: gitlab.NewGL(baseURL, apiURL),
 		owner:    owner,
 		project:  project,
 		token:    token,
 	}
 }
 
-func (e *GitLabExecutor) WaitBuildSuccess(t testing.TB, commitSha, failureMsg string) error {
-	return e.executor.WaitBuildSuccess(t, e.owner, e.project, e.token, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+func (e *GitLabExecutor) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	return e.executor.WaitBuildSuccess(t, ctx, e.owner, e.project, e.token, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+}
+
+func (e *GitLabExecutor) Promote(t testing.TB, ctx context.Context, from, env, title string, autoMerge bool) error {
+	iid, url, err := e.executor.CreateMergeRequest(t, ctx, e.owner, e.project, e.token, from, env, title)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# Merge request to promote %s to %s: %s\n", from, env, url)
+	return e.executor.WaitMergeRequestMerged(t, ctx, e.owner, e.project, e.token, iid, autoMerge, MaxReviewRetries)
+}
+
+type JenkinsExecutor struct {
+	executor jenkins.JK
+	job      string
+}
+
+func NewJenkinsExecutor(url, user, token, job string) *JenkinsExecutor {
+	return &JenkinsExecutor{
+		executor: jenkins.NewJK(url, user, token),
+		job:      job,
+	}
+}
+
+func (e *JenkinsExecutor) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	return e.executor.WaitBuildSuccess(t, ctx, e.job, branch, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+}
+
+// tfcRuns waits for the runs of the Terraform Cloud workspaces connected to a branch.
+type tfcRuns interface {
+	WaitBuildSuccess(t testing.TB, ctx context.Context, workspaces []string, branch, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error
+}
+
+type TFCExecutor struct {
+	executor   tfcRuns
+	workspaces []string
+}
+
+// NewTFCExecutor creates an executor that waits for the runs of the workspaces connected to the
+// branch the commit was pushed to.
+func NewTFCExecutor(org, token string, workspaces []string) *TFCExecutor {
+	return &TFCExecutor{
+		executor:   tfc.NewTFC(org, token),
+		workspaces: workspaces,
+	}
+}
+
+func (e *TFCExecutor) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	return e.executor.WaitBuildSuccess(t, ctx, e.workspaces, branch, commitSha, failureMsg, MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
 }
@@ -0,0 +1,235 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/msg"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+)
+
+const (
+	NetworksStage = "3-networks"
+)
+
+// DefaultRegistry is the registry used by the helper.
+// Custom stages can be added to it with Register before the deploy starts.
+var DefaultRegistry = NewFoundationRegistry()
+
+// Register adds a custom stage to the default registry.
+func Register(st Stage) error {
+	return DefaultRegistry.Register(st)
+}
+
+// NewFoundationRegistry creates a registry with the stages of the Terraform Example Foundation.
+func NewFoundationRegistry() *Registry {
+	r := NewRegistry()
+	for _, st := range foundationStages() {
+		if err := r.Register(st); err != nil {
+			panic(fmt.Sprintf("failed to register stage %s: %s", st.Name, err.Error()))
+		}
+	}
+	return r
+}
+
+func foundationStages() []Stage {
+	return []Stage{
+		{
+			Name: BootstrapStep,
+			Step: BootstrapRepo,
+			Deploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DeployBootstrapStage(t, ctx, s, r.TFVars, r.Common)
+			},
+			PostDeploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				bo, err := r.LoadBootstrapOutputs(t)
+				if err != nil {
+					return err
+				}
+				if r.StageSkipped(BootstrapStep) {
+					msg.PrintBuildMsg(bo.CICDProject, bo.DefaultRegion, r.Common.DisablePrompt)
+				}
+				msg.PrintQuotaMsg(bo.ProjectsSA, r.Common.DisablePrompt)
+				if r.TFVars.HasGroupsCreation() {
+					msg.PrintAdminGroupPermissionMsg(bo.BootstrapSA, r.Common.DisablePrompt)
+				}
+				return nil
+			},
+			Destroy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DestroyBootstrapStage(t, ctx, s, r.Common, r.EnvVars)
+			},
+			// the bootstrap stage is applied locally and is not upgraded
+			Upgrade: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return upgradeBootstrapCode(t, r)
+			},
+			Drift: func(t testing.TB, r *RunConf) []StageConf {
+				return []StageConf{{
+					Stage:         BootstrapStep,
+					Repo:          BootstrapRepo,
+					GroupingUnits: []string{"envs"},
+					Envs:          []string{SharedEnv},
+				}}
+			},
+		},
+		{
+			Name:            OrgStep,
+			Step:            OrgRepo,
+			DependsOn:       []string{BootstrapStep},
+			RequiredOutputs: []string{BootstrapStep},
+			Deploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DeployOrgStage(t, ctx, s, r.TFVars, r.BootstrapOutputs(t), r.Common)
+			},
+			Destroy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DestroyOrgStage(t, ctx, s, r.BootstrapOutputs(t), r.Common)
+			},
+			Drift: func(t testing.TB, r *RunConf) []StageConf {
+				return []StageConf{{
+					Stage:         OrgRepo,
+					StageSA:       r.BootstrapOutputs(t).OrgSA,
+					Repo:          OrgRepo,
+					GroupingUnits: []string{"envs"},
+					Envs:          []string{SharedEnv},
+				}}
+			},
+		},
+		{
+			Name:            EnvironmentsStep,
+			Step:            EnvironmentsRepo,
+			DependsOn:       []string{OrgStep},
+			RequiredOutputs: []string{BootstrapStep},
+			Deploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DeployEnvStage(t, ctx, s, r.TFVars, r.BootstrapOutputs(t), r.Common)
+			},
+			Destroy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DestroyEnvStage(t, ctx, s, r.BootstrapOutputs(t), r.Common)
+			},
+			Drift: func(t testing.TB, r *RunConf) []StageConf {
+				return []StageConf{{
+					Stage:         EnvironmentsRepo,
+					StageSA:       r.BootstrapOutputs(t).EnvsSA,
+					Repo:          EnvironmentsRepo,
+					GroupingUnits: []string{"envs"},
+					Envs:          r.Common.DeployEnvs(),
+				}}
+			},
+		},
+		{
+			Name:            NetworksStage,
+			Aliases:         []string{HubAndSpokeStep, SvpcStep},
+			Step:            NetworksRepo,
+			DependsOn:       []string{EnvironmentsStep},
+			RequiredOutputs: []string{BootstrapStep},
+			Deploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DeployNetworksStage(t, ctx, s, r.TFVars, r.BootstrapOutputs(t), r.Common)
+			},
+			Destroy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DestroyNetworksStage(t, ctx, s, r.BootstrapOutputs(t), r.Common)
+			},
+			Drift: func(t testing.TB, r *RunConf) []StageConf {
+				return []StageConf{{
+					Stage:         NetworksRepo,
+					StageSA:       r.BootstrapOutputs(t).NetworkSA,
+					Repo:          NetworksRepo,
+					HasLocalStep:  true,
+					GroupingUnits: []string{"envs"},
+					Envs:          r.Common.DeployEnvs(),
+				}}
+			},
+		},
+		{
+			Name:            ProjectsStep,
+			Step:            ProjectsRepo,
+			DependsOn:       []string{NetworksStage},
+			RequiredOutputs: []string{BootstrapStep},
+			PreDeploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				msg.ConfirmQuota(r.BootstrapOutputs(t).ProjectsSA, r.Common.DisablePrompt)
+				return nil
+			},
+			Deploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DeployProjectsStage(t, ctx, s, r.TFVars, r.BootstrapOutputs(t), r.Common)
+			},
+			Destroy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				return DestroyProjectsStage(t, ctx, s, r.BootstrapOutputs(t), r.Common)
+			},
+			Drift: func(t testing.TB, r *RunConf) []StageConf {
+				return []StageConf{{
+					Stage:         ProjectsRepo,
+					StageSA:       r.BootstrapOutputs(t).ProjectsSA,
+					Repo:          ProjectsRepo,
+					HasLocalStep:  true,
+					GroupingUnits: GroupingUnits(r.Common.BusinessUnits),
+					Envs:          r.Common.DeployEnvs(),
+				}}
+			},
+		},
+		{
+			// each business unit has its own step named after its app infra repository
+			Name:            AppInfraStep,
+			DependsOn:       []string{ProjectsStep},
+			RequiredOutputs: []string{BootstrapStep, ProjectsStep},
+			Steps: func(c CommonConf) []string {
+				repos := []string{}
+				for _, bu := range c.BusinessUnits {
+					repos = append(repos, bu.AppInfraRepo)
+				}
+				return repos
+			},
+			Enabled: func(r *RunConf) bool {
+				return r.Common.BuildType == BuildTypeCBCSR
+			},
+			Deploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				for _, bu := range r.Common.BusinessUnits {
+					io := r.InfraPipelineOutputs(t, bu)
+					msg.PrintBuildMsg(io.InfraPipeProj, io.DefaultRegion, r.Common.DisablePrompt)
+					err := s.RunStep(bu.AppInfraRepo, func() error {
+						return DeployExampleAppStage(t, ctx, s, r.TFVars, bu, io, r.Common)
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Destroy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+				for i := len(r.Common.BusinessUnits) - 1; i >= 0; i-- {
+					bu := r.Common.BusinessUnits[i]
+					err := s.RunDestroyStep(bu.AppInfraRepo, func() error {
+						return DestroyExampleAppStage(t, ctx, s, bu, r.InfraPipelineOutputs(t, bu), r.Common)
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Drift: func(t testing.TB, r *RunConf) []StageConf {
+				confs := []StageConf{}
+				for _, bu := range r.Common.BusinessUnits {
+					confs = append(confs, StageConf{
+						Stage:         bu.AppInfraRepo,
+						StageSA:       r.InfraPipelineOutputs(t, bu).TerraformSA,
+						Repo:          bu.AppInfraRepo,
+						GroupingUnits: []string{bu.Name},
+						Envs:          r.Common.DeployEnvs(),
+					})
+				}
+				return confs
+			},
+		},
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/msg"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+const (
+	// DefaultGateTimeout is how long a gate waits for an approval hook or for the health check to pass.
+	DefaultGateTimeout = 24 * time.Hour
+	gatePollInterval   = time.Minute
+)
+
+// promotionGateKeys are the attributes of a gate in the promotion_gates input.
+var promotionGateKeys = []string{"approval", "soak_time", "health_check", "approval_file", "approval_url", "timeout"}
+
+// GateInputs are the gates of the promotion_gates input by environment.
+// The values are strings so each gate sets only the attributes it uses.
+type GateInputs map[string]map[string]string
+
+// PromotionGate is checked after an environment is applied and before the next environment is applied.
+// In the approval file, the approval URL, and the health check, "{stage}" and "{env}" are replaced
+// with the stage and the environment that was applied.
+type PromotionGate struct {
+	// Approval requires a confirmation, with Enter in interactive mode or with an approval hook when the prompt is disabled.
+	Approval bool
+	// SoakTime is how long to wait after the environment is applied.
+	SoakTime time.Duration
+	// HealthCheck is a shell command that must exit with success.
+	HealthCheck string
+	// ApprovalFile is a file that approves the promotion when it exists. It is removed after it is used.
+	ApprovalFile string
+	// ApprovalURL is a URL that approves the promotion when it returns 200 and rejects it when it returns 403.
+	ApprovalURL string
+	// Timeout is how long to wait for the approval hook or for the health check to pass.
+	Timeout time.Duration
+}
+
+// ParsePromotionGate parses the attributes of a gate of the promotion_gates input.
+func ParsePromotionGate(attrs map[string]string) (PromotionGate, error) {
+	gate := PromotionGate{Timeout: DefaultGateTimeout}
+	for k, v := range attrs {
+		var err error
+		switch k {
+		case "approval":
+			gate.Approval, err = strconv.ParseBool(v)
+		case "soak_time":
+			gate.SoakTime, err = time.ParseDuration(v)
+		case "timeout":
+			gate.Timeout, err = time.ParseDuration(v)
+		case "health_check":
+			gate.HealthCheck = v
+		case "approval_file":
+			gate.ApprovalFile = v
+		case "approval_url":
+			gate.ApprovalURL = v
+		default:
+			return PromotionGate{}, fmt.Errorf("unknown attribute '%s', valid attributes are: %s", k, strings.Join(promotionGateKeys, ", "))
+		}
+		if err != nil {
+			return PromotionGate{}, fmt.Errorf("invalid value '%s' of attribute '%s': %w", v, k, err)
+		}
+	}
+	if gate.SoakTime < 0 || gate.Timeout <= 0 {
+		return PromotionGate{}, fmt.Errorf("'soak_time' must not be negative and 'timeout' must be positive")
+	}
+	if !gate.Approval && gate.SoakTime == 0 && gate.HealthCheck == "" {
+		return PromotionGate{}, fmt.Errorf("gate must have at least one of 'approval', 'soak_time', or 'health_check'")
+	}
+	if (gate.ApprovalFile != "" || gate.ApprovalURL != "") && !gate.Approval {
+		return PromotionGate{}, fmt.Errorf("'approval_file' and 'approval_url' require 'approval = true'")
+	}
+	return gate, nil
+}
+
+// HasApprovalHook checks if the gate can be approved when the prompt is disabled.
+func (g PromotionGate) HasApprovalHook() bool {
+	return g.ApprovalFile != "" || g.ApprovalURL != ""
+}
+
+// GateChecker checks the promotion gates between environments.
+type GateChecker struct {
+	DisablePrompt bool
+	PollInterval  time.Duration
+	// Sleep pauses for the given duration or until the context is done.
+	Sleep func(ctx context.Context, d time.Duration) error
+	// Run executes a shell command with the additional environment variables and returns its combined output.
+	Run func(ctx context.Context, command string, env []string) (string, error)
+	// Get returns the HTTP status code of a GET request to the URL.
+	Get func(ctx context.Context, url string) (int, error)
+	// Prompt waits for the user confirmation.
+	Prompt func(msg string)
+}
+
+// NewGateChecker creates a gate checker that prompts the user unless the prompt is disabled.
+func NewGateChecker(disablePrompt bool) GateChecker {
+	return GateChecker{
+		DisablePrompt: disablePrompt,
+		PollInterval:  gatePollInterval,
+		Sleep:         utils.Sleep,
+		Run: func(ctx context.Context, command string, env []string) (string, error) {
+			cmd := exec.CommandContext(ctx, "sh", "-c", command)
+			cmd.Env = append(os.Environ(), env...)
+			out, err := cmd.CombinedOutput()
+			return strings.TrimSpace(string(out)), err
+		},
+		Get: func(ctx context.Context, url string) (int, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return 0, err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return 0, err
+			}
+			defer resp.Body.Close()
+			return resp.StatusCode, nil
+		},
+		Prompt: msg.PressEnter,
+	}
+}
+
+// Check waits for the soak time, then for the health check to pass, and then for the approval of the gate
+// of the environment of the stage.
+func (g GateChecker) Check(ctx context.Context, stage, env string, gate PromotionGate) error {
+	r := strings.NewReplacer("{stage}", stage, "{env}", env)
+	if gate.SoakTime > 0 {
+		fmt.Printf("# waiting soak time of %s after applying stage %s environment %s\n", gate.SoakTime, stage, env)
+		if err := g.Sleep(ctx, gate.SoakTime); err != nil {
+			return err
+		}
+	}
+	if gate.HealthCheck != "" {
+		if err := g.healthCheck(ctx, r.Replace(gate.HealthCheck), stage, env, gate.Timeout); err != nil {
+			return err
+		}
+	}
+	if !gate.Approval {
+		return nil
+	}
+	if !g.DisablePrompt {
+		g.Prompt(fmt.Sprintf("# Stage %s environment %s applied. Press Enter to approve the promotion to the next environment", stage, env))
+		return nil
+	}
+	if !gate.HasApprovalHook() {
+		return fmt.Errorf("gate of environment %s requires 'approval_file' or 'approval_url' when the prompt is disabled", env)
+	}
+	return g.waitApproval(ctx, r.Replace(gate.ApprovalFile), r.Replace(gate.ApprovalURL), stage, env, gate.Timeout)
+}
+
+// healthCheck runs the command until it exits with success or the timeout elapses.
+func (g GateChecker) healthCheck(ctx context.Context, command, stage, env string, timeout time.Duration) error {
+	vars := []string{"FOUNDATION_STAGE=" + stage, "FOUNDATION_ENV=" + env}
+	for waited := time.Duration(0); ; waited += g.PollInterval {
+		fmt.Printf("# running health check of stage %s environment %s: %s\n", stage, env, command)
+		out, err := g.Run(ctx, command, vars)
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("# health check failed: %v\n%s\n", err, out)
+		if waited+g.PollInterval >= timeout {
+			return fmt.Errorf("health check of stage %s environment %s did not pass in %s: %w", stage, env, timeout, err)
+		}
+		if err := g.Sleep(ctx, g.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// waitApproval polls the approval file and the approval URL until one approves the promotion,
+// the URL rejects it, or the timeout elapses.
+func (g GateChecker) waitApproval(ctx context.Context, file, url, stage, env string, timeout time.Duration) error {
+	fmt.Printf("# waiting approval of the promotion of stage %s environment %s\n", stage, env)
+	for waited := time.Duration(0); ; waited += g.PollInterval {
+		if file != "" {
+			_, err := os.Stat(file)
+			if err == nil {
+				fmt.Printf("# promotion approved by file %s\n", file)
+				return os.Remove(file)
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if url != "" {
+			status, err := g.Get(ctx, url)
+			switch {
+			case err != nil:
+				fmt.Printf("# approval URL %s failed: %v\n", url, err)
+			case status == http.StatusOK:
+				fmt.Printf("# promotion approved by URL %s\n", url)
+				return nil
+			case status == http.StatusForbidden:
+				return fmt.Errorf("promotion of stage %s environment %s rejected by URL %s", stage, env, url)
+			}
+		}
+		if waited+g.PollInterval >= timeout {
+			return fmt.Errorf("promotion of stage %s environment %s was not approved in %s", stage, env, timeout)
+		}
+		if err := g.Sleep(ctx, g.PollInterval); err != nil {
+			return err
+		}
+	}
+}
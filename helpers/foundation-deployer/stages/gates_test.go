@@ -0,0 +1,142 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePromotionGate(t *testing.T) {
+	gate, err := ParsePromotionGate(map[string]string{"approval": "true", "approval_url": "https://approvals.example.com/{stage}/{env}", "timeout": "2h"})
+	assert.NoError(t, err)
+	assert.Equal(t, PromotionGate{Approval: true, ApprovalURL: "https://approvals.example.com/{stage}/{env}", Timeout: 2 * time.Hour}, gate)
+	assert.True(t, gate.HasApprovalHook())
+
+	for _, attrs := range []map[string]string{
+		{"approval": "yes please"},
+		{"soak_time": "1 hour"},
+		{"soak_time": "-1h"},
+		{"soak_time": "1h", "timeout": "0s"},
+		{"after": "development"},
+		{"timeout": "1h"},
+		{"approval_file": "/tmp/approve"},
+	} {
+		_, err := ParsePromotionGate(attrs)
+		assert.Error(t, err, attrs)
+	}
+}
+
+// fakeGateChecker returns a checker that records the sleeps and prompts and does not wait.
+func fakeGateChecker(disablePrompt bool, slept *[]time.Duration, prompts *[]string) GateChecker {
+	return GateChecker{
+		DisablePrompt: disablePrompt,
+		PollInterval:  time.Minute,
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			*slept = append(*slept, d)
+			return nil
+		},
+		Run: func(ctx context.Context, command string, env []string) (string, error) {
+			return "", nil
+		},
+		Get: func(ctx context.Context, url string) (int, error) {
+			return http.StatusNotFound, nil
+		},
+		Prompt: func(msg string) {
+			*prompts = append(*prompts, msg)
+		},
+	}
+}
+
+func TestGateCheckerCheck(t *testing.T) {
+	ctx := context.Background()
+	slept := []time.Duration{}
+	prompts := []string{}
+
+	// soak time, health check retried until it passes, and manual approval
+	g := fakeGateChecker(false, &slept, &prompts)
+	commands := []string{}
+	g.Run = func(ctx context.Context, command string, env []string) (string, error) {
+		commands = append(commands, command)
+		assert.Equal(t, []string{"FOUNDATION_STAGE=gcp-networks", "FOUNDATION_ENV=production"}, env)
+		if len(commands) < 3 {
+			return "unhealthy", errors.New("exit status 1")
+		}
+		return "healthy", nil
+	}
+	gate := PromotionGate{Approval: true, SoakTime: time.Hour, HealthCheck: "./check.sh {stage} {env}", Timeout: DefaultGateTimeout}
+	err := g.Check(ctx, "gcp-networks", "production", gate)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Hour, time.Minute, time.Minute}, slept)
+	assert.Equal(t, []string{"./check.sh gcp-networks production", "./check.sh gcp-networks production", "./check.sh gcp-networks production"}, commands)
+	assert.Len(t, prompts, 1)
+
+	// health check timeout
+	slept = []time.Duration{}
+	g.Run = func(ctx context.Context, command string, env []string) (string, error) {
+		return "unhealthy", errors.New("exit status 1")
+	}
+	err = g.Check(ctx, "gcp-networks", "production", PromotionGate{HealthCheck: "./check.sh", Timeout: 3 * time.Minute})
+	assert.ErrorContains(t, err, "health check of stage gcp-networks environment production did not pass in 3m0s")
+	assert.Len(t, slept, 2)
+
+	// approval without hook when the prompt is disabled
+	g = fakeGateChecker(true, &slept, &prompts)
+	err = g.Check(ctx, "gcp-networks", "production", PromotionGate{Approval: true, Timeout: DefaultGateTimeout})
+	assert.ErrorContains(t, err, "requires 'approval_file' or 'approval_url'")
+
+	// approval file, removed after it is used
+	dir := t.TempDir()
+	gate = PromotionGate{Approval: true, ApprovalFile: filepath.Join(dir, "approve-{stage}-{env}"), Timeout: DefaultGateTimeout}
+	approval := filepath.Join(dir, "approve-gcp-networks-production")
+	slept = []time.Duration{}
+	g.Sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return os.WriteFile(approval, []byte{}, 0644)
+	}
+	err = g.Check(ctx, "gcp-networks", "production", gate)
+	assert.NoError(t, err)
+	assert.Len(t, slept, 1)
+	assert.NoFileExists(t, approval)
+
+	// approval URL approves, rejects, or times out
+	g = fakeGateChecker(true, &slept, &prompts)
+	gate = PromotionGate{Approval: true, ApprovalURL: "https://approvals.example.com/{stage}/{env}", Timeout: time.Hour}
+	statuses := []int{http.StatusNotFound, http.StatusOK}
+	g.Get = func(ctx context.Context, url string) (int, error) {
+		assert.Equal(t, "https://approvals.example.com/gcp-networks/production", url)
+		status := statuses[0]
+		statuses = statuses[1:]
+		return status, nil
+	}
+	err = g.Check(ctx, "gcp-networks", "production", gate)
+	assert.NoError(t, err)
+
+	statuses = []int{http.StatusForbidden}
+	err = g.Check(ctx, "gcp-networks", "production", gate)
+	assert.ErrorContains(t, err, "rejected")
+
+	g = fakeGateChecker(true, &slept, &prompts)
+	err = g.Check(ctx, "gcp-networks", "production", PromotionGate{Approval: true, ApprovalURL: "https://approvals.example.com", Timeout: 30 * time.Second})
+	assert.ErrorContains(t, err, "was not approved in 30s")
+	assert.Len(t, prompts, 1, "the prompt is not used when it is disabled")
+}
@@ -0,0 +1,139 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+// worktreesDir is the directory in the checkout path with the working trees of the environments destroyed in parallel.
+const worktreesDir = ".worktrees"
+
+// envBatches splits the environments in batches of consecutive environments that can be applied in parallel.
+// A batch ends in an environment with a promotion gate, so the gate is checked before the next environments are applied.
+func envBatches(envs []string, gates map[string]PromotionGate) [][]string {
+	batches := [][]string{}
+	batch := []string{}
+	for _, env := range envs {
+		batch = append(batch, env)
+		if _, ok := gates[env]; ok {
+			batches = append(batches, batch)
+			batch = []string{}
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// runParallel executes f for each environment, running at most n environments at the same time.
+// With n greater than one, the context of each environment prefixes the printed messages with the environment.
+// No new environment is started after an environment fails or the context is cancelled, and the errors of all environments are returned.
+func runParallel(ctx context.Context, n int, envs []string, f func(ctx context.Context, env string) error) error {
+	if n <= 1 {
+		for _, env := range envs {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("environment %s not started: %w", env, err)
+			}
+			err := f(ctx, env)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0
+	}
+	sem := make(chan struct{}, n)
+	for _, env := range envs {
+		sem <- struct{}{}
+		if failed() {
+			<-sem
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			<-sem
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("environment %s not started: %w", env, err))
+			mu.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := f(utils.WithLogPrefix(ctx, fmt.Sprintf("[%s] ", env)), env)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("environment %s: %w", env, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// lockedRepoExecutor releases the lock of the repository of a stage while it waits for builds and for pull or merge requests.
+// Environments applied in parallel hold the lock while they run git commands in the shared repository,
+// and wait for their builds at the same time.
+type lockedRepoExecutor struct {
+	Executor
+	mu *sync.Mutex
+}
+
+func (e lockedRepoExecutor) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	e.mu.Unlock()
+	defer e.mu.Lock()
+	return e.Executor.WaitBuildSuccess(t, ctx, branch, commitSha, failureMsg)
+}
+
+func (e lockedRepoExecutor) Promote(t testing.TB, ctx context.Context, from, env, title string, autoMerge bool) error {
+	p, ok := e.Executor.(Promoter)
+	if !ok {
+		return fmt.Errorf("promotion with pull requests is not supported by the executor of branch %s", env)
+	}
+	e.mu.Unlock()
+	defer e.mu.Lock()
+	return p.Promote(t, ctx, from, env, title, autoMerge)
+}
+
+// envStageConf returns a copy of the stage configuration to apply an environment holding the lock of the repository.
+// The git commands of the copy log with the prefix of the context.
+func envStageConf(t testing.TB, ctx context.Context, sc StageConf, c CommonConf, mu *sync.Mutex) StageConf {
+	if prefix := utils.LogPrefix(ctx); prefix != "" {
+		sc.GitConf = utils.GetRepoOnly(t, filepath.Join(c.CheckoutPath, sc.Repo), utils.PrefixLogger(c.Logger, prefix))
+	}
+	sc.Executor = lockedRepoExecutor{Executor: sc.Executor, mu: mu}
+	return sc
+}
@@ -0,0 +1,193 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	gotest "testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/git"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+func TestEnvBatches(t *gotest.T) {
+	envs := []string{"production", "nonproduction", "development"}
+	assert.Equal(t, [][]string{envs}, envBatches(envs, nil))
+	assert.Equal(t, [][]string{{"production"}, {"nonproduction", "development"}}, envBatches(envs, map[string]PromotionGate{"production": {Approval: true}}))
+	assert.Equal(t, [][]string{{"production", "nonproduction"}, {"development"}}, envBatches(envs, map[string]PromotionGate{"nonproduction": {Approval: true}, "development": {Approval: true}}))
+}
+
+func TestRunParallel(t *gotest.T) {
+	ctx := context.Background()
+	envs := []string{"production", "nonproduction", "development", "sandbox"}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	prefixes := map[string]string{}
+	err := runParallel(ctx, 2, envs, func(ctx context.Context, env string) error {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		prefixes[env] = utils.LogPrefix(ctx)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, maxRunning)
+	assert.Equal(t, map[string]string{"production": "[production] ", "nonproduction": "[nonproduction] ", "development": "[development] ", "sandbox": "[sandbox] "}, prefixes)
+
+	// environments are not started after an environment fails
+	started := []string{}
+	err = runParallel(ctx, 2, envs, func(ctx context.Context, env string) error {
+		mu.Lock()
+		started = append(started, env)
+		mu.Unlock()
+		if env == "production" {
+			return fmt.Errorf("apply failed")
+		}
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	assert.ErrorContains(t, err, "environment production: apply failed")
+	assert.ElementsMatch(t, []string{"production", "nonproduction"}, started)
+
+	// sequential execution stops in the first error without prefixes
+	started = []string{}
+	err = runParallel(ctx, 1, envs, func(ctx context.Context, env string) error {
+		started = append(started, env)
+		assert.Empty(t, utils.LogPrefix(ctx))
+		return context.Canceled
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"production"}, started)
+
+	// environments are not started after the context is cancelled
+	for _, n := range []int{1, 2} {
+		cancelCtx, cancel := context.WithCancel(ctx)
+		started = []string{}
+		err = runParallel(cancelCtx, n, envs, func(ctx context.Context, env string) error {
+			mu.Lock()
+			started = append(started, env)
+			mu.Unlock()
+			if env == "nonproduction" {
+				cancel()
+			}
+			if n > 1 && env == "production" {
+				<-ctx.Done()
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled, "parallel %d", n)
+		assert.ErrorContains(t, err, "environment development not started", "parallel %d", n)
+		assert.ElementsMatch(t, []string{"production", "nonproduction"}, started, "parallel %d", n)
+	}
+}
+
+// barrierBuilds waits until all the environments are waiting for their builds at the same time.
+type barrierBuilds struct {
+	mu      sync.Mutex
+	waiting sync.WaitGroup
+	builds  []string
+}
+
+func (e *barrierBuilds) WaitBuildSuccess(t testing.TB, ctx context.Context, branch, commitSha, failureMsg string) error {
+	e.mu.Lock()
+	e.builds = append(e.builds, branch+"@"+commitSha)
+	e.mu.Unlock()
+	e.waiting.Done()
+	done := make(chan struct{})
+	go func() {
+		e.waiting.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(10 * time.Second):
+		return errors.New("builds were not waited for in parallel")
+	}
+}
+
+// barrierRuns waits for the Terraform Cloud runs of the environments at the same time.
+type barrierRuns struct {
+	*barrierBuilds
+}
+
+func (e barrierRuns) WaitBuildSuccess(t testing.TB, ctx context.Context, workspaces []string, branch, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+	return e.barrierBuilds.WaitBuildSuccess(t, ctx, branch, commitSha, failureMsg)
+}
+
+func TestParallelApplyEnv(t *gotest.T) {
+	originPath := filepath.Join(t.TempDir(), "origin")
+	assert.NoError(t, os.MkdirAll(originPath, 0755))
+	originConf := git.NewCmdConfig(t, git.WithDir(originPath))
+	originConf.Init()
+	assert.NoError(t, os.WriteFile(filepath.Join(originPath, "README.md"), []byte("# Testing\n"), 0644))
+	originConf.AddAll()
+	originConf.CommitWithMsg("Initial commit", nil)
+
+	checkout := t.TempDir()
+	repo := filepath.Join(checkout, OrgRepo)
+	conf := utils.GitClone(t, "Generic", OrgRepo, "file://"+originPath, repo, "", logger.Discard)
+	envs := []string{"production", "nonproduction", "development"}
+	shas := map[string]string{}
+	for _, env := range envs {
+		assert.NoError(t, conf.CheckoutBranch(env))
+		assert.NoError(t, os.WriteFile(filepath.Join(repo, "main.tf"), []byte(env), 0644))
+		assert.NoError(t, conf.CommitFiles(env))
+		sha, err := conf.GetCommitSha()
+		assert.NoError(t, err)
+		shas[env] = sha
+	}
+
+	want := []string{}
+	for _, env := range envs {
+		want = append(want, env+"@"+shas[env])
+	}
+
+	c := CommonConf{CheckoutPath: checkout, Logger: logger.Discard}
+	for _, buildType := range []string{"Generic", BuildTypeTFC} {
+		builds := &barrierBuilds{}
+		builds.waiting.Add(len(envs))
+		var executor Executor = builds
+		if buildType == BuildTypeTFC {
+			executor = &TFCExecutor{executor: barrierRuns{builds}, workspaces: []string{"1-org"}}
+		}
+		sc := StageConf{Repo: OrgRepo, GitConf: conf, Executor: executor, BuildType: buildType}
+		var repoMu sync.Mutex
+		err := runParallel(context.Background(), len(envs), envs, func(ctx context.Context, env string) error {
+			repoMu.Lock()
+			defer repoMu.Unlock()
+			sc := envStageConf(t, ctx, sc, c, &repoMu)
+			return applyEnv(t, ctx, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, env, sc.Executor)
+		})
+		assert.NoError(t, err, buildType)
+		assert.ElementsMatch(t, want, builds.builds, "%s: each environment should wait for the build of its own branch", buildType)
+	}
+}
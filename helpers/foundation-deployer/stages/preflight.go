@@ -0,0 +1,341 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Minimum versions of the tools required by the helper.
+const (
+	MinGoVersion        = "1.22.0"
+	MinTerraformVersion = "1.5.7"
+	MinGcloudVersion    = "393.0.0"
+	MinGitVersion       = "2.28.0"
+)
+
+// RequiredOrgRoles are the roles the user deploying the foundation needs in the organization.
+var RequiredOrgRoles = []string{
+	"roles/resourcemanager.organizationAdmin",
+	"roles/orgpolicy.policyAdmin",
+	"roles/resourcemanager.projectCreator",
+	"roles/resourcemanager.folderCreator",
+	"roles/securitycenter.admin",
+}
+
+// RequiredBillingRoles are the roles the user deploying the foundation needs in the billing account.
+var RequiredBillingRoles = []string{
+	"roles/billing.admin",
+}
+
+var (
+	versionRegexp              = regexp.MustCompile(`\d+(\.\d+)+`)
+	dockerTerraformRegexp      = regexp.MustCompile(`(?m)^ARG TERRAFORM_VERSION=(\S+)`)
+	pipelineTerraformRegexp    = regexp.MustCompile(`(?m)terraform_version:\s*['"]?([0-9][0-9.]*)`)
+	gcloudComponentsToValidate = []string{"beta", "terraform-tools"}
+)
+
+// PreflightCheck is the result of a preflight check.
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Details string `json:"details"`
+}
+
+// Preflight checks the local environment before the deployment:
+// the versions of the tools, the Application Default Credentials, the quota project,
+// and the IAM roles of the user in the organization and in the billing account.
+type Preflight struct {
+	TFVars GlobalTFVars
+	// Run executes a command and returns its standard output.
+	Run func(name string, args ...string) (string, error)
+}
+
+// NewPreflight creates the preflight checks for the given configuration.
+func NewPreflight(g GlobalTFVars) Preflight {
+	return Preflight{
+		TFVars: g,
+		Run: func(name string, args ...string) (string, error) {
+			out, err := exec.Command(name, args...).Output()
+			if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+				err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+			}
+			return strings.TrimSpace(string(out)), err
+		},
+	}
+}
+
+// Checks runs all the preflight checks.
+func (p Preflight) Checks() []PreflightCheck {
+	goCheck, _ := p.checkVersion("go version", MinGoVersion, "go", "version")
+	tfCheck, tfVersion := p.checkVersion("terraform version", MinTerraformVersion, "terraform", "version")
+	gcloudCheck, _ := p.checkVersion("gcloud version", MinGcloudVersion, "gcloud", "version")
+	gitCheck, _ := p.checkVersion("git version", MinGitVersion, "git", "version")
+	checks := []PreflightCheck{
+		goCheck,
+		tfCheck,
+		p.checkTerraformPipeline(tfVersion),
+		gcloudCheck,
+		p.checkGcloudComponents(),
+		gitCheck,
+		p.checkADC(),
+		p.checkQuotaProject(),
+	}
+	account, err := p.Run("gcloud", "config", "get-value", "account")
+	if err != nil || account == "" {
+		return append(checks, PreflightCheck{Name: "gcloud account", Details: fmt.Sprintf("no active account, run 'gcloud auth login': %v", err)})
+	}
+	return append(checks,
+		p.checkRoles("organization IAM roles", account, RequiredOrgRoles, "organizations", "get-iam-policy", p.TFVars.OrgID),
+		p.checkRoles("billing account IAM roles", account, RequiredBillingRoles, "billing", "accounts", "get-iam-policy", p.TFVars.BillingAccount),
+	)
+}
+
+// PreflightPassed checks if all the checks passed.
+func PreflightPassed(checks []PreflightCheck) bool {
+	for _, c := range checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// PreflightTable formats the checks as a table.
+func PreflightTable(checks []PreflightCheck) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tDETAILS")
+	for _, c := range checks {
+		result := "FAIL"
+		if c.Passed {
+			result = "PASS"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, result, c.Details)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// checkVersion runs the command and checks that the first version in its output is at least the minimum version.
+func (p Preflight) checkVersion(name, min string, cmd string, args ...string) (PreflightCheck, string) {
+	c := PreflightCheck{Name: name}
+	out, err := p.Run(cmd, args...)
+	if err != nil {
+		c.Details = fmt.Sprintf("failed to get version: %v", err)
+		return c, ""
+	}
+	v := versionRegexp.FindString(out)
+	if v == "" {
+		c.Details = fmt.Sprintf("failed to find version in '%s'", out)
+		return c, ""
+	}
+	c.Passed = compareVersions(v, min) >= 0
+	c.Details = fmt.Sprintf("version %s, required %s or later", v, min)
+	return c, v
+}
+
+// checkTerraformPipeline checks that the local Terraform version is the same version used in the build pipelines.
+func (p Preflight) checkTerraformPipeline(local string) PreflightCheck {
+	c := PreflightCheck{Name: "terraform pipeline version"}
+	if local == "" {
+		c.Details = "local Terraform version not found"
+		return c
+	}
+	pinned, err := PipelineTerraformVersions(p.TFVars.FoundationCodePath)
+	if err != nil {
+		c.Details = err.Error()
+		return c
+	}
+	mismatches := []string{}
+	for _, f := range slices.Sorted(maps.Keys(pinned)) {
+		if pinned[f] != local {
+			mismatches = append(mismatches, fmt.Sprintf("%s uses %s", f, pinned[f]))
+		}
+	}
+	if len(mismatches) > 0 {
+		c.Details = fmt.Sprintf("local version %s differs from the pipeline: %s", local, strings.Join(mismatches, ", "))
+		return c
+	}
+	c.Passed = true
+	c.Details = fmt.Sprintf("version %s used in %d pipeline files", local, len(pinned))
+	return c
+}
+
+// PipelineTerraformVersions returns the Terraform versions pinned in the 0-bootstrap Dockerfile
+// and in the build pipeline files of the foundation code, by file path relative to the foundation code.
+func PipelineTerraformVersions(foundationPath string) (map[string]string, error) {
+	versions := map[string]string{}
+	dockerfile := filepath.Join("0-bootstrap", "Dockerfile")
+	files, err := filepath.Glob(filepath.Join(foundationPath, "build", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range append([]string{filepath.Join(foundationPath, dockerfile)}, files...) {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pinned Terraform version: %w", err)
+		}
+		re := pipelineTerraformRegexp
+		if strings.HasSuffix(f, dockerfile) {
+			re = dockerTerraformRegexp
+		}
+		rel, err := filepath.Rel(foundationPath, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range re.FindAllStringSubmatch(string(content), -1) {
+			versions[rel] = m[1]
+		}
+	}
+	return versions, nil
+}
+
+func (p Preflight) checkGcloudComponents() PreflightCheck {
+	c := PreflightCheck{Name: "gcloud components"}
+	filter := []string{}
+	for _, id := range gcloudComponentsToValidate {
+		filter = append(filter, "id="+id)
+	}
+	out, err := p.Run("gcloud", "components", "list", "--filter", strings.Join(filter, " OR "), "--format", "json")
+	if err != nil {
+		c.Details = fmt.Sprintf("failed to list components: %v", err)
+		return c
+	}
+	var components []struct {
+		ID    string `json:"id"`
+		State struct {
+			Name string `json:"name"`
+		} `json:"state"`
+	}
+	if err := json.Unmarshal([]byte(out), &components); err != nil {
+		c.Details = fmt.Sprintf("failed to parse components: %v", err)
+		return c
+	}
+	installed := map[string]bool{}
+	for _, comp := range components {
+		installed[comp.ID] = comp.State.Name != "Not Installed"
+	}
+	missing := []string{}
+	for _, id := range gcloudComponentsToValidate {
+		if !installed[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		c.Details = fmt.Sprintf("missing components: %s, run 'gcloud components install %s'", strings.Join(missing, ", "), strings.Join(missing, " "))
+		return c
+	}
+	c.Passed = true
+	c.Details = fmt.Sprintf("installed: %s", strings.Join(gcloudComponentsToValidate, ", "))
+	return c
+}
+
+func (p Preflight) checkADC() PreflightCheck {
+	c := PreflightCheck{Name: "application default credentials"}
+	_, err := p.Run("gcloud", "auth", "application-default", "print-access-token")
+	if err != nil {
+		c.Details = "credentials not found or expired, run 'gcloud auth application-default login'"
+		return c
+	}
+	c.Passed = true
+	c.Details = "valid"
+	return c
+}
+
+func (p Preflight) checkQuotaProject() PreflightCheck {
+	c := PreflightCheck{Name: "quota project"}
+	project, err := p.Run("gcloud", "config", "get-value", "billing/quota_project")
+	if err != nil || project == "" {
+		c.Details = "not set, run 'gcloud config set billing/quota_project <QUOTA-PROJECT>'"
+		return c
+	}
+	c.Passed = true
+	c.Details = project
+	return c
+}
+
+// checkRoles checks that the account has the required roles in the IAM policy returned by the gcloud command.
+// Roles granted through groups are not detected.
+func (p Preflight) checkRoles(name, account string, required []string, args ...string) PreflightCheck {
+	c := PreflightCheck{Name: name}
+	out, err := p.Run("gcloud", append(args, "--format", "json")...)
+	if err != nil {
+		c.Details = fmt.Sprintf("failed to get IAM policy: %v", err)
+		return c
+	}
+	var policy struct {
+		Bindings []struct {
+			Role    string   `json:"role"`
+			Members []string `json:"members"`
+		} `json:"bindings"`
+	}
+	if err := json.Unmarshal([]byte(out), &policy); err != nil {
+		c.Details = fmt.Sprintf("failed to parse IAM policy: %v", err)
+		return c
+	}
+	granted := map[string]bool{}
+	for _, b := range policy.Bindings {
+		for _, m := range b.Members {
+			if m == "user:"+account || m == "serviceAccount:"+account {
+				granted[b.Role] = true
+			}
+		}
+	}
+	missing := []string{}
+	for _, r := range required {
+		if !granted[r] {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		c.Details = fmt.Sprintf("'%s' is missing roles: %s (roles granted through groups are not detected)", account, strings.Join(missing, ", "))
+		return c
+	}
+	c.Passed = true
+	c.Details = fmt.Sprintf("'%s' has roles: %s", account, strings.Join(required, ", "))
+	return c
+}
+
+// compareVersions compares two dot separated numeric versions, returning -1, 0, or 1.
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
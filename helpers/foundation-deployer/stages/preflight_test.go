@@ -0,0 +1,108 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipelineTerraformVersions(t *testing.T) {
+	versions, err := PipelineTerraformVersions("../../..")
+	assert.NoError(t, err)
+	assert.Equal(t, MinTerraformVersion, versions[filepath.Join("0-bootstrap", "Dockerfile")])
+	assert.Equal(t, MinTerraformVersion, versions[filepath.Join("build", "github-tf-apply.yaml")])
+	assert.NotContains(t, versions, filepath.Join("build", "cloudbuild-tf-apply.yaml"), "files without a pinned version should be ignored")
+}
+
+func TestPreflight(t *testing.T) {
+	foundation := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(foundation, "0-bootstrap"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(foundation, "build"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(foundation, "0-bootstrap", "Dockerfile"), []byte("FROM gcloud\nARG TERRAFORM_VERSION=1.5.7\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(foundation, "build", "github-tf-apply.yaml"), []byte("with:\n  terraform_version: '1.5.7'\n"), 0644))
+
+	outputs := map[string]string{
+		"go version":        "go version go1.23.1 linux/amd64",
+		"terraform version": "Terraform v1.5.7\non linux_amd64",
+		"gcloud version":    "Google Cloud SDK 470.0.0\nbeta 2024.03.29",
+		"git version":       "git version 2.27.0",
+		"gcloud components list --filter id=beta OR id=terraform-tools --format json": `[{"id":"beta","state":{"name":"Installed"}},{"id":"terraform-tools","state":{"name":"Not Installed"}}]`,
+		"gcloud auth application-default print-access-token":                          "token",
+		"gcloud config get-value billing/quota_project":                               "quota-project",
+		"gcloud config get-value account":                                             "admin@example.com",
+		"gcloud organizations get-iam-policy 1234 --format json": `{"bindings":[
+			{"role":"roles/resourcemanager.organizationAdmin","members":["user:admin@example.com"]},
+			{"role":"roles/orgpolicy.policyAdmin","members":["user:admin@example.com"]},
+			{"role":"roles/resourcemanager.projectCreator","members":["user:admin@example.com"]},
+			{"role":"roles/resourcemanager.folderCreator","members":["user:admin@example.com"]},
+			{"role":"roles/securitycenter.admin","members":["group:admins@example.com"]}]}`,
+		"gcloud billing accounts get-iam-policy 000000-000000-000000 --format json": `{"bindings":[{"role":"roles/billing.admin","members":["user:admin@example.com"]}]}`,
+	}
+	p := Preflight{
+		TFVars: GlobalTFVars{OrgID: "1234", BillingAccount: "000000-000000-000000", FoundationCodePath: foundation},
+		Run: func(name string, args ...string) (string, error) {
+			cmd := strings.Join(append([]string{name}, args...), " ")
+			out, ok := outputs[cmd]
+			if !ok {
+				return "", fmt.Errorf("unexpected command '%s'", cmd)
+			}
+			return out, nil
+		},
+	}
+
+	results := map[string]PreflightCheck{}
+	checks := p.Checks()
+	for _, c := range checks {
+		results[c.Name] = c
+	}
+	assert.Len(t, checks, 10)
+	for _, name := range []string{"go version", "terraform version", "terraform pipeline version", "gcloud version", "application default credentials", "quota project", "billing account IAM roles"} {
+		assert.True(t, results[name].Passed, name)
+	}
+	assert.False(t, results["git version"].Passed)
+	assert.Equal(t, "version 2.27.0, required 2.28.0 or later", results["git version"].Details)
+	assert.False(t, results["gcloud components"].Passed)
+	assert.Contains(t, results["gcloud components"].Details, "gcloud components install terraform-tools")
+	assert.False(t, results["organization IAM roles"].Passed)
+	assert.Contains(t, results["organization IAM roles"].Details, "missing roles: roles/securitycenter.admin")
+	assert.False(t, PreflightPassed(checks))
+
+	table := PreflightTable(checks)
+	assert.Contains(t, table, "CHECK")
+	assert.Regexp(t, `quota project\s+PASS\s+quota-project`, table)
+	assert.Regexp(t, `git version\s+FAIL\s+version 2.27.0`, table)
+
+	// local Terraform version different from the pipeline
+	c := p.checkTerraformPipeline("1.9.0")
+	assert.False(t, c.Passed)
+	assert.Equal(t, "local version 1.9.0 differs from the pipeline: 0-bootstrap/Dockerfile uses 1.5.7, build/github-tf-apply.yaml uses 1.5.7", c.Details)
+
+	// missing ADC
+	delete(outputs, "gcloud auth application-default print-access-token")
+	assert.False(t, p.checkADC().Passed)
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, compareVersions("1.5.7", "1.5.7"))
+	assert.Equal(t, 1, compareVersions("1.10.0", "1.9.9"))
+	assert.Equal(t, -1, compareVersions("1.5", "1.5.7"))
+	assert.Equal(t, 1, compareVersions("470.0.0", "393.0.0"))
+}
@@ -0,0 +1,472 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/msg"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+)
+
+// StageFunc is the function executed to deploy or destroy a stage.
+// The context is cancelled when the execution is interrupted.
+type StageFunc func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error
+
+// Stage is a unit of the deployment that can be registered in a Registry.
+type Stage struct {
+	// Name is the name used to select the stage in the command line. Example: "1-org"
+	Name string
+	// Aliases are alternative names that can be used to select the stage.
+	Aliases []string
+	// Step is the name of the step that records the stage execution in the steps file.
+	// If empty, the stage functions are responsible for recording their own steps.
+	Step string
+	// Steps returns the steps recorded by the stage functions when Step is empty.
+	Steps func(c CommonConf) []string
+	// DependsOn are the stages that must be deployed before this stage.
+	DependsOn []string
+	// RequiredOutputs are the stages whose outputs are read by this stage.
+	RequiredOutputs []string
+	// Enabled reports if the stage is used in the current configuration. Nil means always enabled.
+	Enabled func(r *RunConf) bool
+	// PreDeploy is executed before the deploy step, even if the step is already completed.
+	PreDeploy StageFunc
+	// Deploy deploys the stage.
+	Deploy StageFunc
+	// PostDeploy is executed after the deploy step, even if the step was already completed.
+	PostDeploy StageFunc
+	// Destroy destroys the stage.
+	Destroy StageFunc
+	// Upgrade is executed in upgrade mode, instead of deploying the stage again, when the stage is selected
+	// or a selected stage depends on it. Nil means the stage is upgraded running Deploy again.
+	Upgrade StageFunc
+	// Drift returns the configurations of the repositories checked by the drift detection.
+	// Nil means the stage is not checked.
+	Drift func(t testing.TB, r *RunConf) []StageConf
+}
+
+// RunConf is the configuration shared by the stages of a deploy or destroy execution.
+type RunConf struct {
+	TFVars           GlobalTFVars
+	Common           CommonConf
+	EnvVars          map[string]string
+	bootstrapOutputs *BootstrapOutputs
+	infraOutputs     map[string]InfraPipelineOutputs
+	skipped          map[string]bool
+}
+
+// NewRunConf creates the configuration shared by the stages of an execution.
+func NewRunConf(tfvars GlobalTFVars, c CommonConf, envVars map[string]string) *RunConf {
+	return &RunConf{
+		TFVars:       tfvars,
+		Common:       c,
+		EnvVars:      envVars,
+		infraOutputs: map[string]InfraPipelineOutputs{},
+		skipped:      map[string]bool{},
+	}
+}
+
+// LoadBootstrapOutputs reads the outputs of the 0-bootstrap stage.
+// The outputs are read once and reused by the other stages.
+func (r *RunConf) LoadBootstrapOutputs(t testing.TB) (BootstrapOutputs, error) {
+	if r.bootstrapOutputs == nil {
+		bo, err := GetBootstrapStepOutputs(t, r.Common.FoundationPath, r.Common.BuildType)
+		if err != nil {
+			return BootstrapOutputs{}, bootstrapOutputsError(r.Common, err)
+		}
+		r.bootstrapOutputs = &bo
+	}
+	return *r.bootstrapOutputs, nil
+}
+
+// BootstrapOutputs returns the outputs of the 0-bootstrap stage.
+// The stages that read them require the 0-bootstrap stage, so the outputs are loaded before they run.
+func (r *RunConf) BootstrapOutputs(t testing.TB) BootstrapOutputs {
+	bo, err := r.LoadBootstrapOutputs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bo
+}
+
+// InfraPipelineOutputs returns the outputs of the infra pipeline of the given business unit.
+// The outputs are read once and reused by the other stages.
+func (r *RunConf) InfraPipelineOutputs(t testing.TB, bu BusinessUnit) InfraPipelineOutputs {
+	io, ok := r.infraOutputs[bu.AppInfraRepo]
+	if !ok {
+		io = GetInfraPipelineOutputs(t, r.Common.CheckoutPath, bu.Name, bu.AppInfraRepo)
+		io.RemoteStateBucket = r.BootstrapOutputs(t).RemoteStateBucketProjects
+		r.infraOutputs[bu.AppInfraRepo] = io
+	}
+	return io
+}
+
+// StageSkipped reports if the deploy step of the given stage was already completed before the current execution.
+func (r *RunConf) StageSkipped(name string) bool {
+	return r.skipped[name]
+}
+
+// Registry holds the stages that compose the deployment.
+type Registry struct {
+	stages map[string]Stage
+	names  []string
+}
+
+// NewRegistry creates an empty stage registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		stages: map[string]Stage{},
+	}
+}
+
+// Register adds a stage to the registry.
+func (r *Registry) Register(st Stage) error {
+	if st.Name == "" {
+		return fmt.Errorf("stage name is required")
+	}
+	if st.Deploy == nil || st.Destroy == nil {
+		return fmt.Errorf("deploy and destroy functions are required for stage '%s'", st.Name)
+	}
+	for _, n := range append([]string{st.Name}, st.Aliases...) {
+		if _, err := r.resolve(n); err == nil {
+			return fmt.Errorf("stage '%s' is already registered", n)
+		}
+	}
+	r.stages[st.Name] = st
+	r.names = append(r.names, st.Name)
+	return nil
+}
+
+// Names returns the names of the registered stages in registration order.
+func (r *Registry) Names() []string {
+	return append([]string{}, r.names...)
+}
+
+// Get returns the stage registered with the given name or alias.
+func (r *Registry) Get(name string) (Stage, bool) {
+	n, err := r.resolve(name)
+	if err != nil {
+		return Stage{}, false
+	}
+	return r.stages[n], true
+}
+
+// resolve returns the name of the stage registered with the given name or alias.
+func (r *Registry) resolve(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	for _, n := range r.names {
+		if n == name {
+			return n, nil
+		}
+		for _, a := range r.stages[n].Aliases {
+			if a == name {
+				return n, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("invalid stage '%s'. Must be one of: %s", name, strings.Join(r.names, ", "))
+}
+
+// DeployOrder returns the stages sorted so that each stage comes after its dependencies.
+// Stages without dependencies between them keep the registration order.
+func (r *Registry) DeployOrder() ([]Stage, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	order := []Stage{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle between stages: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		path = append(append([]string{}, path...), name)
+		for _, d := range r.stages[name].DependsOn {
+			dep, err := r.resolve(d)
+			if err != nil {
+				return fmt.Errorf("stage '%s' depends on unknown stage '%s'", name, d)
+			}
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, r.stages[name])
+		return nil
+	}
+	for _, n := range r.names {
+		if err := visit(n, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// DestroyOrder returns the stages in the reverse of the deploy order.
+func (r *Registry) DestroyOrder() ([]Stage, error) {
+	order, err := r.DeployOrder()
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
+
+// Select returns the stages to be executed based on a comma separated list of stages
+// or on a contiguous range of stages in deploy order.
+// If no stage is provided all the stages are selected.
+func (r *Registry) Select(list, from, to string) (map[string]bool, error) {
+	if list != "" && (from != "" || to != "") {
+		return nil, fmt.Errorf("a list of stages cannot be used together with a range of stages")
+	}
+	selected := map[string]bool{}
+	if list != "" {
+		for _, name := range strings.Split(list, ",") {
+			n, err := r.resolve(name)
+			if err != nil {
+				return nil, err
+			}
+			selected[n] = true
+		}
+		return selected, nil
+	}
+	var err error
+	if from != "" {
+		if from, err = r.resolve(from); err != nil {
+			return nil, err
+		}
+	}
+	if to != "" {
+		if to, err = r.resolve(to); err != nil {
+			return nil, err
+		}
+	}
+	order, err := r.DeployOrder()
+	if err != nil {
+		return nil, err
+	}
+	first, last := 0, len(order)-1
+	for i, st := range order {
+		if from != "" && st.Name == from {
+			first = i
+		}
+		if to != "" && st.Name == to {
+			last = i
+		}
+	}
+	if first > last {
+		return nil, fmt.Errorf("stage '%s' comes after stage '%s'", from, to)
+	}
+	for _, st := range order[first : last+1] {
+		selected[st.Name] = true
+	}
+	return selected, nil
+}
+
+// Dependents returns the given stage and the stages that depend on it, directly or transitively, in deploy order.
+func (r *Registry) Dependents(name string) ([]Stage, error) {
+	name, err := r.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	order, err := r.DeployOrder()
+	if err != nil {
+		return nil, err
+	}
+	dependents := map[string]bool{name: true}
+	result := []Stage{}
+	// dependencies always come before their dependents in deploy order
+	for _, st := range order {
+		for _, d := range st.DependsOn {
+			if dep, err := r.resolve(d); err == nil && dependents[dep] {
+				dependents[st.Name] = true
+			}
+		}
+		if dependents[st.Name] {
+			result = append(result, st)
+		}
+	}
+	return result, nil
+}
+
+// ResetFrom resets the steps of the given stage and of the stages that depend on it, including their nested steps.
+func (r *Registry) ResetFrom(s steps.Steps, c CommonConf, name string) error {
+	dependents, err := r.Dependents(name)
+	if err != nil {
+		return err
+	}
+	for _, st := range dependents {
+		for _, step := range st.steps(c) {
+			if !s.StepExists(step) {
+				continue
+			}
+			if err := s.ResetStepRecursive(step); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// steps returns the steps recorded by the stage.
+func (st Stage) steps(c CommonConf) []string {
+	if st.Step != "" {
+		return []string{st.Step}
+	}
+	if st.Steps != nil {
+		return st.Steps(c)
+	}
+	return nil
+}
+
+// enabled checks if the stage is used in the current configuration.
+func (st Stage) enabled(r *RunConf) bool {
+	return st.Enabled == nil || st.Enabled(r)
+}
+
+// Deploy deploys the selected stages in deploy order.
+// Steps that fail after the context is cancelled are recorded as interrupted.
+func (r *Registry) Deploy(t testing.TB, ctx context.Context, s steps.Steps, rc *RunConf, selected map[string]bool) error {
+	order, err := r.DeployOrder()
+	if err != nil {
+		return err
+	}
+	s = s.WithContext(ctx)
+	deployed := map[string]bool{}
+	for _, st := range order {
+		if !selected[st.Name] || !st.enabled(rc) {
+			continue
+		}
+		for _, o := range st.RequiredOutputs {
+			req, ok := r.Get(o)
+			if !ok {
+				return fmt.Errorf("stage '%s' requires outputs of unknown stage '%s'", st.Name, o)
+			}
+			if req.Step != "" && !s.IsStepComplete(req.Step) && (!deployed[req.Name] || rc.Common.PlanOnly) {
+				return fmt.Errorf("stage '%s' requires the outputs of stage '%s' that is not deployed", st.Name, req.Name)
+			}
+			if req.Name == BootstrapStep {
+				if _, err := rc.LoadBootstrapOutputs(t); err != nil {
+					return fmt.Errorf("stage '%s' requires the outputs of stage '%s': %w", st.Name, req.Name, err)
+				}
+			}
+		}
+
+		msg.PrintStageMsg(fmt.Sprintf("Deploying %s stage", st.Name))
+		rc.skipped[st.Name] = st.Step != "" && s.IsStepComplete(st.Step)
+		err := emitStage(st.Name, "deploy", func() error {
+			if st.PreDeploy != nil {
+				if err := st.PreDeploy(t, ctx, s, rc); err != nil {
+					return err
+				}
+			}
+			err := runStageStep(st.Step, s.RunStep, func() error {
+				return st.Deploy(t, ctx, s, rc)
+			})
+			if err != nil {
+				return err
+			}
+			if st.PostDeploy != nil {
+				return st.PostDeploy(t, ctx, s, rc)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s stage failed: %w", st.Name, err)
+		}
+		deployed[st.Name] = true
+	}
+	return nil
+}
+
+// Destroy destroys the selected stages in destroy order.
+// A stage is not destroyed while a stage that depends on it, and is not selected, is still deployed.
+// Steps that fail after the context is cancelled are recorded as interrupted.
+func (r *Registry) Destroy(t testing.TB, ctx context.Context, s steps.Steps, rc *RunConf, selected map[string]bool) error {
+	order, err := r.DestroyOrder()
+	if err != nil {
+		return err
+	}
+	for _, st := range order {
+		if !selected[st.Name] || !st.enabled(rc) {
+			continue
+		}
+		dependents, err := r.Dependents(st.Name)
+		if err != nil {
+			return err
+		}
+		for _, d := range dependents {
+			if selected[d.Name] || !d.enabled(rc) {
+				continue
+			}
+			for _, step := range d.steps(rc.Common) {
+				if s.StepExists(step) && !s.IsStepDestroyed(step) {
+					return fmt.Errorf("stage '%s' cannot be destroyed while stage '%s' that depends on it is deployed. Destroy stage '%s' first or select it too", st.Name, d.Name, d.Name)
+				}
+			}
+		}
+	}
+	s = s.WithContext(ctx)
+	for _, st := range order {
+		if !selected[st.Name] || !st.enabled(rc) {
+			continue
+		}
+		msg.PrintStageMsg(fmt.Sprintf("Destroying %s stage", st.Name))
+		err := emitStage(st.Name, "destroy", func() error {
+			return runStageStep(st.Step, s.RunDestroyStep, func() error {
+				return st.Destroy(t, ctx, s, rc)
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("%s stage destroy failed: %w", st.Name, err)
+		}
+	}
+	return nil
+}
+
+// emitStage executes the stage function emitting the stage started, completed, and failed events.
+func emitStage(stage, mode string, f func() error) error {
+	start := time.Now()
+	events.Emit(events.Event{Type: events.StageStarted, Stage: stage, Mode: mode})
+	err := f()
+	if err != nil {
+		events.Emit(events.Event{Type: events.StageFailed, Stage: stage, Mode: mode, Duration: events.Since(start), Error: err.Error()})
+		return err
+	}
+	events.Emit(events.Event{Type: events.StageCompleted, Stage: stage, Mode: mode, Duration: events.Since(start)})
+	return nil
+}
+
+// runStageStep executes the stage function in the given step or directly if the stage has no step.
+func runStageStep(step string, run func(string, func() error) error, f func() error) error {
+	if step == "" {
+		return f()
+	}
+	return run(step, f)
+}
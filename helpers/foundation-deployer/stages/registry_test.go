@@ -0,0 +1,249 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+)
+
+func testStage(name string, executed *[]string, deps ...string) Stage {
+	return Stage{
+		Name:      name,
+		Step:      "step-" + name,
+		DependsOn: deps,
+		Deploy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+			*executed = append(*executed, "deploy-"+name)
+			return nil
+		},
+		Destroy: func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+			*executed = append(*executed, "destroy-"+name)
+			return nil
+		},
+	}
+}
+
+func stageNames(l []Stage) []string {
+	n := []string{}
+	for _, st := range l {
+		n = append(n, st.Name)
+	}
+	return n
+}
+
+func TestRegistryOrder(t *gotest.T) {
+	executed := []string{}
+	r := NewRegistry()
+	assert.NoError(t, r.Register(testStage("c", &executed, "b")))
+	assert.NoError(t, r.Register(testStage("a", &executed)))
+	assert.NoError(t, r.Register(testStage("b", &executed, "a")))
+	assert.NoError(t, r.Register(testStage("d", &executed, "a")))
+	assert.Error(t, r.Register(testStage("a", &executed)), "duplicated stage should not be registered")
+
+	deploy, err := r.DeployOrder()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, stageNames(deploy))
+
+	destroy, err := r.DestroyOrder()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "c", "b", "a"}, stageNames(destroy))
+
+	cycle := NewRegistry()
+	assert.NoError(t, cycle.Register(testStage("a", &executed, "b")))
+	assert.NoError(t, cycle.Register(testStage("b", &executed, "a")))
+	_, err = cycle.DeployOrder()
+	assert.ErrorContains(t, err, "dependency cycle between stages: a -> b -> a")
+
+	unknown := NewRegistry()
+	assert.NoError(t, unknown.Register(testStage("a", &executed, "z")))
+	_, err = unknown.DeployOrder()
+	assert.ErrorContains(t, err, "unknown stage 'z'")
+}
+
+func TestRegistrySelect(t *gotest.T) {
+	r := NewFoundationRegistry()
+
+	all, err := r.Select("", "", "")
+	assert.NoError(t, err)
+	assert.Len(t, all, 6)
+
+	list, err := r.Select("2-environments, 3-networks-svpc", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{EnvironmentsStep: true, NetworksStage: true}, list)
+
+	rng, err := r.Select("", "3-networks", "4-projects")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{NetworksStage: true, ProjectsStep: true}, rng)
+
+	from, err := r.Select("", "4-projects", "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{ProjectsStep: true, AppInfraStep: true}, from)
+
+	_, err = r.Select("", "4-projects", "1-org")
+	assert.ErrorContains(t, err, "comes after")
+
+	_, err = r.Select("6-workloads", "", "")
+	assert.ErrorContains(t, err, "invalid stage '6-workloads'")
+
+	_, err = r.Select("1-org", "1-org", "")
+	assert.Error(t, err)
+}
+
+func TestRegistrySelectCases(t *gotest.T) {
+	r := NewFoundationRegistry()
+	tests := []struct {
+		name     string
+		list     string
+		from     string
+		to       string
+		selected []string
+		err      string
+	}{
+		{name: "hub and spoke alias", list: HubAndSpokeStep, selected: []string{NetworksStage}},
+		{name: "svpc alias with spaces", list: " 1-org , " + SvpcStep + " ", selected: []string{OrgStep, NetworksStage}},
+		{name: "duplicated stages", list: "1-org,1-org", selected: []string{OrgStep}},
+		{name: "alias in range", from: SvpcStep, to: HubAndSpokeStep, selected: []string{NetworksStage}},
+		{name: "to only", to: "2-environments", selected: []string{BootstrapStep, OrgStep, EnvironmentsStep}},
+		{name: "unknown stage in list", list: "1-org,9-unknown", err: "invalid stage '9-unknown'"},
+		{name: "empty stage in list", list: "1-org,", err: "invalid stage ''"},
+		{name: "unknown from", from: "9-unknown", err: "invalid stage '9-unknown'"},
+		{name: "unknown to", to: "9-unknown", err: "invalid stage '9-unknown'"},
+		{name: "from after to", from: AppInfraStep, to: SvpcStep, err: "stage '5-app-infra' comes after stage '3-networks'"},
+		{name: "list and range", list: "1-org", to: "2-environments", err: "cannot be used together"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *gotest.T) {
+			selected, err := r.Select(tt.list, tt.from, tt.to)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			want := map[string]bool{}
+			for _, st := range tt.selected {
+				want[st] = true
+			}
+			assert.Equal(t, want, selected)
+		})
+	}
+}
+
+func TestRegistryDeployDestroy(t *gotest.T) {
+	executed := []string{}
+	r := NewRegistry()
+	assert.NoError(t, r.Register(testStage("a", &executed)))
+	b := testStage("b", &executed, "a")
+	b.RequiredOutputs = []string{"a"}
+	assert.NoError(t, r.Register(b))
+	c := testStage("c", &executed, "b")
+	c.Enabled = func(r *RunConf) bool { return false }
+	assert.NoError(t, r.Register(c))
+
+	s, err := steps.LoadSteps(filepath.Join(t.TempDir(), "registry.json"))
+	assert.NoError(t, err)
+	rc := NewRunConf(GlobalTFVars{}, CommonConf{}, nil)
+
+	err = r.Deploy(t, context.Background(), s, rc, map[string]bool{"b": true})
+	assert.ErrorContains(t, err, "requires the outputs of stage 'a'")
+	assert.Empty(t, executed)
+
+	all, err := r.Select("", "", "")
+	assert.NoError(t, err)
+	err = r.Deploy(t, context.Background(), s, rc, all)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deploy-a", "deploy-b"}, executed)
+	assert.True(t, s.IsStepComplete("step-b"))
+
+	executed = []string{}
+	err = r.Deploy(t, context.Background(), s, rc, map[string]bool{"b": true})
+	assert.NoError(t, err)
+	assert.Empty(t, executed, "completed stages should not be executed again")
+	assert.True(t, rc.StageSkipped("b"))
+
+	err = r.Destroy(t, context.Background(), s, rc, map[string]bool{"a": true})
+	assert.ErrorContains(t, err, "stage 'a' cannot be destroyed while stage 'b' that depends on it is deployed")
+	assert.Empty(t, executed, "no stage should be destroyed while a dependent stage is deployed")
+
+	err = r.Destroy(t, context.Background(), s, rc, all)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"destroy-b", "destroy-a"}, executed)
+	assert.True(t, s.IsStepDestroyed("step-a"))
+
+	// dependent stages already destroyed or never deployed do not block the destroy
+	assert.NoError(t, s.CompleteStep("step-a"))
+	executed = []string{}
+	err = r.Destroy(t, context.Background(), s, rc, map[string]bool{"a": true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"destroy-a"}, executed)
+}
+
+func TestRegistryResetFrom(t *gotest.T) {
+	executed := []string{}
+	r := NewRegistry()
+	assert.NoError(t, r.Register(testStage("a", &executed)))
+	assert.NoError(t, r.Register(testStage("b", &executed, "a")))
+	assert.NoError(t, r.Register(testStage("c", &executed, "b")))
+	assert.NoError(t, r.Register(testStage("d", &executed, "a")))
+	bu := testStage("e", &executed, "c")
+	bu.Step = ""
+	bu.Steps = func(c CommonConf) []string {
+		return []string{"bu1-app-infra", "bu2-app-infra"}
+	}
+	assert.NoError(t, r.Register(bu))
+
+	dependents, err := r.Dependents("b")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "e"}, stageNames(dependents))
+
+	_, err = r.Dependents("z")
+	assert.ErrorContains(t, err, "invalid stage 'z'")
+
+	s, err := steps.LoadSteps(filepath.Join(t.TempDir(), "reset.json"))
+	assert.NoError(t, err)
+	for _, step := range []string{"step-a", "step-b", "step-b.production", "step-c", "step-d", "bu1-app-infra"} {
+		assert.NoError(t, s.CompleteStep(step))
+	}
+	assert.NoError(t, r.ResetFrom(s, CommonConf{}, "b"))
+	for _, step := range []string{"step-a", "step-d"} {
+		assert.True(t, s.IsStepComplete(step), step)
+	}
+	for _, step := range []string{"step-b", "step-b.production", "step-c", "bu1-app-infra"} {
+		assert.False(t, s.IsStepComplete(step), step)
+	}
+	assert.False(t, s.StepExists("bu2-app-infra"), "steps not executed should not be created")
+	assert.NoError(t, s.Unlock())
+}
+
+func TestLoadBootstrapOutputs(t *gotest.T) {
+	// the 0-bootstrap stage of an empty foundation code has no state
+	rc := NewRunConf(GlobalTFVars{}, CommonConf{FoundationPath: t.TempDir(), PlanOnly: true}, nil)
+	_, err := rc.LoadBootstrapOutputs(t)
+	assert.ErrorContains(t, err, "plan only mode can only preview the stages after 0-bootstrap once 0-bootstrap is applied")
+
+	rc = NewRunConf(GlobalTFVars{}, CommonConf{FoundationPath: t.TempDir()}, nil)
+	_, err = rc.LoadBootstrapOutputs(t)
+	assert.ErrorContains(t, err, "error reading the outputs of the 0-bootstrap stage")
+
+	rc.bootstrapOutputs = &BootstrapOutputs{OrgSA: "org-sa"}
+	bo, err := rc.LoadBootstrapOutputs(t)
+	assert.NoError(t, err)
+	assert.Equal(t, "org-sa", bo.OrgSA, "outputs should be read once")
+}
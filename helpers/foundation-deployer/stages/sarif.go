@@ -0,0 +1,177 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	toolName     = "foundation-deployer"
+	toolURI      = "https://github.com/terraform-google-modules/terraform-example-foundation/tree/master/helpers/foundation-deployer"
+	srcRoot      = "%SRCROOT%"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// ValidationSARIF returns the issues as a SARIF log, so they can be reported by code scanning tools.
+// Each issue is located in the line of the tfvars file where its input is defined, if it is found.
+func ValidationSARIF(issues []ValidationIssue, tfvarsFile string) ([]byte, error) {
+	content, err := os.ReadFile(tfvarsFile)
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := artifactLocation(tfvarsFile)
+	if err != nil {
+		return nil, err
+	}
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           toolName,
+			InformationURI: toolURI,
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	rules := map[string]bool{}
+	for _, i := range issues {
+		id := "tfvars/" + i.Field
+		if !rules[id] {
+			rules[id] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               id,
+				ShortDescription: sarifMessage{Text: fmt.Sprintf("Invalid value for input '%s'", i.Field)},
+			})
+		}
+		location := sarifPhysicalLocation{ArtifactLocation: artifact}
+		if line := inputLine(string(content), i.Field); line > 0 {
+			location.Region = &sarifRegion{StartLine: line}
+		}
+		message := i.Message
+		if i.Hint != "" {
+			message += ". " + i.Hint
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    id,
+			Level:     i.Severity,
+			Message:   sarifMessage{Text: message},
+			Locations: []sarifLocation{{PhysicalLocation: location}},
+		})
+	}
+	return json.MarshalIndent(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}}, "", "  ")
+}
+
+// artifactLocation returns the location of the file relative to the root of its git repository,
+// or to the current directory if it is not in a git repository, as code scanning tools expect.
+// Files outside of the root are located by their absolute file URI.
+func artifactLocation(file string) (sarifArtifactLocation, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return sarifArtifactLocation{}, err
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return sarifArtifactLocation{}, err
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			root = dir
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return sarifArtifactLocation{URI: "file://" + filepath.ToSlash(abs)}, nil
+	}
+	return sarifArtifactLocation{URI: filepath.ToSlash(rel), URIBaseID: srcRoot}, nil
+}
+
+// inputLine returns the line number where the top level input of the field is defined, or 0 if it is not found.
+func inputLine(content, field string) int {
+	input := strings.FieldsFunc(field, func(r rune) bool { return r == '.' || r == '[' })
+	if len(input) == 0 {
+		return 0
+	}
+	re := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(input[0]) + `\s*=`)
+	for n, l := range strings.Split(content, "\n") {
+		if re.MatchString(l) {
+			return n + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,193 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/msg"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+const (
+	// UpstreamBranch is the branch of the stage repositories with the unmodified foundation code.
+	// It is the base of the three-way merge of a new version of the foundation code with the local changes.
+	UpstreamBranch = "foundation-upstream"
+)
+
+// Upgrade upgrades the selected stages that are deployed to the version of the foundation code in the foundation path.
+// Stages with an Upgrade function execute it when they are selected or when a selected stage depends on them.
+// The completed steps of the other selected stages are reset and the stages are deployed again merging the new
+// foundation code in their repositories, and planning and applying it in each environment.
+// Stages that were being upgraded are resumed without being reset.
+func (r *Registry) Upgrade(t testing.TB, ctx context.Context, s steps.Steps, rc *RunConf, selected map[string]bool) error {
+	order, err := r.DeployOrder()
+	if err != nil {
+		return err
+	}
+	// dependencies always come before their dependents in deploy order
+	required := map[string]bool{}
+	for i := len(order) - 1; i >= 0; i-- {
+		if !selected[order[i].Name] && !required[order[i].Name] {
+			continue
+		}
+		for _, d := range order[i].DependsOn {
+			if dep, err := r.resolve(d); err == nil {
+				required[dep] = true
+			}
+		}
+	}
+	rc.Common.Upgrade = true
+	redeploy := map[string]bool{}
+	for _, st := range order {
+		if !st.enabled(rc) {
+			continue
+		}
+		if st.Upgrade != nil && (selected[st.Name] || required[st.Name]) {
+			msg.PrintStageMsg(fmt.Sprintf("Upgrading %s stage", st.Name))
+			err := emitStage(st.Name, "upgrade", func() error {
+				return st.Upgrade(t, ctx, s, rc)
+			})
+			if err != nil {
+				return fmt.Errorf("%s stage upgrade failed: %w", st.Name, err)
+			}
+			continue
+		}
+		if st.Upgrade != nil || !selected[st.Name] {
+			continue
+		}
+		names := []string{}
+		for _, step := range st.steps(rc.Common) {
+			if s.StepExists(step) {
+				names = append(names, step)
+			}
+		}
+		if len(names) == 0 {
+			fmt.Printf("# Skipping %s stage. It is not deployed.\n", st.Name)
+			continue
+		}
+		// in plan only mode all the steps are executed
+		if !s.PlanOnly {
+			for _, step := range names {
+				if !s.IsStepComplete(step) {
+					continue
+				}
+				if err := s.ResetStepRecursive(step); err != nil {
+					return err
+				}
+			}
+		}
+		redeploy[st.Name] = true
+	}
+	return r.Deploy(t, ctx, s, rc, redeploy)
+}
+
+// upgradeBootstrapCode updates the backend files of the new version of the foundation code with the state buckets
+// created by the 0-bootstrap stage, as done in its deploy.
+func upgradeBootstrapCode(t testing.TB, r *RunConf) error {
+	bo, err := r.LoadBootstrapOutputs(t)
+	if err != nil {
+		return err
+	}
+	if r.Common.BuildType == BuildTypeTFC {
+		err = utils.SetTFCBackendAndRemote(r.Common.FoundationPath)
+		if err != nil {
+			return err
+		}
+	}
+	return replaceBackendBuckets(r.Common.FoundationPath, bo.RemoteStateBucket, bo.RemoteStateBucketProjects)
+}
+
+// initCommitMsg returns the message of the commit that initializes a stage repository with the foundation code.
+func initCommitMsg(repo string) string {
+	return fmt.Sprintf("Initialize %s repo", repo)
+}
+
+// mergeStepCode merges a new version of the foundation code into the plan branch of the stage repository.
+// The foundation code is committed in the upstream branch, created from the commit that initialized the repository,
+// so the changes made to the repository after it are kept by the three-way merge.
+// Files deleted from the foundation code are deleted from the repository, files committed before the foundation code are kept.
+// If the merge has conflicts it is aborted so they can be resolved manually.
+func mergeStepCode(t testing.TB, sc StageConf, c CommonConf) error {
+	exists, err := sc.GitConf.HasBranch(UpstreamBranch)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		base, err := sc.GitConf.FirstCommitWithMessage("plan", initCommitMsg(sc.Repo))
+		if err != nil {
+			return err
+		}
+		if base == "" {
+			fmt.Printf("# foundation code commit not found in repository %s, copying the foundation code\n", sc.Repo)
+			return copyStageCode(t, sc, c)
+		}
+		err = sc.GitConf.CreateBranch(UpstreamBranch, base)
+		if err != nil {
+			return err
+		}
+	}
+	err = sc.GitConf.CheckoutBranch(UpstreamBranch)
+	if err != nil {
+		return err
+	}
+	dir := sc.CustomTargetDirPath
+	if dir == "" {
+		dir = "."
+	}
+	err = sc.GitConf.RemoveFiles(dir)
+	if err != nil {
+		return err
+	}
+	base, err := sc.GitConf.FirstCommitWithMessage(UpstreamBranch, initCommitMsg(sc.Repo))
+	if err != nil {
+		return err
+	}
+	err = sc.GitConf.RestoreFiles(base+"~1", dir)
+	if err != nil {
+		return err
+	}
+	err = copyStageCode(t, sc, c)
+	if err != nil {
+		return err
+	}
+	err = sc.GitConf.CommitFiles(fmt.Sprintf("Update foundation code of %s repo", sc.Repo))
+	if err != nil {
+		return err
+	}
+	err = sc.GitConf.CheckoutBranch("plan")
+	if err != nil {
+		return err
+	}
+	err = sc.GitConf.MergeBranch(UpstreamBranch, fmt.Sprintf("Merge foundation code into %s repo", sc.Repo))
+	if err != nil {
+		return fmt.Errorf("%w. Resolve the conflicts merging branch %s into branch plan in %s and run the upgrade again", err, UpstreamBranch, filepath.Join(c.CheckoutPath, sc.Repo))
+	}
+	return nil
+}
+
+// promoteBranch merges a branch into an environment branch.
+func promoteBranch(conf utils.GitRepo, from, env string) error {
+	err := conf.CheckoutBranch(env)
+	if err != nil {
+		return err
+	}
+	return conf.MergeBranch(from, fmt.Sprintf("Promote %s to %s", from, env))
+}
@@ -0,0 +1,161 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/git"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/steps"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+func TestRegistryUpgrade(t *gotest.T) {
+	executed := []string{}
+	r := NewRegistry()
+	a := testStage("a", &executed)
+	a.Upgrade = func(t testing.TB, ctx context.Context, s steps.Steps, r *RunConf) error {
+		assert.True(t, r.Common.Upgrade)
+		executed = append(executed, "upgrade-a")
+		return nil
+	}
+	assert.NoError(t, r.Register(a))
+	assert.NoError(t, r.Register(testStage("b", &executed, "a")))
+	assert.NoError(t, r.Register(testStage("c", &executed, "b")))
+	assert.NoError(t, r.Register(testStage("d", &executed, "a")))
+
+	s, err := steps.LoadSteps(filepath.Join(t.TempDir(), "upgrade.json"))
+	assert.NoError(t, err)
+	for _, step := range []string{"step-a", "step-b", "step-c", "step-c.copy-code", "step-c.production"} {
+		assert.NoError(t, s.CompleteStep(step))
+	}
+	rc := NewRunConf(GlobalTFVars{}, CommonConf{}, nil)
+
+	err = r.Upgrade(t, context.Background(), s, rc, map[string]bool{"c": true, "d": true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"upgrade-a", "deploy-c"}, executed, "dependencies should be prepared and stages not deployed skipped")
+	assert.True(t, s.IsStepComplete("step-b"), "stages not selected should not be reset")
+	assert.True(t, s.IsStepComplete("step-c"))
+	assert.False(t, s.IsStepComplete("step-c.copy-code"), "nested steps should be reset")
+	assert.NoError(t, s.Unlock())
+}
+
+func TestUpgradeBootstrapCodeTFC(t *gotest.T) {
+	foundation := t.TempDir()
+	bootstrap := filepath.Join(foundation, BootstrapStep)
+	shared := filepath.Join(foundation, OrgStep, "envs", SharedEnv)
+	for dir, files := range map[string]map[string]string{
+		bootstrap: {"backend.tf.example": "bucket = \"UPDATE_ME\"", "backend.tf.cloud.example": "cloud"},
+		shared:    {"backend.tf": "bucket = \"UPDATE_ME\"", "backend.tf.cloud.example": "cloud"},
+	} {
+		assert.NoError(t, os.MkdirAll(dir, 0755))
+		for f, content := range files {
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, f), []byte(content), 0644))
+		}
+	}
+	rc := NewRunConf(GlobalTFVars{}, CommonConf{FoundationPath: foundation, BuildType: BuildTypeTFC}, nil)
+	rc.bootstrapOutputs = &BootstrapOutputs{RemoteStateBucket: "bkt-state", RemoteStateBucketProjects: "bkt-projects"}
+
+	// the deploy of the bootstrap stage already converted the foundation code to the Terraform Cloud backend
+	assert.NoError(t, utils.SetTFCBackendAndRemote(foundation))
+	assert.NoError(t, upgradeBootstrapCode(t, rc))
+	for file, content := range map[string]string{
+		filepath.Join(bootstrap, "backend.tf"):          "cloud",
+		filepath.Join(shared, "backend.tf"):             "cloud",
+		filepath.Join(shared, "backend.tf.gcs.example"): "bucket = \"UPDATE_ME\"",
+		filepath.Join(bootstrap, "backend.tf.example"):  "bucket = \"UPDATE_ME\"",
+	} {
+		b, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(b), "wrong content of file %s", file)
+	}
+
+	// a new version of the foundation code is converted
+	assert.NoError(t, os.WriteFile(filepath.Join(bootstrap, "backend.tf.cloud.example"), []byte("cloud"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(shared, "backend.tf.cloud.example"), []byte("cloud v2"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(shared, "backend.tf.gcs.example")))
+	assert.NoError(t, os.WriteFile(filepath.Join(shared, "backend.tf"), []byte("bucket = \"UPDATE_ME\""), 0644))
+	assert.NoError(t, upgradeBootstrapCode(t, rc))
+	b, err := os.ReadFile(filepath.Join(shared, "backend.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cloud v2", string(b))
+	b, err = os.ReadFile(filepath.Join(bootstrap, "backend.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cloud", string(b))
+	assert.NoFileExists(t, filepath.Join(bootstrap, "backend.tf.gcs.example"), "the Terraform Cloud backend of the bootstrap stage is not a GCS backend")
+}
+
+func TestMergeStepCode(t *gotest.T) {
+	foundation := t.TempDir()
+	for _, f := range []string{"build/cloudbuild-tf-apply.yaml", "build/cloudbuild-tf-plan.yaml", "build/tf-wrapper.sh"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(foundation, f)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(foundation, f), []byte(f), 0644))
+	}
+	mainTF := filepath.Join(foundation, OrgStep, "envs", "shared", "main.tf")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(mainTF), 0755))
+	assert.NoError(t, os.WriteFile(mainTF, []byte("a\nb\nc\n"), 0644))
+	removedTF := filepath.Join(foundation, OrgStep, "envs", "shared", "removed.tf")
+	assert.NoError(t, os.WriteFile(removedTF, []byte("removed"), 0644))
+
+	checkout := t.TempDir()
+	repo := filepath.Join(checkout, OrgRepo)
+	assert.NoError(t, os.MkdirAll(repo, 0755))
+	git.NewCmdConfig(t, git.WithDir(repo)).Init()
+	conf := utils.GetRepoOnly(t, repo, logger.Discard)
+	assert.NoError(t, conf.CheckoutBranch("plan"))
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "README.md"), []byte("# Repository"), 0644))
+	assert.NoError(t, conf.CommitFiles("Initial commit"))
+
+	c := CommonConf{FoundationPath: foundation, CheckoutPath: checkout, BuildType: BuildTypeCBCSR}
+	sc := StageConf{Stage: OrgRepo, Step: OrgStep, Repo: OrgRepo, GitConf: conf, BuildType: BuildTypeCBCSR}
+
+	// repositories without the foundation code commit receive a copy of the code
+	assert.NoError(t, mergeStepCode(t, sc, c))
+	assert.NoError(t, conf.CommitFiles(initCommitMsg(OrgRepo)))
+	repoTF := filepath.Join(repo, "envs", "shared", "main.tf")
+	assert.NoError(t, os.WriteFile(repoTF, []byte("a\nb\nlocal\n"), 0644))
+	assert.NoError(t, conf.CommitFiles("local change"))
+
+	assert.FileExists(t, filepath.Join(repo, "envs", "shared", "removed.tf"))
+
+	assert.NoError(t, os.WriteFile(mainTF, []byte("upstream\nb\nc\n"), 0644))
+	assert.NoError(t, os.Remove(removedTF))
+	assert.NoError(t, mergeStepCode(t, sc, c))
+	b, err := os.ReadFile(repoTF)
+	assert.NoError(t, err)
+	assert.Equal(t, "upstream\nb\nlocal\n", string(b), "local changes should be kept")
+	assert.NoFileExists(t, filepath.Join(repo, "envs", "shared", "removed.tf"), "files deleted from the foundation code should be deleted")
+	assert.FileExists(t, filepath.Join(repo, "README.md"), "files committed before the foundation code should be kept")
+	assert.FileExists(t, filepath.Join(repo, "cloudbuild-tf-apply.yaml"))
+	branch, err := conf.GetCurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "plan", branch)
+
+	assert.NoError(t, os.WriteFile(mainTF, []byte("upstream\nb\nconflict\n"), 0644))
+	err = mergeStepCode(t, sc, c)
+	assert.ErrorContains(t, err, "conflicts in files: envs/shared/main.tf")
+
+	assert.NoError(t, promoteBranch(conf, "plan", "production"))
+	b, err = os.ReadFile(repoTF)
+	assert.NoError(t, err)
+	assert.Equal(t, "upstream\nb\nlocal\n", string(b), "environment branch should have the plan branch code")
+}
@@ -16,7 +16,11 @@ package stages
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/mitchellh/go-testing-interface"
@@ -29,6 +33,50 @@ const (
 	exampleDotCom = "example.com"
 )
 
+// maxProjectPrefixLength keeps the IDs of the projects created by the foundation within the 30 characters limit.
+const maxProjectPrefixLength = 3
+
+var (
+	orgIDRegexp          = regexp.MustCompile(`^[0-9]+$`)
+	billingAccountRegexp = regexp.MustCompile(`^[0-9A-F]{6}-[0-9A-F]{6}-[0-9A-F]{6}$`)
+	regionRegexp         = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+	projectPrefixRegexp  = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	networksRegionRegexp = regexp.MustCompile(`(?m)^\s*(default_region[12])\s*=\s*(.+?)\s*$`)
+)
+
+// Severity of the validation issues.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationIssue is a problem found in an input of the tfvars file.
+type ValidationIssue struct {
+	// Field is the name of the input in the tfvars file.
+	Field    string `json:"field"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// Hint describes how to fix the issue.
+	Hint string `json:"hint,omitempty"`
+}
+
+func (i ValidationIssue) String() string {
+	if i.Hint == "" {
+		return fmt.Sprintf("%s: %s: %s", i.Severity, i.Field, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s. %s", i.Severity, i.Field, i.Message, i.Hint)
+}
+
+// HasErrors checks if any of the issues is an error.
+func HasErrors(issues []ValidationIssue) bool {
+	for _, i := range issues {
+		if i.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidateDirectories checks if the required directories exist
 func ValidateDirectories(g GlobalTFVars) error {
 	_, err := os.Stat(g.FoundationCodePath)
@@ -62,93 +110,296 @@ func ValidateComponents(t testing.TB) error {
 }
 
 // ValidateBasicFields validates if the values for the required field were provided
-func ValidateBasicFields(t testing.TB, g GlobalTFVars) {
+func ValidateBasicFields(t testing.TB, g GlobalTFVars) []ValidationIssue {
 	gcpConf := gcp.NewGCP()
-	fmt.Println("")
-	fmt.Println("# Validating tfvar file.")
+	issues := []ValidationIssue{}
 	if g.OrgID != replaceME {
 		if g.HasValidatorProj() && g.EnableSccResourcesInTerraform != nil && *g.EnableSccResourcesInTerraform && gcpConf.HasSccNotification(t, g.OrgID, g.SccNotificationName) {
-			fmt.Printf("# Notification '%s' exists in organization '%s'. Chose a different one.\n", g.SccNotificationName, g.OrgID)
-			fmt.Printf("# See existing Notifications for organization '%s'.\n", g.OrgID)
-			fmt.Printf("# gcloud scc notifications list organizations/%s --location=global --filter=\"name:organizations/%s/locations/global/notificationConfigs/%s\" --format=\"value(name)\"\n", g.OrgID, g.OrgID, g.SccNotificationName)
-			fmt.Println("")
+			issues = append(issues, ValidationIssue{
+				Field:    "scc_notification_name",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Notification '%s' exists in organization '%s'", g.SccNotificationName, g.OrgID),
+				Hint: fmt.Sprintf("Chose a different one. See existing Notifications with: gcloud scc notifications list organizations/%s --location=global --filter=\"name:organizations/%s/locations/global/notificationConfigs/%s\" --format=\"value(name)\"",
+					g.OrgID, g.OrgID, g.SccNotificationName),
+			})
 		}
 		if g.HasValidatorProj() && !g.CreateUniqueTagKey && gcpConf.HasTagKey(t, g.OrgID, "environment") {
-			fmt.Printf("# Tag key 'environment' exists in organization '%s'.\n", g.OrgID)
-			fmt.Println("# Set variable 'create_unique_tag_key' to 'true' in the tfvar file.")
-			fmt.Println("")
+			issues = append(issues, ValidationIssue{
+				Field:    "create_unique_tag_key",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Tag key 'environment' exists in organization '%s'", g.OrgID),
+				Hint:     "Set variable 'create_unique_tag_key' to 'true' in the tfvar file",
+			})
 		}
 	}
 
-	g.CheckString(replaceME)
+	issues = append(issues, g.CheckString(replaceME)...)
+	issues = append(issues, g.CheckString(exampleDotCom)...)
+	issues = append(issues, ValidateSemantics(g)...)
 
-	if strings.Contains(g.Domain, exampleDotCom) {
-		fmt.Println("# Replace value 'example.com' for input 'domain'")
-	}
 	if g.Domain != "" && g.Domain[len(g.Domain)-1:] != "." {
-		fmt.Println("# Value for input 'domain' must end with '.'")
-	}
-	for _, d := range g.DomainsToAllow {
-		if strings.Contains(d, exampleDotCom) {
-			fmt.Println("# Replace value 'example.com' for input 'domains_to_allow'")
-		}
+		issues = append(issues, ValidationIssue{
+			Field:    "domain",
+			Severity: SeverityError,
+			Message:  "Value for input 'domain' must end with '.'",
+			Hint:     fmt.Sprintf("Use '%s.'", g.Domain),
+		})
 	}
 	for _, e := range g.EssentialContactsDomains {
-		if strings.Contains(e, exampleDotCom) {
-			fmt.Println("# Replace value 'example.com' for input 'essential_contacts_domains_to_allow'")
-		}
 		if e != "" && e[0:1] != "@" {
-			fmt.Printf("# Essential contacts must start with '@': '%s'\n", e)
+			issues = append(issues, ValidationIssue{
+				Field:    "essential_contacts_domains_to_allow",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Essential contacts must start with '@': '%s'", e),
+				Hint:     fmt.Sprintf("Use '@%s'", e),
+			})
 		}
 	}
 	for _, p := range g.PerimeterAdditionalMembers {
-		if strings.Contains(p, exampleDotCom) {
-			fmt.Printf("# Replace value for input 'perimeter_additional_members': '%s'\n", p)
-		}
 		if strings.Contains(p, "group:") {
-			fmt.Printf("# VPC Service Controls does not allow groups in the perimeter: '%s'\n", p)
+			issues = append(issues, ValidationIssue{
+				Field:    "perimeter_additional_members",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("VPC Service Controls does not allow groups in the perimeter: '%s'", p),
+				Hint:     "Add the members of the group as 'user:' or 'serviceAccount:' members",
+			})
+		}
+	}
+	envs := map[string]bool{}
+	for _, e := range g.GetEnvironments() {
+		if e == "" || e == SharedEnv {
+			issues = append(issues, ValidationIssue{
+				Field:    "environments",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Invalid value for input 'environments': '%s'", e),
+				Hint:     fmt.Sprintf("Environments cannot be empty or '%s'", SharedEnv),
+			})
+		}
+		if envs[e] {
+			issues = append(issues, ValidationIssue{
+				Field:    "environments",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Duplicated value for input 'environments': '%s'", e),
+				Hint:     "Remove the duplicated environment",
+			})
 		}
+		envs[e] = true
+	}
+	if production := g.GetProductionEnvironment(); !envs[production] {
+		issues = append(issues, ValidationIssue{
+			Field:    "production_environment",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("Production environment '%s' is not one of the environments: %s", production, strings.Join(g.GetEnvironments(), ", ")),
+			Hint:     "Set the 'production_environment' input with the environment of the production networks",
+		})
+	}
+	names, repos := map[string]bool{}, map[string]bool{}
+	for i, bu := range g.GetBusinessUnits() {
+		field := fmt.Sprintf("business_units[%d]", i)
+		if bu.Name == "" {
+			issues = append(issues, ValidationIssue{
+				Field:    field + ".name",
+				Severity: SeverityError,
+				Message:  "Business unit name cannot be empty",
+				Hint:     "Use the name of the directory of the business unit in the '4-projects' and '5-app-infra' steps",
+			})
+		} else if names[bu.Name] {
+			issues = append(issues, ValidationIssue{
+				Field:    field + ".name",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Duplicated business unit name: '%s'", bu.Name),
+				Hint:     "Remove the duplicated business unit",
+			})
+		}
+		names[bu.Name] = true
+		if bu.AppInfraRepo == "" {
+			issues = append(issues, ValidationIssue{
+				Field:    field + ".app_infra_repo",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Business unit '%s' must have an 'app_infra_repo'", bu.Name),
+				Hint:     "Set the name of the app infra repository of the business unit",
+			})
+		} else if repos[bu.AppInfraRepo] {
+			issues = append(issues, ValidationIssue{
+				Field:    field + ".app_infra_repo",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Duplicated app infra repository: '%s'", bu.AppInfraRepo),
+				Hint:     "Each business unit needs its own app infra repository",
+			})
+		}
+		repos[bu.AppInfraRepo] = true
+	}
+	return issues
+}
+
+// replaceIssue is the issue of an input that still has a value of the example file.
+func replaceIssue(field, value string) ValidationIssue {
+	return ValidationIssue{
+		Field:    field,
+		Severity: SeverityError,
+		Message:  fmt.Sprintf("Replace value '%s' for input '%s'", value, field),
+		Hint:     "Use a value of your environment",
 	}
 }
 
 // ValidateDestroyFlags checks if the flags to allow the destruction of the infrastructure are enabled
-func ValidateDestroyFlags(t testing.TB, g GlobalTFVars) {
-	trueFlags := []string{}
-	falseFlags := []string{}
-	projectDeletion := false
+func ValidateDestroyFlags(t testing.TB, g GlobalTFVars) []ValidationIssue {
+	issues := []ValidationIssue{}
+	destroyIssue := func(field, value string) {
+		issues = append(issues, ValidationIssue{
+			Field:    field,
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("The deployment created by this helper cannot be destroyed by the helper unless '%s' is '%s'", field, value),
+			Hint:     fmt.Sprintf("Set the '%s' input to '%s' in the tfvars file", field, value),
+		})
+	}
 
 	if g.BucketForceDestroy == nil || !*g.BucketForceDestroy {
-		trueFlags = append(trueFlags, "bucket_force_destroy")
+		destroyIssue("bucket_force_destroy", "true")
 	}
 	if g.AuditLogsTableDeleteContentsOnDestroy == nil || !*g.AuditLogsTableDeleteContentsOnDestroy {
-		trueFlags = append(trueFlags, "audit_logs_table_delete_contents_on_destroy")
+		destroyIssue("audit_logs_table_delete_contents_on_destroy", "true")
 	}
 	if g.LogExportStorageForceDestroy == nil || !*g.LogExportStorageForceDestroy {
-		trueFlags = append(trueFlags, "log_export_storage_force_destroy")
+		destroyIssue("log_export_storage_force_destroy", "true")
 	}
 	if g.BucketTfstateKmsForceDestroy == nil || !*g.BucketTfstateKmsForceDestroy {
-		trueFlags = append(trueFlags, "bucket_tfstate_kms_force_destroy")
+		destroyIssue("bucket_tfstate_kms_force_destroy", "true")
 	}
 	if g.FolderDeletionProtection != nil && *g.FolderDeletionProtection {
-		falseFlags = append(falseFlags, "folder_deletion_protection")
+		destroyIssue("folder_deletion_protection", "false")
 	}
 	if g.WorkflowDeletionProtection != nil && *g.WorkflowDeletionProtection {
-		falseFlags = append(falseFlags, "workflow_deletion_protection")
+		destroyIssue("workflow_deletion_protection", "false")
+	}
+	if g.ProjectDeletionPolicy != "DELETE" {
+		destroyIssue("project_deletion_policy", "DELETE")
+	}
+	return issues
+}
+
+// ValidateSemantics validates the format of the inputs and the rules between inputs
+// that would otherwise only fail during the terraform apply.
+func ValidateSemantics(g GlobalTFVars) []ValidationIssue {
+	issues := []ValidationIssue{}
+	invalid := func(field, message, hint string) {
+		issues = append(issues, ValidationIssue{Field: field, Severity: SeverityError, Message: message, Hint: hint})
+	}
+
+	if g.OrgID != replaceME && !orgIDRegexp.MatchString(g.OrgID) {
+		invalid("org_id", fmt.Sprintf("Organization ID '%s' must be numeric", g.OrgID), "Get the ID with: gcloud organizations list")
+	}
+	if g.BillingAccount != replaceME && !billingAccountRegexp.MatchString(g.BillingAccount) {
+		invalid("billing_account", fmt.Sprintf("Billing account '%s' must have the format 000000-000000-000000", g.BillingAccount), "Get the ID with: gcloud billing accounts list")
 	}
-	projectDeletion = g.ProjectDeletionPolicy != "DELETE"
 
-	if len(trueFlags) > 0 || len(falseFlags) > 0 || projectDeletion {
-		fmt.Println("# To use the feature to destroy the deployment created by this helper,")
-		if len(trueFlags) > 0 {
-			fmt.Println("# please set the following flags to 'true' in the tfvars file:")
-			fmt.Printf("# %s\n", strings.Join(trueFlags, ", "))
+	for _, r := range []struct{ field, region string }{{"default_region", g.DefaultRegion}, {"default_region_2", g.DefaultRegion2}} {
+		if !regionRegexp.MatchString(r.region) {
+			invalid(r.field, fmt.Sprintf("'%s' is not a valid region", r.region), "Use a region like 'us-central1'. See the regions with: gcloud compute regions list")
 		}
-		if len(falseFlags) > 0 {
-			fmt.Println("# please set the following flags to 'false' in the tfvars file:")
-			fmt.Printf("# %s\n", strings.Join(falseFlags, ", "))
+	}
+	if g.DefaultRegion != "" && g.DefaultRegion == g.DefaultRegion2 {
+		invalid("default_region_2", fmt.Sprintf("'default_region' and 'default_region_2' must be different regions, both are '%s'", g.DefaultRegion), "Use a second region for the high availability resources")
+	}
+	regions, err := networksRegions(g)
+	if err != nil {
+		issues = append(issues, ValidationIssue{
+			Field:    "default_region",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("Could not read the regions of the networks stage: %s", err.Error()),
+			Hint:     "Check the 'foundation_code_path' input",
+		})
+	} else if len(regions) > 0 && !slices.Contains(regions, g.DefaultRegion) {
+		invalid("default_region", fmt.Sprintf("'%s' must be one of the regions hardcoded in the 'default_region1' and 'default_region2' locals of the networks stage: %s", g.DefaultRegion, strings.Join(regions, ", ")),
+			"The subnets of the networks stage are only created in these regions. Change the 'default_region' input or the locals of the networks stage")
+	}
+
+	if g.ProjectPrefix != nil {
+		prefix := *g.ProjectPrefix
+		if len(prefix) > maxProjectPrefixLength {
+			invalid("project_prefix", fmt.Sprintf("Project prefix '%s' must have at most %d characters so the generated project IDs have at most 30 characters", prefix, maxProjectPrefixLength), "Use a shorter prefix")
+		}
+		if !projectPrefixRegexp.MatchString(prefix) {
+			invalid("project_prefix", fmt.Sprintf("Project prefix '%s' must start with a lowercase letter and have only lowercase letters, digits, and hyphens", prefix), "Use a prefix like 'prj'")
+		}
+	}
+
+	if g.GitProvider() != "" || g.BuildType == BuildTypeJenkins {
+		if g.GitRepos == nil {
+			invalid("git_repos", fmt.Sprintf("Input 'git_repos' is required for build type '%s'", g.BuildType), "Configure the owner and the repositories of the stages")
+		} else {
+			repos := map[string]string{
+				"owner":        g.GitRepos.Owner,
+				"bootstrap":    g.GitRepos.Bootstrap,
+				"organization": g.GitRepos.Organization,
+				"environments": g.GitRepos.Environments,
+				"networks":     g.GitRepos.Networks,
+				"projects":     g.GitRepos.Projects,
+			}
+			for _, k := range []string{"owner", "bootstrap", "organization", "environments", "networks", "projects"} {
+				if repos[k] == "" {
+					invalid("git_repos."+k, fmt.Sprintf("Input 'git_repos.%s' is required for build type '%s'", k, g.BuildType), "Set the value in the 'git_repos' input")
+				}
+			}
+			if g.GitProvider() == BuildTypeGitLab && (g.GitRepos.CICDRunner == nil || *g.GitRepos.CICDRunner == "") {
+				invalid("git_repos.cicd_runner", "Input 'git_repos.cicd_runner' is required for GitLab", "Set the name of the GitLab project of the CI/CD runner image")
+			}
+		}
+	}
+
+	gates, err := g.GetPromotionGates()
+	if err != nil {
+		invalid("promotion_gates", err.Error(), "Use the environments of the 'environments' input as keys and the attributes: "+strings.Join(promotionGateKeys, ", "))
+	}
+	envs := g.GetEnvironments()
+	if last := envs[len(envs)-1]; gates[last] != (PromotionGate{}) {
+		issues = append(issues, ValidationIssue{
+			Field:    "promotion_gates",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("The gate of environment '%s' is not used because it is the last environment in deploy order", last),
+			Hint:     "Gates are checked after their environment is applied and before the next environment",
+		})
+	}
+
+	for _, a := range g.TargetNameServerAddresses {
+		ip := net.ParseIP(a.Ipv4Address)
+		if ip == nil || ip.To4() == nil {
+			invalid("target_name_server_addresses", fmt.Sprintf("'%s' is not a valid IPv4 address", a.Ipv4Address), "Use the IPv4 address of the on-premises DNS server")
+		}
+	}
+	return issues
+}
+
+// networksRegions returns the regions hardcoded in the default_region1 and default_region2 locals of the environments of the networks stage.
+// Locals that read the regions from the bootstrap outputs follow the tfvars inputs and are skipped.
+func networksRegions(g GlobalTFVars) ([]string, error) {
+	stage := SvpcStep
+	if g.EnableHubAndSpoke {
+		stage = HubAndSpokeStep
+	}
+	files, err := filepath.Glob(filepath.Join(g.FoundationCodePath, stage, "envs", "*", "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Terraform files found in '%s'", filepath.Join(g.FoundationCodePath, stage, "envs"))
+	}
+	regions := []string{}
+	for _, f := range files {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
 		}
-		if projectDeletion {
-			fmt.Println("# please set the project_deletion_policy input to 'DELETE' in the tfvars file")
+		for _, m := range networksRegionRegexp.FindAllStringSubmatch(string(content), -1) {
+			value := m[2]
+			if !strings.HasPrefix(value, `"`) {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+			if !slices.Contains(regions, value) {
+				regions = append(regions, value)
+			}
 		}
 	}
+	return regions, nil
 }
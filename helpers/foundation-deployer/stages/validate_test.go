@@ -0,0 +1,261 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	gotest "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationIssues(t *gotest.T) {
+	g, err := ReadGlobalTFVars("../global.tfvars.example")
+	assert.NoError(t, err)
+	g.FoundationCodePath = "../../.."
+
+	issues := ValidateBasicFields(t, g)
+	assert.True(t, HasErrors(issues))
+	fields := map[string]bool{}
+	for _, i := range issues {
+		fields[i.Field] = true
+		assert.Equal(t, SeverityError, i.Severity, i.Field)
+	}
+	for _, f := range []string{
+		"org_id",
+		"billing_account",
+		"domain",
+		"domains_to_allow[0]",
+		"essential_contacts_domains_to_allow[0]",
+		"perimeter_additional_members[0]",
+		"groups.required_groups.group_org_admins",
+		"groups.required_groups.audit_data_users",
+	} {
+		assert.True(t, fields[f], f)
+	}
+	assert.Contains(t, issues, ValidationIssue{
+		Field:    "org_id",
+		Severity: SeverityError,
+		Message:  "Replace value 'REPLACE_ME' for input 'org_id'",
+		Hint:     "Use a value of your environment",
+	})
+
+	g.BusinessUnits = &[]BusinessUnit{
+		{Name: "business_unit_1", AppInfraRepo: "bu1-example-app"},
+		{Name: "", AppInfraRepo: "bu2-example-app"},
+		{Name: "business_unit_1", AppInfraRepo: "bu1-example-app"},
+		{Name: "business_unit_3", AppInfraRepo: ""},
+	}
+	buFields := []string{}
+	for _, i := range ValidateBasicFields(t, g) {
+		if strings.HasPrefix(i.Field, "business_units") {
+			buFields = append(buFields, i.Field)
+		}
+	}
+	assert.Equal(t, []string{"business_units[1].name", "business_units[2].name", "business_units[2].app_infra_repo", "business_units[3].app_infra_repo"}, buFields)
+	g.BusinessUnits = nil
+
+	hasField := func(issues []ValidationIssue, field string) bool {
+		for _, i := range issues {
+			if i.Field == field {
+				return true
+			}
+		}
+		return false
+	}
+	assert.False(t, hasField(issues, "production_environment"), "default environments include the production environment")
+	g.Environments = &[]string{"prd", "stg"}
+	assert.True(t, hasField(ValidateBasicFields(t, g), "production_environment"))
+	prd := "prd"
+	g.ProductionEnvironment = &prd
+	assert.False(t, hasField(ValidateBasicFields(t, g), "production_environment"))
+	g.Environments = nil
+	g.ProductionEnvironment = nil
+
+	destroy := ValidateDestroyFlags(t, g)
+	assert.False(t, HasErrors(destroy), "destroy flags are warnings")
+	assert.Contains(t, destroy, ValidationIssue{
+		Field:    "project_deletion_policy",
+		Severity: SeverityWarning,
+		Message:  "The deployment created by this helper cannot be destroyed by the helper unless 'project_deletion_policy' is 'DELETE'",
+		Hint:     "Set the 'project_deletion_policy' input to 'DELETE' in the tfvars file",
+	})
+	assert.Equal(t, "warning: bucket_force_destroy: The deployment created by this helper cannot be destroyed by the helper unless 'bucket_force_destroy' is 'true'. Set the 'bucket_force_destroy' input to 'true' in the tfvars file", destroy[0].String())
+
+	enabled := true
+	g.BucketForceDestroy = &enabled
+	g.FolderDeletionProtection = nil
+	assert.Len(t, ValidateDestroyFlags(t, g), len(destroy)-2)
+}
+
+func TestValidationSARIF(t *gotest.T) {
+	issues := []ValidationIssue{
+		{Field: "org_id", Severity: SeverityError, Message: "Replace value 'REPLACE_ME' for input 'org_id'", Hint: "Use a value of your environment"},
+		{Field: "bucket_force_destroy", Severity: SeverityWarning, Message: "cannot destroy"},
+		{Field: "org_id", Severity: SeverityError, Message: "other"},
+		{Field: "not_in_file", Severity: SeverityError, Message: "missing"},
+	}
+	b, err := ValidationSARIF(issues, "../global.tfvars.example")
+	assert.NoError(t, err)
+
+	var log sarifLog
+	assert.NoError(t, json.Unmarshal(b, &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	assert.Len(t, log.Runs, 1)
+	assert.Len(t, log.Runs[0].Tool.Driver.Rules, 3, "rules should not be duplicated")
+	results := log.Runs[0].Results
+	assert.Len(t, results, 4)
+	assert.Equal(t, "tfvars/org_id", results[0].RuleID)
+	assert.Equal(t, "error", results[0].Level)
+	assert.Equal(t, "Replace value 'REPLACE_ME' for input 'org_id'. Use a value of your environment", results[0].Message.Text)
+	assert.Equal(t, sarifArtifactLocation{URI: "helpers/foundation-deployer/global.tfvars.example", URIBaseID: "%SRCROOT%"}, results[0].Locations[0].PhysicalLocation.ArtifactLocation, "location should be relative to the repository root")
+	assert.Equal(t, 39, results[0].Locations[0].PhysicalLocation.Region.StartLine)
+	assert.Equal(t, "warning", results[1].Level)
+	assert.Nil(t, results[3].Locations[0].PhysicalLocation.Region)
+
+	_, err = ValidationSARIF(issues, "missing.tfvars")
+	assert.Error(t, err)
+
+	outside := filepath.Join(t.TempDir(), "global.tfvars")
+	assert.NoError(t, os.WriteFile(outside, []byte("org_id = \"REPLACE_ME\"\n"), 0644))
+	b, err = ValidationSARIF(issues[:1], outside)
+	assert.NoError(t, err)
+	var outsideLog sarifLog
+	assert.NoError(t, json.Unmarshal(b, &outsideLog))
+	assert.Equal(t, sarifArtifactLocation{URI: "file://" + filepath.ToSlash(outside)}, outsideLog.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation)
+}
+
+func TestInputLine(t *gotest.T) {
+	content := "# org_id = 1\norg_id = \"1\"\n\ngroups = {\n  required_groups = {}\n}\n"
+	assert.Equal(t, 2, inputLine(content, "org_id"))
+	assert.Equal(t, 4, inputLine(content, "groups.required_groups"))
+	assert.Equal(t, 0, inputLine(content, "domain"))
+	assert.Equal(t, 0, inputLine(content, ""))
+}
+
+func TestValidateSemantics(t *gotest.T) {
+	prefix := "prj"
+	runner := "runner"
+	valid := func() GlobalTFVars {
+		return GlobalTFVars{
+			OrgID:              "123456789012",
+			BillingAccount:     "01AB23-45CD67-89EF01",
+			DefaultRegion:      "us-central1",
+			DefaultRegion2:     "us-west1",
+			ProjectPrefix:      &prefix,
+			FoundationCodePath: "../../..",
+			BuildType:          BuildTypeGitLab,
+			GitRepos: &GitRepos{
+				Owner:        "owner",
+				Bootstrap:    "bootstrap",
+				Organization: "org",
+				Environments: "envs",
+				Networks:     "networks",
+				Projects:     "projects",
+				CICDRunner:   &runner,
+			},
+			TargetNameServerAddresses: []ServerAddress{{Ipv4Address: "192.168.0.1"}},
+		}
+	}
+	assert.Empty(t, ValidateSemantics(valid()))
+
+	fields := func(issues []ValidationIssue) []string {
+		f := []string{}
+		for _, i := range issues {
+			f = append(f, i.Field)
+		}
+		return f
+	}
+
+	g := valid()
+	g.OrgID = "organizations/123"
+	g.BillingAccount = "01ab23-45cd67-89ef01"
+	g.DefaultRegion2 = "us-central1"
+	g.TargetNameServerAddresses = []ServerAddress{{Ipv4Address: "192.168.0.1"}, {Ipv4Address: "192.168.0.300"}, {Ipv4Address: "::1"}}
+	assert.Equal(t, []string{"org_id", "billing_account", "default_region_2", "target_name_server_addresses", "target_name_server_addresses"}, fields(ValidateSemantics(g)))
+
+	g = valid()
+	g.DefaultRegion = "US"
+	assert.Equal(t, []string{"default_region"}, fields(ValidateSemantics(g)))
+
+	g = valid()
+	long := "prj1"
+	g.ProjectPrefix = &long
+	assert.Equal(t, []string{"project_prefix"}, fields(ValidateSemantics(g)))
+	invalid := "P"
+	g.ProjectPrefix = &invalid
+	assert.Equal(t, []string{"project_prefix"}, fields(ValidateSemantics(g)))
+
+	g = valid()
+	g.GitRepos.Networks = ""
+	g.GitRepos.CICDRunner = nil
+	assert.Equal(t, []string{"git_repos.networks", "git_repos.cicd_runner"}, fields(ValidateSemantics(g)))
+	g.BuildType = BuildTypeGiHub
+	assert.Equal(t, []string{"git_repos.networks"}, fields(ValidateSemantics(g)), "cicd_runner is only required for GitLab")
+	g.GitRepos = nil
+	assert.Equal(t, []string{"git_repos"}, fields(ValidateSemantics(g)))
+	g.BuildType = BuildTypeCBCSR
+	assert.Empty(t, ValidateSemantics(g), "git_repos is not required for Cloud Build")
+
+	g = valid()
+	g.PromotionGates = &GateInputs{"production": {"soak_time": "1h"}, "staging": {"approval": "true"}}
+	assert.Equal(t, []string{"promotion_gates"}, fields(ValidateSemantics(g)), "staging is not an environment")
+	g.PromotionGates = &GateInputs{"production": {"soak_time": "an hour"}}
+	assert.Equal(t, []string{"promotion_gates"}, fields(ValidateSemantics(g)))
+	g.PromotionGates = &GateInputs{"development": {"soak_time": "1h"}}
+	issues := ValidateSemantics(g)
+	assert.Equal(t, []string{"promotion_gates"}, fields(issues))
+	assert.Equal(t, SeverityWarning, issues[0].Severity, "the gate of the last environment is not used")
+
+	g = valid()
+	g.FoundationCodePath = t.TempDir()
+	issues = ValidateSemantics(g)
+	assert.Len(t, issues, 1)
+	assert.Equal(t, SeverityWarning, issues[0].Severity)
+
+	// regions hardcoded in the locals of the networks stage
+	production := filepath.Join(g.FoundationCodePath, SvpcStep, "envs", "production")
+	assert.NoError(t, os.MkdirAll(production, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(production, "remote.tf"), []byte("locals {\n  default_region1 = \"europe-west1\"\n  default_region2 = \"europe-west4\"\n}\n"), 0644))
+	issues = ValidateSemantics(g)
+	assert.Equal(t, []string{"default_region"}, fields(issues))
+	assert.Equal(t, SeverityError, issues[0].Severity)
+	g.DefaultRegion = "europe-west1"
+	assert.Empty(t, ValidateSemantics(g))
+}
+
+func TestNetworksRegions(t *gotest.T) {
+	g := GlobalTFVars{DefaultRegion: "us-central1", DefaultRegion2: "us-west1", FoundationCodePath: "../../.."}
+	regions, err := networksRegions(g)
+	assert.NoError(t, err)
+	assert.Empty(t, regions, "regions read from the bootstrap outputs are not hardcoded")
+
+	g.EnableHubAndSpoke = true
+	regions, err = networksRegions(g)
+	assert.NoError(t, err)
+	assert.Empty(t, regions)
+
+	// regions hardcoded in the locals
+	g.FoundationCodePath = t.TempDir()
+	shared := filepath.Join(g.FoundationCodePath, HubAndSpokeStep, "envs", SharedEnv)
+	assert.NoError(t, os.MkdirAll(shared, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(shared, "remote.tf"), []byte("locals {\n  default_region1 = \"europe-west1\"\n  default_region2 = \"europe-west4\"\n}\n"), 0644))
+	regions, err = networksRegions(g)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"europe-west1", "europe-west4"}, regions)
+}
@@ -0,0 +1,222 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package steps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+const gcsScheme = "gs://"
+
+// GCSStore stores the steps state in a Google Cloud Storage object.
+// Object generation preconditions are used to lock the state with a lock object
+// and to prevent overwriting changes made by other processes.
+type GCSStore struct {
+	Bucket string
+	Object string
+
+	service *storage.Service
+	// mu guards the generations of the objects.
+	mu sync.Mutex
+	// generation is the generation of the state object last read or written, 0 if it does not exist.
+	generation int64
+	// lockGeneration is the generation of the lock object owned by the current process.
+	lockGeneration int64
+}
+
+// NewGCSStore returns a store for the given gs://bucket/path location.
+func NewGCSStore(ctx context.Context, location string, opts ...option.ClientOption) (*GCSStore, error) {
+	bucket, object, found := strings.Cut(strings.TrimPrefix(location, gcsScheme), "/")
+	if !strings.HasPrefix(location, gcsScheme) || !found || bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid steps backend '%s', expected format is gs://bucket/path", location)
+	}
+	opts = append([]option.ClientOption{option.WithScopes(storage.DevstorageReadWriteScope)}, opts...)
+	service, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	return &GCSStore{
+		Bucket:  bucket,
+		Object:  object,
+		service: service,
+	}, nil
+}
+
+func (g *GCSStore) lockObject() string {
+	return g.Object + ".lock"
+}
+
+// read returns the content and the generation of the given object.
+func (g *GCSStore) read(object string) ([]byte, int64, error) {
+	o, err := g.service.Objects.Get(g.Bucket, object).Do()
+	if err != nil {
+		return nil, 0, g.error(object, err)
+	}
+	resp, err := g.service.Objects.Get(g.Bucket, object).Generation(o.Generation).Download()
+	if err != nil {
+		return nil, 0, g.error(object, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, o.Generation, nil
+}
+
+// write creates a new generation of the given object if its current generation is the given one.
+// Generation 0 only creates the object if it does not exist.
+func (g *GCSStore) write(object string, data []byte, generation int64) (int64, error) {
+	o, err := g.service.Objects.Insert(g.Bucket, &storage.Object{Name: object}).
+		Media(bytes.NewReader(data), googleapi.ContentType("application/json")).
+		IfGenerationMatch(generation).
+		Do()
+	if err != nil {
+		return 0, g.error(object, err)
+	}
+	return o.Generation, nil
+}
+
+// error converts not found errors into os.ErrNotExist.
+func (g *GCSStore) error(object string, err error) error {
+	if isGoogleAPIError(err, http.StatusNotFound) {
+		return fmt.Errorf("object 'gs://%s/%s' does not exist: %w", g.Bucket, object, os.ErrNotExist)
+	}
+	return err
+}
+
+// isGoogleAPIError checks if the error is a Google API error with the given status code.
+func isGoogleAPIError(err error, code int) bool {
+	var e *googleapi.Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+func (g *GCSStore) Read() ([]byte, error) {
+	data, generation, err := g.read(g.Object)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.generation = generation
+	return data, nil
+}
+
+func (g *GCSStore) Write(data []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	generation, err := g.write(g.Object, data, g.generation)
+	if isGoogleAPIError(err, http.StatusPreconditionFailed) {
+		return fmt.Errorf("steps state '%s' was modified by another process, reload it and try again: %w", g, err)
+	}
+	if err != nil {
+		return err
+	}
+	g.generation = generation
+	return nil
+}
+
+func (g *GCSStore) Delete() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	err := g.service.Objects.Delete(g.Bucket, g.Object).Do()
+	if err != nil && !isGoogleAPIError(err, http.StatusNotFound) {
+		return err
+	}
+	g.generation = 0
+	return nil
+}
+
+// Lock creates the lock object of the steps state.
+// The lock is taken over if it is stale or if it is already owned by the current process.
+func (g *GCSStore) Lock() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	object := g.lockObject()
+	current := currentLock()
+	content, err := json.MarshalIndent(current, "", "    ")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		generation, err := g.write(object, content, 0)
+		if err == nil {
+			g.lockGeneration = generation
+			return nil
+		}
+		if !isGoogleAPIError(err, http.StatusPreconditionFailed) {
+			return err
+		}
+		data, generation, err := g.read(object)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		l, err := parseLock(data)
+		if err != nil {
+			return fmt.Errorf("steps state '%s' is locked. If no other helper is running, delete the lock object 'gs://%s/%s': invalid lock object: %w",
+				g, g.Bucket, object, err)
+		}
+		if l.ownedBy(current) {
+			g.lockGeneration = generation
+			return nil
+		}
+		if !l.isStale(current) {
+			return fmt.Errorf("steps state '%s' is locked by process %d on host '%s' since %s. If no other helper is running, delete the lock object 'gs://%s/%s'",
+				g, l.PID, l.Host, l.Created.Format(time.RFC3339), g.Bucket, object)
+		}
+		fmt.Printf("# removing stale lock object 'gs://%s/%s' of process %d on host '%s'\n", g.Bucket, object, l.PID, l.Host)
+		err = g.service.Objects.Delete(g.Bucket, object).IfGenerationMatch(generation).Do()
+		if err != nil && !isGoogleAPIError(err, http.StatusNotFound) && !isGoogleAPIError(err, http.StatusPreconditionFailed) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to lock steps state '%s'", g)
+}
+
+// Unlock deletes the lock object if it is owned by the current process.
+func (g *GCSStore) Unlock() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.lockGeneration == 0 {
+		return nil
+	}
+	err := g.service.Objects.Delete(g.Bucket, g.lockObject()).IfGenerationMatch(g.lockGeneration).Do()
+	if err != nil && !isGoogleAPIError(err, http.StatusNotFound) && !isGoogleAPIError(err, http.StatusPreconditionFailed) {
+		return err
+	}
+	g.lockGeneration = 0
+	return nil
+}
+
+func (g *GCSStore) String() string {
+	return gcsScheme + g.Bucket + "/" + g.Object
+}
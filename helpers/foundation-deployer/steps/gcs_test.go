@@ -0,0 +1,261 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+)
+
+type fakeObject struct {
+	data       []byte
+	generation int64
+}
+
+// fakeGCS implements the subset of the Cloud Storage JSON API used by GCSStore.
+type fakeGCS struct {
+	mu         sync.Mutex
+	objects    map[string]fakeObject
+	generation int64
+}
+
+func newFakeGCS(t *testing.T) (*fakeGCS, *httptest.Server) {
+	f := &fakeGCS{objects: map[string]fakeObject{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeGCS) put(name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.generation++
+	f.objects[name] = fakeObject{data: data, generation: f.generation}
+}
+
+func (f *fakeGCS) get(name string) (fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.objects[name]
+	return o, ok
+}
+
+func fakeGCSError(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":"%s"}}`, code, http.StatusText(code))
+}
+
+// preconditionFailed checks the ifGenerationMatch parameter against the current object.
+func preconditionFailed(r *http.Request, o fakeObject, exists bool) bool {
+	match := r.URL.Query().Get("ifGenerationMatch")
+	if match == "" {
+		return false
+	}
+	generation, _ := strconv.ParseInt(match, 10, 64)
+	if !exists {
+		return generation != 0
+	}
+	return generation != o.generation
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := r.URL.EscapedPath()
+	if r.Method == http.MethodPost && strings.HasPrefix(path, "/upload/storage/v1/b/") {
+		f.upload(w, r)
+		return
+	}
+	_, name, found := strings.Cut(path, "/o/")
+	if !found {
+		fakeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	name, _ = url.PathUnescape(name)
+	o, exists := f.objects[name]
+	if !exists && r.URL.Query().Get("ifGenerationMatch") == "" {
+		fakeGCSError(w, http.StatusNotFound)
+		return
+	}
+	if preconditionFailed(r, o, exists) {
+		fakeGCSError(w, http.StatusPreconditionFailed)
+		return
+	}
+	if !exists {
+		fakeGCSError(w, http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if g := r.URL.Query().Get("generation"); g != "" && g != strconv.FormatInt(o.generation, 10) {
+			fakeGCSError(w, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			_, _ = w.Write(o.data)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":%q,"generation":"%d"}`, name, o.generation)
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeGCSError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// upload handles multipart uploads, the first part has the object metadata and the second the content.
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		fakeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	var metadata struct {
+		Name string `json:"name"`
+	}
+	part, err := reader.NextPart()
+	if err != nil || json.NewDecoder(part).Decode(&metadata) != nil {
+		fakeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	part, err = reader.NextPart()
+	if err != nil {
+		fakeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		fakeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	o, exists := f.objects[metadata.Name]
+	if preconditionFailed(r, o, exists) {
+		fakeGCSError(w, http.StatusPreconditionFailed)
+		return
+	}
+	f.generation++
+	f.objects[metadata.Name] = fakeObject{data: data, generation: f.generation}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"name":%q,"generation":"%d"}`, metadata.Name, f.generation)
+}
+
+func newTestGCSStore(t *testing.T, server *httptest.Server) *GCSStore {
+	store, err := NewGCSStore(context.Background(), "gs://bucket/foundation/steps.json",
+		option.WithEndpoint(server.URL+"/storage/v1/"),
+		option.WithHTTPClient(server.Client()),
+		option.WithoutAuthentication())
+	assert.NoError(t, err)
+	return store
+}
+
+func TestGCSStore(t *testing.T) {
+	fake, server := newFakeGCS(t)
+	store := newTestGCSStore(t, server)
+	assert.Equal(t, "gs://bucket/foundation/steps.json", store.String())
+
+	// new state
+	s, err := LoadStepsFromStore(store)
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://bucket/foundation/steps.json", s.File)
+	_, locked := fake.get("foundation/steps.json.lock")
+	assert.True(t, locked, "the lock object should be created")
+	assert.NoError(t, s.CompleteStep("gcs"))
+	assert.NoError(t, s.FailStep("other", "error"))
+
+	// state saved in the bucket
+	r, err := ReadStepsFromStore(newTestGCSStore(t, server))
+	assert.NoError(t, err)
+	assert.True(t, r.IsStepComplete("gcs"))
+	assert.Equal(t, "error", r.GetStepError("other"))
+
+	// changes made by another process are not overwritten
+	other := newTestGCSStore(t, server)
+	o, err := ReadStepsFromStore(other)
+	assert.NoError(t, err)
+	assert.NoError(t, o.CompleteStep("other"))
+	err = s.CompleteStep("gcs2")
+	assert.ErrorContains(t, err, "was modified by another process")
+
+	assert.NoError(t, s.Unlock())
+	_, locked = fake.get("foundation/steps.json.lock")
+	assert.False(t, locked, "the lock object should be deleted")
+	assert.NoError(t, s.Delete())
+	_, exists := fake.get("foundation/steps.json")
+	assert.False(t, exists, "the state object should be deleted")
+}
+
+func TestGCSStoreLock(t *testing.T) {
+	fake, server := newFakeGCS(t)
+	current := currentLock()
+	lock := func(l Lock) {
+		b, err := json.Marshal(l)
+		assert.NoError(t, err)
+		fake.put("foundation/steps.json.lock", b)
+	}
+
+	// lock of a process running in another host
+	lock(Lock{PID: current.PID, Host: "other-host", Created: current.Created})
+	_, err := LoadStepsFromStore(newTestGCSStore(t, server))
+	assert.ErrorContains(t, err, "is locked by process")
+	assert.ErrorContains(t, err, "other-host")
+	r, err := ReadStepsFromStore(newTestGCSStore(t, server))
+	assert.NoError(t, err, "steps can be read without the lock")
+	assert.NoError(t, r.Unlock())
+	_, locked := fake.get("foundation/steps.json.lock")
+	assert.True(t, locked, "locks of other processes should not be released")
+
+	// lock already owned by the current process
+	lock(current)
+	s, err := LoadStepsFromStore(newTestGCSStore(t, server))
+	assert.NoError(t, err, "the lock should be reentrant for the same process")
+	assert.NoError(t, s.Unlock())
+
+	// lock object that cannot be parsed is held by another process
+	fake.put("foundation/steps.json.lock", []byte("{"))
+	_, err = LoadStepsFromStore(newTestGCSStore(t, server))
+	assert.ErrorContains(t, err, "is locked")
+	assert.ErrorContains(t, err, "invalid lock object")
+	o, _ := fake.get("foundation/steps.json.lock")
+	assert.Equal(t, "{", string(o.data), "lock objects that cannot be parsed should not be taken over")
+}
+
+func TestNewStore(t *testing.T) {
+	s, err := NewStore(context.Background(), ".steps.json")
+	assert.NoError(t, err)
+	assert.Equal(t, NewFileStore(".steps.json"), s)
+
+	for _, location := range []string{"gs://", "gs://bucket", "gs://bucket/", "gs:///path"} {
+		_, err = NewStore(context.Background(), location)
+		assert.ErrorContains(t, err, "expected format is gs://bucket/path", location)
+	}
+}
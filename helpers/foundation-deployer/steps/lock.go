@@ -0,0 +1,190 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package steps
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Lock is the content of the advisory lock file of a steps file.
+type Lock struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+}
+
+// lockFile returns the path of the lock file of the given steps file.
+func lockFile(file string) string {
+	return file + ".lock"
+}
+
+// currentLock returns the lock of the current process.
+func currentLock() Lock {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return Lock{
+		PID:     os.Getpid(),
+		Host:    host,
+		Created: time.Now().UTC(),
+	}
+}
+
+// ownedBy checks if the lock was taken by the same process as the other lock.
+func (l Lock) ownedBy(o Lock) bool {
+	return l.PID == o.PID && l.Host == o.Host
+}
+
+// isStale checks if the process that took the lock is not running anymore.
+// Only locks taken in the current host can be checked.
+func (l Lock) isStale(current Lock) bool {
+	if l.Host != current.Host {
+		return false
+	}
+	p, err := os.FindProcess(l.PID)
+	if err != nil {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err != nil && !errors.Is(err, syscall.EPERM)
+}
+
+// parseLock parses the content of a lock.
+func parseLock(data []byte) (Lock, error) {
+	var l Lock
+	err := json.Unmarshal(data, &l)
+	return l, err
+}
+
+// readLock reads the lock file.
+func readLock(path string) (Lock, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Lock{}, err
+	}
+	l, err := parseLock(b)
+	if err != nil {
+		return Lock{}, fmt.Errorf("invalid lock file '%s': %w", path, err)
+	}
+	return l, nil
+}
+
+// createLock writes the lock to a temporary file and links it to the lock file,
+// so the lock file is never seen partially written. It fails if the lock file already exists.
+func createLock(path string, content []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return err
+	}
+	return os.Link(tmp, path)
+}
+
+// acquireLock creates the lock file of the given steps file.
+// The lock is taken over if it is stale or if it is already owned by the current process.
+// A lock file that cannot be parsed is considered held by another process.
+func acquireLock(file string) error {
+	path := lockFile(file)
+	current := currentLock()
+	content, err := json.MarshalIndent(current, "", "    ")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		err := createLock(path, content)
+		if err == nil || !os.IsExist(err) {
+			return err
+		}
+		l, err := readLock(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("steps file '%s' is locked. If no other helper is running, delete the lock file '%s': %w", file, path, err)
+		}
+		if l.ownedBy(current) {
+			return nil
+		}
+		if !l.isStale(current) {
+			return fmt.Errorf("steps file '%s' is locked by process %d on host '%s' since %s. If no other helper is running, delete the lock file '%s'",
+				file, l.PID, l.Host, l.Created.Format(time.RFC3339), path)
+		}
+		fmt.Printf("# removing stale lock file '%s' of process %d on host '%s'\n", path, l.PID, l.Host)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to lock steps file '%s'", file)
+}
+
+// releaseLock removes the lock file of the given steps file if it is owned by the current process.
+func releaseLock(file string) error {
+	path := lockFile(file)
+	l, err := readLock(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !l.ownedBy(currentLock()) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it to the target file,
+// so the target file is never left partially written.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,96 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package steps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Migration upgrades the steps of a steps file from one schema version to the next.
+type Migration struct {
+	Description string
+	Migrate     func(steps map[string]Step) (map[string]Step, error)
+}
+
+// migrations upgrade the steps files written by previous versions of the helper.
+// The migration at index i upgrades a file from version i to version i+1,
+// so a new migration must be appended when a step is renamed or merged in the stages.
+var migrations = []Migration{
+	{
+		Description: "add the schema version to the steps file",
+		Migrate: func(steps map[string]Step) (map[string]Step, error) {
+			return steps, nil
+		},
+	},
+}
+
+// RenameSteps returns a migration function that renames the given steps and their nested steps.
+// Steps renamed to the same name are merged, the merged step is only completed if all of them were completed.
+func RenameSteps(renames map[string]string) func(map[string]Step) (map[string]Step, error) {
+	return func(steps map[string]Step) (map[string]Step, error) {
+		names := make([]string, 0, len(steps))
+		for name := range steps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		migrated := map[string]Step{}
+		for _, name := range names {
+			step := steps[name]
+			step.Name = renamedStep(name, renames)
+			if existing, ok := migrated[step.Name]; ok && existing.Status != completedStatus {
+				continue
+			}
+			migrated[step.Name] = step
+		}
+		return migrated, nil
+	}
+}
+
+// renamedStep returns the new name of the step, a nested step is renamed with its parent.
+func renamedStep(name string, renames map[string]string) string {
+	if n, ok := renames[name]; ok {
+		return n
+	}
+	if isNested(name) {
+		p := parent(name)
+		return renamedStep(p, renames) + strings.TrimPrefix(name, p)
+	}
+	return name
+}
+
+// currentVersion is the schema version of the steps files written by this helper.
+func currentVersion() int {
+	return len(migrations)
+}
+
+// migrate upgrades the steps to the version after the last of the given migrations.
+func (s *Steps) migrate(migrations []Migration) error {
+	current := len(migrations)
+	if s.Version > current {
+		return fmt.Errorf("steps file '%s' has version %d but this helper only supports up to version %d, use a newer version of the helper", s.File, s.Version, current)
+	}
+	for ; s.Version < current; s.Version++ {
+		m := migrations[s.Version]
+		fmt.Printf("# migrating steps file '%s' from version %d to %d: %s\n", s.File, s.Version, s.Version+1, m.Description)
+		steps, err := m.Migrate(s.Steps)
+		if err != nil {
+			return fmt.Errorf("failed to migrate steps file '%s' from version %d to %d: %w", s.File, s.Version, s.Version+1, err)
+		}
+		s.Steps = steps
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package steps
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateSteps(t *testing.T) {
+	testMigrations := []Migration{
+		migrations[0],
+		{
+			Description: "rename bootstrap steps",
+			Migrate: RenameSteps(map[string]string{
+				"gcp-bootstrap":                     "bootstrap",
+				"gcp-bootstrap.migrate-state":       "bootstrap.state",
+				"gcp-bootstrap.migrate-state-local": "bootstrap.state",
+				"gcp-org.production":                "gcp-org.prod",
+			}),
+		},
+		{
+			Description: "fail",
+			Migrate: func(map[string]Step) (map[string]Step, error) {
+				return nil, fmt.Errorf("migration failed")
+			},
+		},
+	}
+
+	s := Steps{
+		File: "steps.json",
+		Steps: map[string]Step{
+			"gcp-bootstrap":                     {Name: "gcp-bootstrap", Status: completedStatus},
+			"gcp-bootstrap.migrate-state":       {Name: "gcp-bootstrap.migrate-state", Status: completedStatus},
+			"gcp-bootstrap.migrate-state-local": {Name: "gcp-bootstrap.migrate-state-local", Status: failedStatus, Error: "error"},
+			"gcp-bootstrap.build":               {Name: "gcp-bootstrap.build", Status: completedStatus},
+			"gcp-org.production":                {Name: "gcp-org.production", Status: completedStatus},
+			"gcp-org.development":               {Name: "gcp-org.development", Status: completedStatus},
+		},
+	}
+	assert.NoError(t, s.migrate(testMigrations[:2]))
+	assert.Equal(t, 2, s.Version)
+	assert.Equal(t, map[string]Step{
+		"bootstrap":           {Name: "bootstrap", Status: completedStatus},
+		"bootstrap.state":     {Name: "bootstrap.state", Status: failedStatus, Error: "error"},
+		"bootstrap.build":     {Name: "bootstrap.build", Status: completedStatus},
+		"gcp-org.prod":        {Name: "gcp-org.prod", Status: completedStatus},
+		"gcp-org.development": {Name: "gcp-org.development", Status: completedStatus},
+	}, s.Steps, "merged steps should only be completed if all of them were completed")
+
+	// files already in the current version are not changed
+	assert.NoError(t, s.migrate(testMigrations[:2]))
+	assert.Equal(t, 2, s.Version)
+
+	err := s.migrate(testMigrations)
+	assert.ErrorContains(t, err, "failed to migrate steps file 'steps.json' from version 2 to 3: migration failed")
+
+	err = s.migrate(testMigrations[:1])
+	assert.ErrorContains(t, err, "steps file 'steps.json' has version 2 but this helper only supports up to version 1")
+}
+
+func TestStepsVersion(t *testing.T) {
+	// files without version are migrated
+	e, err := ReadSteps("./testdata/existing.json")
+	assert.NoError(t, err)
+	assert.Equal(t, currentVersion(), e.Version)
+	assert.True(t, e.IsStepComplete("test"))
+
+	// new files are created in the current version
+	file := filepath.Join(t.TempDir(), "new.json")
+	s, err := LoadSteps(file)
+	assert.NoError(t, err)
+	assert.NoError(t, s.CompleteStep("unit"))
+	assert.NoError(t, s.Unlock())
+	r, err := ReadSteps(file)
+	assert.NoError(t, err)
+	assert.Equal(t, currentVersion(), r.Version)
+
+	// files newer than the helper are rejected without keeping the lock
+	r.Version = currentVersion() + 1
+	assert.NoError(t, r.SaveSteps())
+	_, err = LoadSteps(file)
+	assert.ErrorContains(t, err, "use a newer version of the helper")
+	assert.NoFileExists(t, lockFile(file))
+}
@@ -15,29 +15,77 @@
 package steps
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
 )
 
 const (
-	completedStatus = "COMPLETED"
-	destroyedStatus = "DESTROYED"
-	failedStatus    = "FAILED"
-	pendingStatus   = "PENDING"
+	completedStatus   = "COMPLETED"
+	destroyedStatus   = "DESTROYED"
+	failedStatus      = "FAILED"
+	interruptedStatus = "INTERRUPTED"
+	pendingStatus     = "PENDING"
 )
 
+// Attempt is the record of a previous execution of a step.
+type Attempt struct {
+	Number    int        `json:"attempt"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	Duration  float64    `json:"duration_seconds,omitempty"`
+	BuildIDs  []string   `json:"build_ids,omitempty"`
+}
+
 type Step struct {
 	Name   string `json:"name"`
 	Status string `json:"status"`
 	Error  string `json:"error"`
+	// Attempt is the number of times the step was executed.
+	Attempt   int        `json:"attempt,omitempty"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	Duration  float64    `json:"duration_seconds,omitempty"`
+	// BuildIDs are the IDs of the builds, runs, or jobs waited for during the execution.
+	BuildIDs []string `json:"build_ids,omitempty"`
+	// History has the previous status of the step, oldest first.
+	History []Attempt `json:"history,omitempty"`
 }
 
+// execution is the timing and the builds of a step execution.
+type execution struct {
+	start    time.Time
+	end      time.Time
+	mu       sync.Mutex
+	buildIDs []string
+	stop     func()
+}
+
+// stepsMu serializes the access to the steps and the saves of the steps file,
+// so steps can be executed in parallel.
+var stepsMu sync.Mutex
+
 type Steps struct {
-	File  string          `json:"file"`
-	Steps map[string]Step `json:"steps"`
+	File string `json:"file"`
+	// Version is the schema version of the steps file, files without version are version 0.
+	Version int             `json:"version"`
+	Steps   map[string]Step `json:"steps"`
+	// PlanOnly executes the steps without recording their status in the file.
+	PlanOnly bool `json:"-"`
+	// ctx is used to detect if the execution was interrupted.
+	ctx context.Context
+	// store persists the steps state.
+	store Store
 }
 
 // String creates a string representation of the step
@@ -48,40 +96,129 @@ func (s Step) String() string {
 	return fmt.Sprintf("%s %s error:%s", s.Name, s.Status, s.Error)
 }
 
+// attempt returns the record of the current status of the step.
+func (s Step) attempt() Attempt {
+	return Attempt{
+		Number:    s.Attempt,
+		Status:    s.Status,
+		Error:     s.Error,
+		StartTime: s.StartTime,
+		EndTime:   s.EndTime,
+		Duration:  s.Duration,
+		BuildIDs:  s.BuildIDs,
+	}
+}
+
+// startExecution starts recording the timing and the builds of a step execution.
+// Builds of other steps executed in parallel, that are not nested in the step, are not recorded.
+func startExecution(step string) *execution {
+	ex := &execution{start: time.Now()}
+	ex.stop = events.Listen(func(e events.Event) {
+		if e.BuildID == "" || (e.Step != "" && e.Step != step && !strings.HasPrefix(e.Step, step+".")) {
+			return
+		}
+		ex.mu.Lock()
+		defer ex.mu.Unlock()
+		for _, id := range ex.buildIDs {
+			if id == e.BuildID {
+				return
+			}
+		}
+		ex.buildIDs = append(ex.buildIDs, e.BuildID)
+	})
+	return ex
+}
+
+// finish stops recording the step execution.
+func (ex *execution) finish() {
+	ex.stop()
+	ex.end = time.Now()
+}
+
+// setStep records the new status of a step keeping its previous status in the history.
+// The attempt number is increased if the status is the result of an execution.
+func (s Steps) setStep(name, status, err string, ex *execution) error {
+	st := Step{
+		Name:   name,
+		Status: status,
+		Error:  err,
+	}
+	stepsMu.Lock()
+	if prev, ok := s.Steps[name]; ok {
+		st.Attempt = prev.Attempt
+		st.History = append([]Attempt{}, prev.History...)
+		if prev.Status != pendingStatus {
+			st.History = append(st.History, prev.attempt())
+		}
+	}
+	if ex != nil {
+		start, end := ex.start.UTC(), ex.end.UTC()
+		st.Attempt++
+		st.StartTime = &start
+		st.EndTime = &end
+		st.Duration = end.Sub(start).Seconds()
+		st.BuildIDs = ex.buildIDs
+	}
+	if len(st.History) == 0 {
+		st.History = nil
+	}
+	s.Steps[name] = st
+	stepsMu.Unlock()
+	return s.SaveSteps()
+}
+
 // DeleteStepsFile deletes the whole steps file
 func DeleteStepsFile(file string) error {
-	_, err := os.Stat(file)
-	if err != nil && !os.IsNotExist(err) {
-		return err
+	return NewFileStore(file).Delete()
+}
+
+// LoadSteps loads a previous execution steps from the given file
+// and takes the advisory lock of the file, so other helper processes cannot use it.
+// The lock is released with Unlock.
+func LoadSteps(file string) (Steps, error) {
+	return LoadStepsFromStore(NewFileStore(file))
+}
+
+// LoadStepsFromStore loads a previous execution steps from the given store
+// and takes the lock of the store, so other helper processes cannot use it.
+// The lock is released with Unlock.
+func LoadStepsFromStore(store Store) (Steps, error) {
+	err := store.Lock()
+	if err != nil {
+		return Steps{}, err
 	}
-	if !os.IsNotExist(err) {
-		return os.Remove(file)
+	s, err := ReadStepsFromStore(store)
+	if err != nil {
+		return s, errors.Join(err, store.Unlock())
 	}
-	return nil
+	return s, nil
 }
 
-// LoadSteps loads a previous execution steps from the given file.
-func LoadSteps(file string) (Steps, error) {
-	var s Steps
-	_, err := os.Stat(file)
-	if err != nil && !os.IsNotExist(err) {
+// ReadSteps reads a previous execution steps from the given file without taking its lock.
+func ReadSteps(file string) (Steps, error) {
+	return ReadStepsFromStore(NewFileStore(file))
+}
+
+// ReadStepsFromStore reads a previous execution steps from the given store without taking its lock.
+func ReadStepsFromStore(store Store) (Steps, error) {
+	s := Steps{File: store.String(), store: store}
+	f, err := store.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return s, err
 	}
-	if os.IsNotExist(err) {
-		fmt.Printf("# creating new steps file '%s'.\n", file)
-		s = Steps{
-			File: file,
-		}
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("# creating new steps file '%s'.\n", store)
+		s.Version = currentVersion()
 	} else {
-		f, err := os.ReadFile(file)
+		err = json.Unmarshal(f, &s)
 		if err != nil {
 			return s, err
 		}
-		err = json.Unmarshal(f, &s)
+		s.File = store.String()
+		err = s.migrate(migrations)
 		if err != nil {
 			return s, err
 		}
-		s.File = file
 	}
 	if s.Steps == nil {
 		s.Steps = map[string]Step{}
@@ -89,22 +226,51 @@ func LoadSteps(file string) (Steps, error) {
 	return s, nil
 }
 
-// SaveSteps saves the current execution state of the steps in the file that was loaded.
+// storage returns the store the steps were loaded from.
+// Steps created without a store are saved in the local file.
+func (s Steps) storage() Store {
+	if s.store == nil {
+		return NewFileStore(s.File)
+	}
+	return s.store
+}
+
+// SaveSteps saves the current execution state of the steps in the store that was loaded.
 func (s Steps) SaveSteps() error {
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
 	f, err := json.MarshalIndent(s, "", "    ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.File, f, 0644)
+	return s.storage().Write(f)
+}
+
+// Unlock releases the lock of the steps store if it is owned by the current process.
+func (s Steps) Unlock() error {
+	return s.storage().Unlock()
+}
+
+// Delete deletes the whole steps state from the store.
+func (s Steps) Delete() error {
+	return s.storage().Delete()
+}
+
+// step returns the given step.
+func (s Steps) step(name string) (Step, bool) {
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
+	st, ok := s.Steps[name]
+	return st, ok
 }
 
 // CompleteStep marks a given step as completed.
 func (s Steps) CompleteStep(name string) error {
-	s.Steps[name] = Step{
-		Name:   name,
-		Status: completedStatus,
-	}
-	err := s.SaveSteps()
+	return s.completeStep(name, nil)
+}
+
+func (s Steps) completeStep(name string, ex *execution) error {
+	err := s.setStep(name, completedStatus, "", ex)
 	if err != nil {
 		return err
 	}
@@ -114,27 +280,65 @@ func (s Steps) CompleteStep(name string) error {
 
 // IsStepComplete checks if the given step is completed.
 func (s Steps) IsStepComplete(name string) bool {
-	v, ok := s.Steps[name]
+	v, ok := s.step(name)
 	if ok {
 		return v.Status == completedStatus
 	}
 	return false
 }
 
+// WithContext returns a copy of the steps that records as interrupted
+// the steps that fail after the given context is done.
+// New steps are not started once the context is done.
+func (s Steps) WithContext(ctx context.Context) Steps {
+	s.ctx = ctx
+	return s
+}
+
+// interrupted checks if the execution was interrupted.
+func (s Steps) interrupted(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	return s.ctx != nil && s.ctx.Err() != nil
+}
+
+// IsStepInterrupted checks if the given step was interrupted.
+func (s Steps) IsStepInterrupted(name string) bool {
+	v, ok := s.step(name)
+	if ok {
+		return v.Status == interruptedStatus
+	}
+	return false
+}
+
+// InterruptStep marks a given step as interrupted and saves the error message.
+func (s Steps) InterruptStep(name string, err string) error {
+	return s.interruptStep(name, err, nil)
+}
+
+func (s Steps) interruptStep(name string, err string, ex *execution) error {
+	e := s.setStep(name, interruptedStatus, err, ex)
+	if e != nil {
+		return e
+	}
+	fmt.Printf("# step '%s' execution interrupted\n", name)
+	return nil
+}
+
 // StepExists checks if the given step exists
 func (s Steps) StepExists(name string) bool {
-	_, ok := s.Steps[name]
+	_, ok := s.step(name)
 	return ok
 }
 
 // FailStep marks a given step as failed and saves the error message.
 func (s Steps) FailStep(name string, err string) error {
-	s.Steps[name] = Step{
-		Name:   name,
-		Status: failedStatus,
-		Error:  err,
-	}
-	e := s.SaveSteps()
+	return s.failStep(name, err, nil)
+}
+
+func (s Steps) failStep(name string, err string, ex *execution) error {
+	e := s.setStep(name, failedStatus, err, ex)
 	if e != nil {
 		return e
 	}
@@ -151,11 +355,7 @@ func parent(name string) string {
 
 // ResetStep resets the execution status of a given step and its parent.
 func (s Steps) ResetStep(name string) error {
-	s.Steps[name] = Step{
-		Name:   name,
-		Status: pendingStatus,
-	}
-	err := s.SaveSteps()
+	err := s.setStep(name, pendingStatus, "", nil)
 	if err != nil {
 		return err
 	}
@@ -168,7 +368,7 @@ func (s Steps) ResetStep(name string) error {
 
 // GetStepError gets the error message save in an step.
 func (s Steps) GetStepError(name string) string {
-	v, ok := s.Steps[name]
+	v, ok := s.step(name)
 	if ok {
 		return v.Error
 	}
@@ -185,28 +385,50 @@ func (s Steps) ListSteps() []string {
 	return l
 }
 
-// RunStep executes a step and marks it as completed or failed.
+// RunStep executes a step and marks it as completed, failed, or interrupted.
 // Completed steps are not executed again.
+// In plan only mode all steps are executed and their status is not saved.
 func (s Steps) RunStep(step string, f func() error) error {
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return fmt.Errorf("step '%s' not started: %w", step, s.ctx.Err())
+	}
+	if s.PlanOnly {
+		fmt.Printf("# starting step '%s' execution in plan only mode\n", step)
+		start := time.Now()
+		events.Emit(events.Event{Type: events.StepStarted, Step: step, Mode: "plan_only"})
+		err := f()
+		if err != nil {
+			events.Emit(events.Event{Type: events.StepFailed, Step: step, Mode: "plan_only", Duration: events.Since(start), Error: err.Error()})
+			return err
+		}
+		events.Emit(events.Event{Type: events.StepCompleted, Step: step, Mode: "plan_only", Duration: events.Since(start)})
+		return nil
+	}
 	if s.IsStepComplete(step) {
 		fmt.Printf("# skipping step '%s' execution\n", step)
+		events.Emit(events.Event{Type: events.StepSkipped, Step: step, Status: completedStatus})
 		return nil
 	}
 	fmt.Printf("# starting step '%s' execution\n", step)
+	prev, _ := s.step(step)
+	events.Emit(events.Event{Type: events.StepStarted, Step: step, Attempt: prev.Attempt + 1})
+	ex := startExecution(step)
 	err := f()
+	ex.finish()
+	if err != nil {
+		return s.stepError(step, err, ex)
+	}
+	err = s.completeStep(step, ex)
 	if err != nil {
-		e := s.FailStep(step, err.Error())
-		if e != nil {
-			return fmt.Errorf("error on FailStep %v, original error %w", e, err)
-		}
 		return err
 	}
-	return s.CompleteStep(step)
+	s.emitStep(events.StepCompleted, step, "")
+	return nil
 }
 
 // IsStepDestroyed checks is the step was destroyed
 func (s Steps) IsStepDestroyed(name string) bool {
-	v, ok := s.Steps[name]
+	v, ok := s.step(name)
 	if ok {
 		return v.Status == destroyedStatus
 	}
@@ -215,11 +437,11 @@ func (s Steps) IsStepDestroyed(name string) bool {
 
 // DestroyStep destroys the given step
 func (s Steps) DestroyStep(name string) error {
-	s.Steps[name] = Step{
-		Name:   name,
-		Status: destroyedStatus,
-	}
-	err := s.SaveSteps()
+	return s.destroyStep(name, nil)
+}
+
+func (s Steps) destroyStep(name string, ex *execution) error {
+	err := s.setStep(name, destroyedStatus, "", ex)
 	if err != nil {
 		return err
 	}
@@ -229,18 +451,59 @@ func (s Steps) DestroyStep(name string) error {
 
 // RunDestroyStep destroys a step and marks it as destroyed or failed.
 func (s Steps) RunDestroyStep(step string, f func() error) error {
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return fmt.Errorf("step '%s' not started: %w", step, s.ctx.Err())
+	}
+	prev, _ := s.step(step)
 	if s.IsStepDestroyed(step) || !s.StepExists(step) {
 		fmt.Printf("# skipping step '%s' destruction\n", step)
+		events.Emit(events.Event{Type: events.StepSkipped, Step: step, Mode: "destroy", Status: prev.Status})
 		return nil
 	}
 	fmt.Printf("# starting step '%s' destruction\n", step)
+	events.Emit(events.Event{Type: events.StepStarted, Step: step, Mode: "destroy", Attempt: prev.Attempt + 1})
+	ex := startExecution(step)
 	err := f()
+	ex.finish()
+	if err != nil {
+		return s.stepError(step, err, ex)
+	}
+	err = s.destroyStep(step, ex)
 	if err != nil {
-		e := s.FailStep(step, err.Error())
+		return err
+	}
+	s.emitStep(events.StepDestroyed, step, "destroy")
+	return nil
+}
+
+// stepError records the step as failed, or as interrupted if the execution was interrupted.
+func (s Steps) stepError(step string, err error, ex *execution) error {
+	if s.interrupted(err) {
+		e := s.interruptStep(step, err.Error(), ex)
 		if e != nil {
-			return fmt.Errorf("error on FailStep %v, original error %w", e, err)
+			return fmt.Errorf("error on InterruptStep %v, original error %w", e, err)
 		}
+		s.emitStep(events.StepInterrupted, step, "")
 		return err
 	}
-	return s.DestroyStep(step)
+	e := s.failStep(step, err.Error(), ex)
+	if e != nil {
+		return fmt.Errorf("error on FailStep %v, original error %w", e, err)
+	}
+	s.emitStep(events.StepFailed, step, "")
+	return err
+}
+
+// emitStep emits an event with the recorded status of the step.
+func (s Steps) emitStep(eventType, step, mode string) {
+	st, _ := s.step(step)
+	events.Emit(events.Event{
+		Type:     eventType,
+		Mode:     mode,
+		Step:     step,
+		Status:   st.Status,
+		Attempt:  st.Attempt,
+		Duration: st.Duration,
+		Error:    st.Error,
+	})
 }
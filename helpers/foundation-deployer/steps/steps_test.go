@@ -15,17 +15,28 @@
 package steps
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
 )
 
 func TestProcessSteps(t *testing.T) {
 	// Loading an existing file
 	e, err := LoadSteps("./testdata/existing.json")
 	assert.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, e.Unlock()) })
 	assert.True(t, e.IsStepComplete("test"), "check if 'test' is 'COMPLETED' should be true")
 	assert.False(t, e.IsStepComplete("unit"), "check if 'unit' is 'COMPLETED' should be false")
 
@@ -132,3 +143,356 @@ func TestProcessSteps(t *testing.T) {
 	}
 	assert.ElementsMatch(t, expectedSteps, s.ListSteps())
 }
+
+func TestPlanOnlySteps(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plan.json")
+	s, err := LoadSteps(file)
+	assert.NoError(t, err)
+	err = s.CompleteStep("done")
+	assert.NoError(t, err)
+
+	s.PlanOnly = true
+	executed := []string{}
+	for _, step := range []string{"done", "new"} {
+		err = s.RunStep(step, func() error {
+			executed = append(executed, step)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"done", "new"}, executed, "all steps should be executed in plan only mode")
+	assert.False(t, s.StepExists("new"), "step 'new' should not be saved in plan only mode")
+
+	err = s.RunStep("bad", func() error {
+		return fmt.Errorf("%s", "plan failed")
+	})
+	assert.Error(t, err)
+	assert.False(t, s.StepExists("bad"), "step 'bad' should not be saved in plan only mode")
+
+	l, err := LoadSteps(file)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"done COMPLETED"}, l.ListSteps())
+}
+
+func TestInterruptedSteps(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "interrupted.json")
+	l, err := LoadSteps(file)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := l.WithContext(ctx)
+
+	err = s.RunStep("parent", func() error {
+		err := s.RunStep("parent.done", func() error { return nil })
+		if err != nil {
+			return err
+		}
+		return s.RunStep("parent.running", func() error {
+			cancel()
+			return fmt.Errorf("%s", "terraform apply failed")
+		})
+	})
+	assert.Error(t, err)
+	assert.True(t, s.IsStepComplete("parent.done"))
+	assert.True(t, s.IsStepInterrupted("parent.running"), "steps failing after the interruption should not be marked as failed")
+	assert.True(t, s.IsStepInterrupted("parent"))
+
+	executed := false
+	err = s.RunStep("next", func() error {
+		executed = true
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, executed, "steps should not start after the interruption")
+	assert.False(t, s.StepExists("next"))
+
+	// a cancelled wait is recorded as interrupted even without a context
+	err = l.RunStep("wait", func() error {
+		return fmt.Errorf("waiting for build: %w", context.Canceled)
+	})
+	assert.Error(t, err)
+	assert.True(t, l.IsStepInterrupted("wait"))
+
+	r, err := LoadSteps(file)
+	assert.NoError(t, err)
+	assert.False(t, r.IsStepComplete("parent"), "interrupted steps should be executed again")
+	assert.ElementsMatch(t, []string{
+		"parent INTERRUPTED error:terraform apply failed",
+		"parent.done COMPLETED",
+		"parent.running INTERRUPTED error:terraform apply failed",
+		"wait INTERRUPTED error:waiting for build: context canceled",
+	}, r.ListSteps())
+}
+
+func TestStepEvents(t *testing.T) {
+	var buf bytes.Buffer
+	events.SetOutput(&buf)
+	t.Cleanup(func() { events.SetOutput(nil) })
+
+	s, err := LoadSteps(filepath.Join(t.TempDir(), "events.json"))
+	assert.NoError(t, err)
+	for _, step := range []string{"ok", "ok"} {
+		err = s.RunStep(step, func() error { return nil })
+		assert.NoError(t, err)
+	}
+	err = s.RunStep("bad", func() error { return fmt.Errorf("%s", "apply failed") })
+	assert.Error(t, err)
+
+	types := []string{}
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		assert.Contains(t, l, `"step":"`)
+		types = append(types, strings.Split(strings.Split(l, `"type":"`)[1], `"`)[0])
+	}
+	assert.Equal(t, []string{
+		events.StepStarted, events.StepCompleted,
+		events.StepSkipped,
+		events.StepStarted, events.StepFailed,
+	}, types)
+	assert.Contains(t, buf.String(), `"error":"apply failed"`)
+}
+
+func TestStepHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.json")
+	s, err := LoadSteps(file)
+	assert.NoError(t, err)
+
+	attempts := 0
+	run := func() error {
+		attempts++
+		events.Build(context.Background(), events.BuildStarted, fmt.Sprintf("build-%d", attempts), "", "", time.Time{})
+		events.Build(context.Background(), events.BuildFinished, fmt.Sprintf("build-%d", attempts), "", "SUCCESS", time.Time{})
+		if attempts == 1 {
+			return fmt.Errorf("%s", "flaky")
+		}
+		return nil
+	}
+	assert.Error(t, s.RunStep("flaky", run))
+	assert.NoError(t, s.RunStep("flaky", run))
+	assert.NoError(t, s.RunStep("flaky", run), "completed steps should not be executed again")
+
+	l, err := LoadSteps(file)
+	assert.NoError(t, err)
+	st := l.Steps["flaky"]
+	assert.Equal(t, completedStatus, st.Status)
+	assert.Equal(t, 2, st.Attempt)
+	assert.Equal(t, []string{"build-2"}, st.BuildIDs)
+	assert.NotNil(t, st.StartTime)
+	assert.NotNil(t, st.EndTime)
+	assert.False(t, st.EndTime.Before(*st.StartTime))
+	assert.Len(t, st.History, 1)
+	assert.Equal(t, 1, st.History[0].Number)
+	assert.Equal(t, failedStatus, st.History[0].Status)
+	assert.Equal(t, "flaky", st.History[0].Error)
+	assert.Equal(t, []string{"build-1"}, st.History[0].BuildIDs)
+
+	// reset keeps the history and the attempt count
+	assert.NoError(t, l.ResetStep("flaky"))
+	assert.NoError(t, l.RunStep("flaky", run))
+	st = l.Steps["flaky"]
+	assert.Equal(t, 3, st.Attempt)
+	assert.Equal(t, []string{failedStatus, completedStatus}, []string{st.History[0].Status, st.History[1].Status})
+
+	assert.Equal(t, []string{
+		"flaky ✔ COMPLETED attempt:3 duration:0s builds:build-3",
+		"    attempt 1 ✘ FAILED duration:0s builds:build-1 error:flaky",
+		"    attempt 2 ✔ COMPLETED duration:0s builds:build-2",
+	}, l.ListStepsTree(false))
+}
+
+func TestParallelSteps(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "parallel.json")
+	s, err := LoadSteps(file)
+	assert.NoError(t, err)
+
+	envs := []string{"production", "nonproduction", "development"}
+	var started, wg sync.WaitGroup
+	started.Add(len(envs))
+	wg.Add(len(envs))
+	err = s.RunStep("gcp-org", func() error {
+		for _, env := range envs {
+			go func() {
+				defer wg.Done()
+				step := "gcp-org." + env
+				err := s.RunStep(step, func() error {
+					started.Done()
+					started.Wait()
+					events.Build(events.WithStep(context.Background(), step), events.BuildFinished, "build-"+env, "", "SUCCESS", time.Time{})
+					return nil
+				})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		return nil
+	})
+	assert.NoError(t, err)
+
+	l, err := LoadSteps(file)
+	assert.NoError(t, err)
+	for _, env := range envs {
+		st := l.Steps["gcp-org."+env]
+		assert.Equal(t, completedStatus, st.Status)
+		assert.Equal(t, []string{"build-" + env}, st.BuildIDs, "builds of other steps running in parallel should not be recorded")
+	}
+	assert.ElementsMatch(t, []string{"build-production", "build-nonproduction", "build-development"}, l.Steps["gcp-org"].BuildIDs, "the parent step should record the builds of its nested steps")
+}
+
+func writeLock(t *testing.T, file string, l Lock) {
+	b, err := json.Marshal(l)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(lockFile(file), b, 0644))
+}
+
+func TestStepsLock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "locked.json")
+	s, err := LoadSteps(file)
+	assert.NoError(t, err)
+	assert.FileExists(t, lockFile(file))
+	_, err = LoadSteps(file)
+	assert.NoError(t, err, "the lock should be reentrant for the same process")
+	assert.NoError(t, s.Unlock())
+	assert.NoFileExists(t, lockFile(file))
+
+	// lock of a process running in another host
+	current := currentLock()
+	writeLock(t, file, Lock{PID: current.PID, Host: "other-host", Created: current.Created})
+	_, err = LoadSteps(file)
+	assert.ErrorContains(t, err, "is locked by process")
+	assert.ErrorContains(t, err, "other-host")
+	r, err := ReadSteps(file)
+	assert.NoError(t, err, "steps can be read without the lock")
+	assert.NoError(t, r.Unlock())
+	assert.FileExists(t, lockFile(file), "locks of other processes should not be released")
+
+	// stale lock of a process that is not running anymore
+	cmd := exec.Command("go", "version")
+	assert.NoError(t, cmd.Run())
+	writeLock(t, file, Lock{PID: cmd.Process.Pid, Host: current.Host, Created: current.Created})
+	s, err = LoadSteps(file)
+	assert.NoError(t, err, "stale locks should be taken over")
+	l, err := readLock(lockFile(file))
+	assert.NoError(t, err)
+	assert.Equal(t, current.PID, l.PID)
+	assert.NoError(t, s.Unlock())
+
+	// lock file that cannot be parsed is held by another process
+	assert.NoError(t, os.WriteFile(lockFile(file), []byte("{"), 0644))
+	_, err = LoadSteps(file)
+	assert.ErrorContains(t, err, "is locked")
+	assert.ErrorContains(t, err, "invalid lock file")
+	b, err := os.ReadFile(lockFile(file))
+	assert.NoError(t, err)
+	assert.Equal(t, "{", string(b), "lock files that cannot be parsed should not be taken over")
+	assert.NoError(t, os.Remove(lockFile(file)))
+
+	// the lock file is created with its content, without leaving temporary files
+	s, err = LoadSteps(file)
+	assert.NoError(t, err)
+	l, err = readLock(lockFile(file))
+	assert.NoError(t, err)
+	assert.Equal(t, current.PID, l.PID)
+	tmps, err := filepath.Glob(lockFile(file) + ".*.tmp")
+	assert.NoError(t, err)
+	assert.Empty(t, tmps)
+	assert.NoError(t, s.Unlock())
+}
+
+func TestSaveStepsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "atomic.json")
+	s, err := LoadSteps(file)
+	assert.NoError(t, err)
+	assert.NoError(t, s.CompleteStep("one"))
+	assert.NoError(t, s.CompleteStep("two"))
+
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"atomic.json", "atomic.json.lock"}, names, "temporary files should not be left behind")
+
+	r, err := ReadSteps(file)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"one COMPLETED", "two COMPLETED"}, r.ListSteps())
+}
+
+func TestStepsTree(t *testing.T) {
+	s, err := LoadSteps(filepath.Join(t.TempDir(), "tree.json"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, s.Unlock()) })
+	for _, step := range []string{
+		"gcp-networks",
+		"gcp-networks.envs",
+		"gcp-networks.envs.apply-shared",
+		"gcp-networks.production",
+		"gcp-networks-other",
+		"gcp-projects.bu1.production",
+	} {
+		assert.NoError(t, s.CompleteStep(step))
+	}
+
+	var names func(nodes []*StepNode) []string
+	names = func(nodes []*StepNode) []string {
+		l := []string{}
+		for _, n := range nodes {
+			l = append(l, n.Name)
+			for _, c := range names(n.Children) {
+				l = append(l, "  "+c)
+			}
+		}
+		return l
+	}
+	assert.Equal(t, []string{
+		"gcp-networks",
+		"  gcp-networks.envs",
+		"    gcp-networks.envs.apply-shared",
+		"  gcp-networks.production",
+		"gcp-networks-other",
+		"gcp-projects",
+		"  gcp-projects.bu1",
+		"    gcp-projects.bu1.production",
+	}, names(s.Tree()))
+
+	assert.Equal(t, []string{"gcp-networks.envs.apply-shared", "gcp-networks.envs", "gcp-networks.production"}, s.Descendants("gcp-networks"))
+	assert.Nil(t, s.Descendants("unknown"))
+
+	assert.NoError(t, s.ResetStepRecursive("gcp-networks.envs"))
+	assert.False(t, s.IsStepComplete("gcp-networks.envs.apply-shared"))
+	assert.False(t, s.IsStepComplete("gcp-networks.envs"))
+	assert.False(t, s.IsStepComplete("gcp-networks"), "the parent step should be reset")
+	assert.True(t, s.IsStepComplete("gcp-networks.production"))
+	assert.True(t, s.IsStepComplete("gcp-networks-other"))
+}
+
+func TestListStepsTree(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := Steps{
+		Steps: map[string]Step{
+			"gcp-bootstrap":            {Name: "gcp-bootstrap", Status: completedStatus, Attempt: 1, StartTime: &start, Duration: 62.4},
+			"gcp-projects.bu1.shared":  {Name: "gcp-projects.bu1.shared", Status: completedStatus},
+			"gcp-projects.bu1.nonprod": {Name: "gcp-projects.bu1.nonprod", Status: failedStatus, Attempt: 2, StartTime: &start, Duration: 3, BuildIDs: []string{"build-2"}, Error: "plan failed: " + strings.Repeat("x", 100) + "\nsecond line", History: []Attempt{{Number: 1, Status: interruptedStatus, StartTime: &start, Duration: 1, BuildIDs: []string{"build-1"}, Error: "interrupted"}}},
+			"gcp-projects.bu1":         {Name: "gcp-projects.bu1", Status: completedStatus, History: []Attempt{{Number: 1, Status: failedStatus, Error: "apply failed"}}},
+			"gcp-projects.bu2.shared":  {Name: "gcp-projects.bu2.shared", Status: pendingStatus},
+		},
+	}
+	assert.Equal(t, []string{
+		"gcp-bootstrap ✔ COMPLETED duration:1m2s",
+		"gcp-projects ○ PENDING",
+		"├── bu1 ✔ COMPLETED",
+		"│   │   attempt 1 ✘ FAILED error:apply failed",
+		"│   ├── nonprod ✘ FAILED attempt:2 duration:3s builds:build-2 error:plan failed: " + strings.Repeat("x", 64) + "...",
+		"│   │       attempt 1 ⏸ INTERRUPTED duration:1s builds:build-1 error:interrupted",
+		"│   └── shared ✔ COMPLETED",
+		"└── bu2 ○ PENDING",
+		"    └── shared ○ PENDING",
+	}, s.ListStepsTree(false))
+	assert.Equal(t, "gcp-bootstrap \033[32m✔ COMPLETED\033[0m duration:1m2s", s.ListStepsTree(true)[0])
+
+	b, err := json.Marshal(s.Tree()[0])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"gcp-bootstrap","status":"COMPLETED","error":"","attempt":1,"start_time":"2025-01-02T03:04:05Z","duration_seconds":62.4}`, string(b))
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package steps
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// Store persists the steps state and guards it with a lock,
+// so only one helper process uses the state at a time.
+type Store interface {
+	// Read returns the content of the steps state.
+	// It returns an error wrapping os.ErrNotExist if the state does not exist yet.
+	Read() ([]byte, error)
+	// Write replaces the content of the steps state.
+	Write(data []byte) error
+	// Delete removes the steps state.
+	Delete() error
+	// Lock takes the lock of the steps state for the current process.
+	Lock() error
+	// Unlock releases the lock of the steps state if it is owned by the current process.
+	Unlock() error
+	// String returns the location of the steps state.
+	String() string
+}
+
+// NewStore returns the store for the given location.
+// Locations in the format gs://bucket/path are stored in Google Cloud Storage,
+// any other location is a local file path.
+func NewStore(ctx context.Context, location string) (Store, error) {
+	if strings.HasPrefix(location, gcsScheme) {
+		return NewGCSStore(ctx, location)
+	}
+	return NewFileStore(location), nil
+}
+
+// FileStore stores the steps state in a local file.
+type FileStore struct {
+	Path string
+}
+
+// NewFileStore returns a store for the given local file.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (f *FileStore) Read() ([]byte, error) {
+	return os.ReadFile(f.Path)
+}
+
+func (f *FileStore) Write(data []byte) error {
+	return writeFileAtomic(f.Path, data, 0644)
+}
+
+func (f *FileStore) Delete() error {
+	err := os.Remove(f.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *FileStore) Lock() error {
+	return acquireLock(f.Path)
+}
+
+func (f *FileStore) Unlock() error {
+	return releaseLock(f.Path)
+}
+
+func (f *FileStore) String() string {
+	return f.Path
+}
@@ -0,0 +1,232 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package steps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxErrorLength is the length of the error messages in the tree of steps.
+const maxErrorLength = 80
+
+// statusIcons are the icons and the terminal colors of the status of the steps.
+var statusIcons = map[string]struct{ icon, color string }{
+	completedStatus:   {"✔", "\033[32m"},
+	failedStatus:      {"✘", "\033[31m"},
+	interruptedStatus: {"⏸", "\033[33m"},
+	pendingStatus:     {"○", "\033[36m"},
+	destroyedStatus:   {"⊘", "\033[90m"},
+}
+
+const colorReset = "\033[0m"
+
+// StepNode is a step in the tree of steps.
+// The dot separated parts of the step names are the levels of the tree, for example,
+// stage, business unit, and environment. Levels without a step of their own are group nodes.
+type StepNode struct {
+	Step
+	// Group is true if the node has no step of its own, its status summarizes the status of its children.
+	Group    bool        `json:"group,omitempty"`
+	Children []*StepNode `json:"children,omitempty"`
+}
+
+// statusOrder sorts the status from the least to the most relevant to summarize a group of steps.
+var statusOrder = map[string]int{
+	destroyedStatus:   1,
+	completedStatus:   2,
+	pendingStatus:     3,
+	interruptedStatus: 4,
+	failedStatus:      5,
+}
+
+// Tree returns the root steps with their nested steps, sorted by name.
+func (s Steps) Tree() []*StepNode {
+	names := make([]string, 0, len(s.Steps))
+	for name := range s.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	root := &StepNode{}
+	nodes := map[string]*StepNode{}
+	for _, name := range names {
+		parts := strings.Split(name, ".")
+		p := root
+		for i := range parts {
+			n := strings.Join(parts[:i+1], ".")
+			node, ok := nodes[n]
+			if !ok {
+				node = &StepNode{Step: Step{Name: n}, Group: true}
+				nodes[n] = node
+				p.Children = append(p.Children, node)
+			}
+			p = node
+		}
+		p.Step = s.Steps[name]
+		p.Name = name
+		p.Group = false
+	}
+	for _, n := range root.Children {
+		n.summarize()
+	}
+	return root.Children
+}
+
+// summarize sets the status of group nodes to the most relevant status of their children.
+func (n *StepNode) summarize() {
+	for _, c := range n.Children {
+		c.summarize()
+		if n.Group && statusOrder[c.Status] > statusOrder[n.Status] {
+			n.Status = c.Status
+		}
+	}
+}
+
+// Descendants returns the names of the nested steps of the given step,
+// with the deepest steps first.
+func (s Steps) Descendants(name string) []string {
+	var find func(nodes []*StepNode) *StepNode
+	find = func(nodes []*StepNode) *StepNode {
+		for _, n := range nodes {
+			if n.Name == name {
+				return n
+			}
+			if f := find(n.Children); f != nil {
+				return f
+			}
+		}
+		return nil
+	}
+	node := find(s.Tree())
+	if node == nil {
+		return nil
+	}
+	var descendants []string
+	var walk func(n *StepNode)
+	walk = func(n *StepNode) {
+		for _, c := range n.Children {
+			walk(c)
+			if !c.Group {
+				descendants = append(descendants, c.Name)
+			}
+		}
+	}
+	walk(node)
+	return descendants
+}
+
+// ResetStepRecursive resets the execution status of a given step, its nested steps, and its parent.
+func (s Steps) ResetStepRecursive(name string) error {
+	for _, d := range s.Descendants(name) {
+		err := s.setStep(d, pendingStatus, "", nil)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# resetting step '%s' execution\n", d)
+	}
+	return s.ResetStep(name)
+}
+
+// ListStepsTree lists the steps as a tree with the status icon, duration, builds, and error of each step.
+// The previous attempts of a step are listed below it.
+// If color is true the status is colored with terminal escape codes.
+func (s Steps) ListStepsTree(color bool) []string {
+	l := []string{}
+	// add appends the lines of the node, indent is the indentation of its children
+	add := func(line string, n *StepNode, indent string) {
+		l = append(l, line)
+		attemptIndent := indent + "    "
+		if len(n.Children) > 0 {
+			attemptIndent = indent + "│   "
+		}
+		for _, a := range n.History {
+			l = append(l, attemptIndent+a.line(color))
+		}
+	}
+	var walk func(nodes []*StepNode, indent string)
+	walk = func(nodes []*StepNode, indent string) {
+		for i, n := range nodes {
+			branch, next := "├── ", "│   "
+			if i == len(nodes)-1 {
+				branch, next = "└── ", "    "
+			}
+			add(indent+branch+n.line(color), n, indent+next)
+			walk(n.Children, indent+next)
+		}
+	}
+	for _, n := range s.Tree() {
+		add(n.line(color), n, "")
+		walk(n.Children, "")
+	}
+	return l
+}
+
+// line renders the node with the last part of its name.
+func (n *StepNode) line(color bool) string {
+	name := n.Name[strings.LastIndex(n.Name, ".")+1:]
+	d := fmt.Sprintf("%s %s", name, statusText(n.Status, color))
+	if n.Group {
+		return d
+	}
+	if n.Attempt > 1 {
+		d += fmt.Sprintf(" attempt:%d", n.Attempt)
+	}
+	return d + executionText(n.StartTime, n.Duration, n.BuildIDs, n.Error)
+}
+
+// line renders a previous attempt of a step.
+func (a Attempt) line(color bool) string {
+	return fmt.Sprintf("attempt %d %s", a.Number, statusText(a.Status, color)) + executionText(a.StartTime, a.Duration, a.BuildIDs, a.Error)
+}
+
+// statusText renders the status with its icon.
+func statusText(status string, color bool) string {
+	icon, ok := statusIcons[status]
+	if !ok {
+		icon.icon = "?"
+	}
+	s := icon.icon + " " + status
+	if color && icon.color != "" {
+		s = icon.color + s + colorReset
+	}
+	return s
+}
+
+// executionText renders the duration, builds, and error of an execution of a step.
+func executionText(start *time.Time, duration float64, buildIDs []string, err string) string {
+	d := ""
+	if start != nil {
+		d += fmt.Sprintf(" duration:%s", time.Duration(duration*float64(time.Second)).Round(time.Second))
+	}
+	if len(buildIDs) > 0 {
+		d += fmt.Sprintf(" builds:%s", strings.Join(buildIDs, ","))
+	}
+	if err != "" {
+		d += fmt.Sprintf(" error:%s", truncate(err, maxErrorLength))
+	}
+	return d
+}
+
+// truncate returns the first line of the message, shortened to the given number of characters.
+func truncate(message string, length int) string {
+	message, _, _ = strings.Cut(strings.TrimSpace(message), "\n")
+	r := []rune(message)
+	if len(r) <= length {
+		return message
+	}
+	return string(r[:length-3]) + "..."
+}
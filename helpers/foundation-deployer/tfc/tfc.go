@@ -0,0 +1,484 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tfc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/events"
+	"github.com/terraform-google-modules/terraform-example-foundation/helpers/foundation-deployer/utils"
+)
+
+const (
+	DefaultURL = "https://app.terraform.io"
+
+	StatusPending            = "pending"
+	StatusPlanning           = "planning"
+	StatusPlanned            = "planned"
+	StatusApplying           = "applying"
+	StatusApplied            = "applied"
+	StatusPlannedAndFinished = "planned_and_finished"
+	StatusErrored            = "errored"
+	StatusDiscarded          = "discarded"
+	StatusCanceled           = "canceled"
+	StatusForceCanceled      = "force_canceled"
+	StatusPolicySoftFailed   = "policy_soft_failed"
+
+	contentType = "application/vnd.api+json"
+)
+
+// finalStatus are the statuses of runs that will not change anymore
+var finalStatus = map[string]bool{
+	StatusApplied:            true,
+	StatusPlannedAndFinished: true,
+	StatusErrored:            true,
+	StatusDiscarded:          true,
+	StatusCanceled:           true,
+	StatusForceCanceled:      true,
+	StatusPolicySoftFailed:   true,
+}
+
+// successStatus are the final statuses of successful runs
+var successStatus = map[string]bool{
+	StatusApplied:            true,
+	StatusPlannedAndFinished: true,
+}
+
+type Workspace struct {
+	ID        string
+	Name      string
+	AutoApply bool
+	Branch    string
+}
+
+type Run struct {
+	ID                     string
+	Status                 string
+	IsConfirmable          bool
+	ConfigurationVersionID string
+	PlanID                 string
+	ApplyID                string
+	CommitSha              string
+}
+
+// resource is a JSON:API resource object
+type resource struct {
+	ID            string                     `json:"id"`
+	Type          string                     `json:"type"`
+	Attributes    json.RawMessage            `json:"attributes"`
+	Relationships map[string]json.RawMessage `json:"relationships"`
+}
+
+// relationshipID returns the ID of a to-one relationship of the resource
+func (r resource) relationshipID(name string) string {
+	var rel struct {
+		Data *struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(r.Relationships[name], &rel); err != nil || rel.Data == nil {
+		return ""
+	}
+	return rel.Data.ID
+}
+
+type runAttributes struct {
+	Status  string `json:"status"`
+	Actions struct {
+		IsConfirmable bool `json:"is-confirmable"`
+	} `json:"actions"`
+}
+
+// toRun converts a JSON:API runs resource
+func toRun(r resource) (Run, error) {
+	var attr runAttributes
+	if err := json.Unmarshal(r.Attributes, &attr); err != nil {
+		return Run{}, fmt.Errorf("failed to read run %s: %w", r.ID, err)
+	}
+	return Run{
+		ID:                     r.ID,
+		Status:                 attr.Status,
+		IsConfirmable:          attr.Actions.IsConfirmable,
+		ConfigurationVersionID: r.relationshipID("configuration-version"),
+		PlanID:                 r.relationshipID("plan"),
+		ApplyID:                r.relationshipID("apply"),
+	}, nil
+}
+
+type TFC struct {
+	url         string
+	org         string
+	token       string
+	client      *http.Client
+	sleepTime   time.Duration
+	initialWait time.Duration
+}
+
+// NewTFC creates a new Terraform Cloud wrapper for the runs API of the given organization
+func NewTFC(org, token string) TFC {
+	return TFC{
+		url:         DefaultURL,
+		org:         org,
+		token:       token,
+		client:      &http.Client{Timeout: 60 * time.Second},
+		sleepTime:   20,
+		initialWait: 30,
+	}
+}
+
+// RunURL returns the URL of a run in the Terraform Cloud console
+func (f TFC) RunURL(workspace, runID string) string {
+	return fmt.Sprintf("%s/app/%s/workspaces/%s/runs/%s", f.url, f.org, workspace, runID)
+}
+
+// do executes a request in the Terraform Cloud API and returns the response if the status is successful
+func (f TFC) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, f.url+"/api/v2"+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+f.token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %w", path, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error Status: %d, failed to read response body: %v", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("error Status: %d\n body: %s", resp.StatusCode, string(b))
+	}
+	return resp, nil
+}
+
+// getJSON reads the response of a GET request in the Terraform Cloud API into the provided value
+func (f TFC) getJSON(ctx context.Context, path string, val interface{}) error {
+	resp, err := f.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(val)
+}
+
+// GetWorkspace returns the workspace with the given name
+func (f TFC) GetWorkspace(t testing.TB, ctx context.Context, name string) (Workspace, error) {
+	var data struct {
+		Data resource `json:"data"`
+	}
+	err := f.getJSON(ctx, fmt.Sprintf("/organizations/%s/workspaces/%s", url.PathEscape(f.org), url.PathEscape(name)), &data)
+	if err != nil {
+		return Workspace{}, fmt.Errorf("error getting workspace '%s': %w", name, err)
+	}
+	var attr struct {
+		Name      string `json:"name"`
+		AutoApply bool   `json:"auto-apply"`
+		VCSRepo   *struct {
+			Branch string `json:"branch"`
+		} `json:"vcs-repo"`
+	}
+	if err := json.Unmarshal(data.Data.Attributes, &attr); err != nil {
+		return Workspace{}, fmt.Errorf("failed to read workspace '%s': %w", name, err)
+	}
+	ws := Workspace{
+		ID:        data.Data.ID,
+		Name:      attr.Name,
+		AutoApply: attr.AutoApply,
+	}
+	if attr.VCSRepo != nil {
+		ws.Branch = attr.VCSRepo.Branch
+	}
+	return ws, nil
+}
+
+// GetRunForCommit finds the latest run of a workspace for a specific commit SHA
+func (f TFC) GetRunForCommit(t testing.TB, ctx context.Context, ws Workspace, sha string) (Run, error) {
+	var data struct {
+		Data     []resource `json:"data"`
+		Included []resource `json:"included"`
+	}
+	err := f.getJSON(ctx, fmt.Sprintf("/workspaces/%s/runs?include=configuration_version.ingress_attributes&page%%5Bsize%%5D=20", ws.ID), &data)
+	if err != nil {
+		return Run{}, fmt.Errorf("error listing runs of workspace '%s': %w", ws.Name, err)
+	}
+	ingress := map[string]string{}
+	for _, i := range data.Included {
+		if i.Type != "ingress-attributes" {
+			continue
+		}
+		var attr struct {
+			CommitSha string `json:"commit-sha"`
+		}
+		if err := json.Unmarshal(i.Attributes, &attr); err == nil {
+			ingress[i.ID] = attr.CommitSha
+		}
+	}
+	commits := map[string]string{}
+	for _, i := range data.Included {
+		if i.Type == "configuration-versions" {
+			commits[i.ID] = ingress[i.relationshipID("ingress-attributes")]
+		}
+	}
+	// runs are listed from the newest to the oldest
+	for _, r := range data.Data {
+		run, err := toRun(r)
+		if err != nil {
+			return Run{}, err
+		}
+		run.CommitSha = commits[run.ConfigurationVersionID]
+		if run.CommitSha == sha {
+			return run, nil
+		}
+	}
+	return Run{}, fmt.Errorf("no runs found for workspace '%s' at SHA '%s'", ws.Name, sha)
+}
+
+// GetRun returns the given run
+func (f TFC) GetRun(t testing.TB, ctx context.Context, runID string) (Run, error) {
+	var data struct {
+		Data resource `json:"data"`
+	}
+	err := f.getJSON(ctx, fmt.Sprintf("/runs/%s", runID), &data)
+	if err != nil {
+		return Run{}, fmt.Errorf("error getting run %s: %w", runID, err)
+	}
+	return toRun(data.Data)
+}
+
+// ApplyRun confirms a run that is waiting for confirmation to be applied
+func (f TFC) ApplyRun(t testing.TB, ctx context.Context, runID, comment string) error {
+	resp, err := f.do(ctx, http.MethodPost, fmt.Sprintf("/runs/%s/actions/apply", runID), map[string]string{"comment": comment})
+	if err != nil {
+		return fmt.Errorf("error applying run %s: %w", runID, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// CreateRun queues a new run in the workspace for the given configuration version
+func (f TFC) CreateRun(t testing.TB, ctx context.Context, ws Workspace, configurationVersionID, message string) (Run, error) {
+	body := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "runs",
+			"attributes": map[string]interface{}{
+				"message": message,
+			},
+			"relationships": map[string]interface{}{
+				"workspace": map[string]interface{}{
+					"data": map[string]string{"type": "workspaces", "id": ws.ID},
+				},
+				"configuration-version": map[string]interface{}{
+					"data": map[string]string{"type": "configuration-versions", "id": configurationVersionID},
+				},
+			},
+		},
+	}
+	resp, err := f.do(ctx, http.MethodPost, "/runs", body)
+	if err != nil {
+		return Run{}, fmt.Errorf("error creating run in workspace '%s': %w", ws.Name, err)
+	}
+	defer resp.Body.Close()
+	var data struct {
+		Data resource `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return Run{}, fmt.Errorf("failed to read run created in workspace '%s': %w", ws.Name, err)
+	}
+	return toRun(data.Data)
+}
+
+// getLogs returns the logs of a plan or of an apply.
+// kind is the API resource of the logs, "plans" or "applies".
+func (f TFC) getLogs(ctx context.Context, kind, id string) (string, error) {
+	var data struct {
+		Data struct {
+			Attributes struct {
+				LogReadURL string `json:"log-read-url"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	err := f.getJSON(ctx, fmt.Sprintf("/%s/%s", kind, id), &data)
+	if err != nil {
+		return "", fmt.Errorf("error getting %s %s: %w", kind, id, err)
+	}
+	if data.Data.Attributes.LogReadURL == "" {
+		return "", nil
+	}
+	// the log read URL is a pre-signed URL and does not need the API token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, data.Data.Attributes.LogReadURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s %s logs: %w", kind, id, err)
+	}
+	defer resp.Body.Close()
+	logs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s %s logs: %v", kind, id, err)
+	}
+	return string(logs), nil
+}
+
+// GetRunLogs returns the plan logs of the run followed by the apply logs, if the run was applied
+func (f TFC) GetRunLogs(t testing.TB, ctx context.Context, run Run) (string, error) {
+	logs := []string{}
+	for _, r := range []struct{ kind, id string }{{"plans", run.PlanID}, {"applies", run.ApplyID}} {
+		if r.id == "" {
+			continue
+		}
+		l, err := f.getLogs(ctx, r.kind, r.id)
+		if err != nil {
+			return "", err
+		}
+		logs = append(logs, l)
+	}
+	return strings.Join(logs, "\n"), nil
+}
+
+// GetFinalRunStatus returns the final status of a run.
+// The run is applied when it is waiting for confirmation and the workspace does not auto apply.
+func (f TFC) GetFinalRunStatus(t testing.TB, ctx context.Context, ws Workspace, runID string, maxBuildRetry int) (Run, error) {
+	count := 0
+	confirmed := false
+	utils.Printf(ctx, "waiting for run %s execution.\n", f.RunURL(ws.Name, runID))
+	run, err := f.GetRun(t, ctx, runID)
+	if err != nil {
+		return Run{}, err
+	}
+	for !finalStatus[run.Status] {
+		if run.IsConfirmable && !ws.AutoApply && !confirmed {
+			utils.Printf(ctx, "applying run %s of workspace %s\n", runID, ws.Name)
+			err = f.ApplyRun(t, ctx, runID, "Applied by the foundation deployer helper")
+			if err != nil {
+				return Run{}, err
+			}
+			confirmed = true
+		}
+		utils.Printf(ctx, "run status is %s\n", run.Status)
+		if count >= maxBuildRetry {
+			return Run{}, fmt.Errorf("timeout waiting for run '%s' execution", f.RunURL(ws.Name, runID))
+		}
+		count = count + 1
+		if err := utils.Sleep(ctx, f.sleepTime*time.Second); err != nil {
+			return run, err
+		}
+		run, err = f.GetRun(t, ctx, runID)
+		if err != nil {
+			return Run{}, err
+		}
+	}
+	utils.Printf(ctx, "final run status is %s\n", run.Status)
+	return run, nil
+}
+
+// WaitRunSuccess waits for the run of a commit in a workspace to be applied.
+func (f TFC) WaitRunSuccess(t testing.TB, ctx context.Context, ws Workspace, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+	run, err := f.GetRunForCommit(t, ctx, ws, commitSha)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < maxErrorRetries; i++ {
+		start := time.Now()
+		events.Build(ctx, events.BuildStarted, run.ID, f.RunURL(ws.Name, run.ID), run.Status, time.Time{})
+		run, err = f.GetFinalRunStatus(t, ctx, ws, run.ID, maxBuildRetry)
+		if err != nil {
+			return err
+		}
+		events.Build(ctx, events.BuildFinished, run.ID, f.RunURL(ws.Name, run.ID), run.Status, start)
+		if successStatus[run.Status] {
+			return nil // run succeeded
+		}
+
+		logs, err := f.GetRunLogs(t, ctx, run)
+		if err != nil {
+			return err
+		}
+		if !utils.IsRetryableError(t, logs) {
+			utils.Println(ctx, logs)
+			return fmt.Errorf("%s\nSee:\n%s\nfor details", failureMsg, f.RunURL(ws.Name, run.ID))
+		}
+		utils.Println(ctx, "run failed with retryable error. a new run will be created.")
+
+		// Create a new run
+		run, err = f.CreateRun(t, ctx, ws, run.ConfigurationVersionID, fmt.Sprintf("Retry of run %s", run.ID))
+		if err != nil {
+			return fmt.Errorf("failed to create new run (attempt %d/%d): %w", i+1, maxErrorRetries, err)
+		}
+		utils.Printf(ctx, "created new run %s (attempt %d/%d)\n", f.RunURL(ws.Name, run.ID), i+1, maxErrorRetries)
+		events.Retry(ctx, run.ID, f.RunURL(ws.Name, run.ID), i+1, maxErrorRetries)
+		if i < maxErrorRetries-1 {
+			if err := utils.Sleep(ctx, timeBetweenErrorRetries); err != nil { // Wait before retrying
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("%s run failed after %d retries", failureMsg, maxErrorRetries)
+}
+
+// WaitBuildSuccess waits for the runs of a commit pushed to a branch in the workspaces connected to the branch.
+// Workspaces connected to other branches are ignored.
+func (f TFC) WaitBuildSuccess(t testing.TB, ctx context.Context, workspaces []string, branch, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+	connected := []Workspace{}
+	for _, name := range workspaces {
+		ws, err := f.GetWorkspace(t, ctx, name)
+		if err != nil {
+			return err
+		}
+		if ws.Branch == branch {
+			connected = append(connected, ws)
+		}
+	}
+	if len(connected) == 0 {
+		utils.Printf(ctx, "no Terraform Cloud workspace is connected to branch %s, there are no runs to wait for.\n", branch)
+		return nil
+	}
+
+	// wait for the new runs to be created and appear in the API results
+	// after the code being pushed to the repository
+	if err := utils.Sleep(ctx, f.initialWait*time.Second); err != nil {
+		return err
+	}
+
+	for _, ws := range connected {
+		err := f.WaitRunSuccess(t, ctx, ws, commitSha, fmt.Sprintf("%s (workspace %s)", failureMsg, ws.Name), maxBuildRetry, maxErrorRetries, timeBetweenErrorRetries)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
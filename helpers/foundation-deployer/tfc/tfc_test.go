@@ -0,0 +1,219 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tfc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	gotest "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSha = "a1b2c3d4"
+)
+
+// tfcStub is a minimal Terraform Cloud API with the workspaces "2-development" and "2-production"
+type tfcStub struct {
+	server    *httptest.Server
+	autoApply bool
+	runs      map[string][]string // sequence of statuses returned for each run
+	calls     map[string]int
+	logs      string
+	applied   []string
+	created   int
+	badAuth   bool
+}
+
+func newTFCStub(t *gotest.T, logs string, autoApply bool, runs map[string][]string) *tfcStub {
+	s := &tfcStub{
+		autoApply: autoApply,
+		runs:      runs,
+		calls:     map[string]int{},
+		logs:      logs,
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *tfcStub) runJSON(id string) string {
+	statuses := s.runs[id]
+	status := statuses[min(s.calls[id], len(statuses)-1)]
+	s.calls[id]++
+	return fmt.Sprintf(`{"id":"%s","type":"runs","attributes":{"status":"%s","actions":{"is-confirmable":%t}},"relationships":{"configuration-version":{"data":{"id":"cv-1","type":"configuration-versions"}},"plan":{"data":{"id":"plan-%s","type":"plans"}},"apply":{"data":{"id":"apply-%s","type":"applies"}}}}`,
+		id, status, status == StatusPlanned, id, id)
+}
+
+func (s *tfcStub) handle(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/logs/") {
+		fmt.Fprint(w, s.logs)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer api-token" {
+		s.badAuth = true
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/api/v2/organizations/example-org/workspaces/2-development":
+		fmt.Fprintf(w, `{"data":{"id":"ws-dev","type":"workspaces","attributes":{"name":"2-development","auto-apply":%t,"vcs-repo":{"branch":"development"}}}}`, s.autoApply)
+	case r.URL.Path == "/api/v2/organizations/example-org/workspaces/2-production":
+		fmt.Fprint(w, `{"data":{"id":"ws-prod","type":"workspaces","attributes":{"name":"2-production","auto-apply":false,"vcs-repo":{"branch":"production"}}}}`)
+	case r.URL.Path == "/api/v2/workspaces/ws-dev/runs":
+		fmt.Fprintf(w, `{"data":[%s,{"id":"run-old","type":"runs","attributes":{"status":"applied"},"relationships":{"configuration-version":{"data":{"id":"cv-0","type":"configuration-versions"}}}}],"included":[{"id":"cv-1","type":"configuration-versions","relationships":{"ingress-attributes":{"data":{"id":"ia-1","type":"ingress-attributes"}}}},{"id":"cv-0","type":"configuration-versions","relationships":{"ingress-attributes":{"data":{"id":"ia-0","type":"ingress-attributes"}}}},{"id":"ia-1","type":"ingress-attributes","attributes":{"commit-sha":"%s"}},{"id":"ia-0","type":"ingress-attributes","attributes":{"commit-sha":"other"}}]}`,
+			s.runJSON("run-1"), testSha)
+	case strings.HasSuffix(r.URL.Path, "/actions/apply") && r.Method == http.MethodPost:
+		s.applied = append(s.applied, strings.Split(r.URL.Path, "/")[4])
+		w.WriteHeader(http.StatusAccepted)
+	case r.URL.Path == "/api/v2/runs" && r.Method == http.MethodPost:
+		var body struct {
+			Data struct {
+				Relationships map[string]struct {
+					Data struct {
+						ID string `json:"id"`
+					} `json:"data"`
+				} `json:"relationships"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Data.Relationships["configuration-version"].Data.ID != "cv-1" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		s.created++
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"data":%s}`, s.runJSON("run-2"))
+	case strings.HasPrefix(r.URL.Path, "/api/v2/runs/"):
+		fmt.Fprintf(w, `{"data":%s}`, s.runJSON(strings.TrimPrefix(r.URL.Path, "/api/v2/runs/")))
+	case strings.HasPrefix(r.URL.Path, "/api/v2/plans/"), strings.HasPrefix(r.URL.Path, "/api/v2/applies/"):
+		fmt.Fprintf(w, `{"data":{"attributes":{"log-read-url":"%s/logs/%s"}}}`, s.server.URL, strings.Split(r.URL.Path, "/")[4])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testTFC(url string) TFC {
+	f := NewTFC("example-org", "api-token")
+	f.url = url
+	f.sleepTime = 0
+	f.initialWait = 0
+	return f
+}
+
+func TestRunURL(t *gotest.T) {
+	f := NewTFC("example-org", "api-token")
+	assert.Equal(t, "https://app.terraform.io/app/example-org/workspaces/1-shared/runs/run-1", f.RunURL("1-shared", "run-1"))
+}
+
+func TestGetRunForCommit(t *gotest.T) {
+	stub := newTFCStub(t, "", false, map[string][]string{"run-1": {StatusApplied}})
+	f := testTFC(stub.server.URL)
+
+	ws, err := f.GetWorkspace(t, context.Background(), "2-development")
+	assert.NoError(t, err)
+	assert.Equal(t, Workspace{ID: "ws-dev", Name: "2-development", Branch: "development"}, ws)
+
+	run, err := f.GetRunForCommit(t, context.Background(), ws, testSha)
+	assert.NoError(t, err)
+	assert.Equal(t, "run-1", run.ID)
+	assert.Equal(t, "cv-1", run.ConfigurationVersionID)
+	assert.Equal(t, testSha, run.CommitSha)
+
+	_, err = f.GetRunForCommit(t, context.Background(), ws, "missing")
+	assert.ErrorContains(t, err, "no runs found for workspace '2-development' at SHA 'missing'")
+
+	unauthorized := testTFC(stub.server.URL)
+	unauthorized.token = "wrong"
+	_, err = unauthorized.GetWorkspace(t, context.Background(), "2-development")
+	assert.ErrorContains(t, err, "error Status: 401")
+}
+
+func TestWaitBuildSuccess(t *gotest.T) {
+	tests := []struct {
+		name       string
+		logs       string
+		autoApply  bool
+		workspaces []string
+		branch     string
+		runs       map[string][]string
+		err        []string
+		applied    []string
+		created    int
+	}{
+		{
+			name:       "apply confirmable run",
+			workspaces: []string{"2-development", "2-production"},
+			branch:     "development",
+			runs:       map[string][]string{"run-1": {StatusPlanning, StatusPlanned, StatusApplying, StatusApplied}},
+			applied:    []string{"run-1"},
+		},
+		{
+			name:       "auto apply workspace",
+			autoApply:  true,
+			workspaces: []string{"2-development"},
+			branch:     "development",
+			runs:       map[string][]string{"run-1": {StatusPlanned, StatusApplying, StatusApplied}},
+		},
+		{
+			name:       "branch without workspaces",
+			workspaces: []string{"2-development", "2-production"},
+			branch:     "plan",
+			runs:       map[string][]string{},
+		},
+		{
+			name:       "failure links the workspace run",
+			logs:       "Error: Invalid value for input variable",
+			workspaces: []string{"2-development"},
+			branch:     "development",
+			runs:       map[string][]string{"run-1": {StatusErrored}},
+			err:        []string{"failed_test_for_WaitBuildSuccess (workspace 2-development)", "/app/example-org/workspaces/2-development/runs/run-1"},
+		},
+		{
+			name:       "retry creates a run of the same configuration version",
+			logs:       "a\nError 403. Compute Engine API has not been used in project\nz",
+			workspaces: []string{"2-development"},
+			branch:     "development",
+			runs:       map[string][]string{"run-1": {StatusErrored}, "run-2": {StatusPending, StatusPlanned, StatusApplied}},
+			applied:    []string{"run-2"},
+			created:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *gotest.T) {
+			stub := newTFCStub(t, tt.logs, tt.autoApply, tt.runs)
+			f := testTFC(stub.server.URL)
+
+			err := f.WaitBuildSuccess(t, context.Background(), tt.workspaces, tt.branch, testSha, "failed_test_for_WaitBuildSuccess", 40, 2, time.Millisecond)
+			if len(tt.err) == 0 {
+				assert.NoError(t, err)
+			}
+			for _, e := range tt.err {
+				assert.ErrorContains(t, err, e)
+			}
+			assert.Equal(t, tt.applied, stub.applied)
+			assert.Equal(t, tt.created, stub.created)
+			if len(tt.runs) == 0 {
+				assert.Empty(t, stub.calls, "no runs should be read for branches without workspaces")
+			}
+			assert.False(t, stub.badAuth)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Sleep pauses for the given duration or until the context is done.
+// It returns the context error if the context is done before the duration elapses.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// logPrefixKey is the context key of the prefix of the printed messages.
+type logPrefixKey struct{}
+
+// WithLogPrefix returns a copy of the context that adds the given prefix to the messages printed with Printf and Println,
+// so the messages of environments applied in parallel can be told apart.
+func WithLogPrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, logPrefixKey{}, prefix)
+}
+
+// LogPrefix returns the prefix of the printed messages of the context, empty if there is none.
+func LogPrefix(ctx context.Context) string {
+	prefix, _ := ctx.Value(logPrefixKey{}).(string)
+	return prefix
+}
+
+// Printf prints the formatted message with the prefix of the context.
+func Printf(ctx context.Context, format string, a ...any) {
+	fmt.Print(LogPrefix(ctx) + fmt.Sprintf(format, a...))
+}
+
+// Println prints the operands with the prefix of the context.
+func Println(ctx context.Context, a ...any) {
+	fmt.Print(LogPrefix(ctx) + fmt.Sprintln(a...))
+}
@@ -20,6 +20,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -50,6 +51,12 @@ func CopyFile(src string, dest string) error {
 
 // CopyDirectory copies a directory and the files and directories under it.
 func CopyDirectory(src string, dest string) error {
+	return CopyDirectoryExcluding(src, dest, nil)
+}
+
+// CopyDirectoryExcluding copies a directory and the files and directories under it
+// skipping the files and directories in the exclude list that are directly under src.
+func CopyDirectoryExcluding(src string, dest string, exclude []string) error {
 	err := os.MkdirAll(dest, 0755)
 	if err != nil {
 		return err
@@ -59,7 +66,7 @@ func CopyDirectory(src string, dest string) error {
 		return err
 	}
 	for _, f := range files {
-		if f.Name() == TerraformTempDir || f.Name() == TerraformLockFile {
+		if f.Name() == TerraformTempDir || f.Name() == TerraformLockFile || slices.Contains(exclude, f.Name()) {
 			continue
 		}
 		if f.IsDir() {
@@ -101,6 +108,79 @@ func FindFiles(dir, filename string) ([]string, error) {
 	return found, err
 }
 
+// SetTFCBackendAndRemote replaces the GCS backend and remote state files under the directory
+// with the Terraform Cloud versions, like the scripts/set-tfc-backend-and-remote.sh script.
+// The GCS versions are kept with the suffix ".gcs.example".
+// The conversion can be run again: when no Terraform Cloud versions are left the files are already converted,
+// and files with a GCS version and without a Terraform Cloud version, or equal to the Terraform Cloud version, are skipped.
+func SetTFCBackendAndRemote(dir string) error {
+	for _, name := range []string{"backend.tf", "remote.tf"} {
+		cloudFiles, err := FindFiles(dir, name+".cloud"+DisableFileSuffix)
+		if err != nil {
+			return err
+		}
+		if len(cloudFiles) == 0 {
+			continue
+		}
+		files, err := FindFiles(dir, name)
+		if err != nil {
+			return err
+		}
+		gcsFiles := map[string]string{}
+		for _, file := range files {
+			gcsFile := file + ".gcs" + DisableFileSuffix
+			exists, err := FileExists(gcsFile)
+			if err != nil {
+				return err
+			}
+			if exists {
+				cloudExists, err := FileExists(file + ".cloud" + DisableFileSuffix)
+				if err != nil {
+					return err
+				}
+				if !cloudExists {
+					continue
+				}
+				return fmt.Errorf("file '%s' already exists", gcsFile)
+			}
+			converted, err := sameContent(file, file+".cloud"+DisableFileSuffix)
+			if err != nil {
+				return err
+			}
+			if !converted {
+				gcsFiles[file] = gcsFile
+			}
+		}
+		for file, gcsFile := range gcsFiles {
+			if err := os.Rename(file, gcsFile); err != nil {
+				return fmt.Errorf("error renaming file \"%s\": %w", file, err)
+			}
+		}
+		for _, file := range cloudFiles {
+			if err := os.Rename(file, filepath.Join(filepath.Dir(file), name)); err != nil {
+				return fmt.Errorf("error renaming file \"%s\": %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// sameContent checks if the files exist and have the same content.
+func sameContent(a, b string) (bool, error) {
+	contentA, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	contentB, err := os.ReadFile(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(contentA, contentB), nil
+}
+
 // FileExists check if a give file exists
 func FileExists(filename string) (bool, error) {
 	_, err := os.Stat(filename)
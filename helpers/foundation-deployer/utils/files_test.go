@@ -69,6 +69,24 @@ func TestCopyDirectory(t *testing.T) {
 	assert.Equal(t, r, []byte(fileContent), "file content should be the same")
 }
 
+func TestCopyDirectoryExcluding(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "base")
+	for _, d := range []string{"business_unit_1", "business_unit_2", filepath.Join("modules", "business_unit_2")} {
+		err := os.MkdirAll(filepath.Join(src, d), 0755)
+		assert.NoError(t, err)
+		_, err = writeTempFile(filepath.Join(src, d), "main.tf", "")
+		assert.NoError(t, err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "base")
+	err := CopyDirectoryExcluding(src, dest, []string{"business_unit_2"})
+	assert.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(dest, "business_unit_1", "main.tf"))
+	assert.FileExists(t, filepath.Join(dest, "modules", "business_unit_2", "main.tf"), "only directories directly under src should be excluded")
+	assert.NoDirExists(t, filepath.Join(dest, "business_unit_2"))
+}
+
 func TestReplaceStringInFile(t *testing.T) {
 	f, err := writeTempFile(t.TempDir(), "to_replace.txt", "OLD")
 	assert.NoError(t, err)
@@ -97,6 +115,65 @@ func TestFindFiles(t *testing.T) {
 	assert.Equal(t, filepath.Join(base, "one", "two", "three", "four", filename), files[0])
 }
 
+func TestSetTFCBackendAndRemote(t *testing.T) {
+	base := t.TempDir()
+	shared := filepath.Join(base, "envs", "shared")
+	bootstrap := filepath.Join(base, "0-bootstrap")
+	cache := filepath.Join(shared, TerraformTempDir)
+	for _, dir := range []string{shared, bootstrap, cache} {
+		err := os.MkdirAll(dir, 0755)
+		assert.NoError(t, err)
+	}
+	for dir, files := range map[string][]string{
+		shared:    {"backend.tf", "backend.tf.cloud.example", "remote.tf", "remote.tf.cloud.example"},
+		bootstrap: {"backend.tf.example", "backend.tf.cloud.example"},
+		cache:     {"backend.tf"},
+	} {
+		for _, f := range files {
+			_, err := writeTempFile(dir, f, f)
+			assert.NoError(t, err)
+		}
+	}
+
+	err := SetTFCBackendAndRemote(base)
+	assert.NoError(t, err)
+
+	for file, content := range map[string]string{
+		filepath.Join(shared, "backend.tf"):             "backend.tf.cloud.example",
+		filepath.Join(shared, "backend.tf.gcs.example"): "backend.tf",
+		filepath.Join(shared, "remote.tf"):              "remote.tf.cloud.example",
+		filepath.Join(shared, "remote.tf.gcs.example"):  "remote.tf",
+		filepath.Join(bootstrap, "backend.tf"):          "backend.tf.cloud.example",
+		filepath.Join(bootstrap, "backend.tf.example"):  "backend.tf.example",
+		filepath.Join(cache, "backend.tf"):              "backend.tf",
+	} {
+		b, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(b), "wrong content of file %s", file)
+	}
+	assert.NoFileExists(t, filepath.Join(shared, "backend.tf.cloud.example"))
+
+	// a second run, like after a failed state migration, keeps the converted files
+	err = SetTFCBackendAndRemote(base)
+	assert.NoError(t, err)
+	for file, content := range map[string]string{
+		filepath.Join(shared, "backend.tf"):             "backend.tf.cloud.example",
+		filepath.Join(shared, "backend.tf.gcs.example"): "backend.tf",
+		filepath.Join(bootstrap, "backend.tf"):          "backend.tf.cloud.example",
+	} {
+		b, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(b), "wrong content of file %s after second run", file)
+	}
+
+	// a Terraform Cloud version left next to both GCS versions is ambiguous
+	_, err = writeTempFile(shared, "backend.tf.cloud.example", "backend.tf.cloud.example")
+	assert.NoError(t, err)
+	err = SetTFCBackendAndRemote(base)
+	assert.ErrorContains(t, err, "backend.tf.gcs.example' already exists")
+	assert.FileExists(t, filepath.Join(shared, "backend.tf"), "no files should be renamed on error")
+}
+
 func createRenameTestFiles(t *testing.T, tempDir string, targetBuild string, defaultBuild string) {
 	t.Helper() // Mark this function as a helper function.
 	// Create some test files to rename.
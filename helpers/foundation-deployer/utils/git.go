@@ -132,6 +132,126 @@ func (g GitRepo) CommitFiles(msg string) error {
 	return err
 }
 
+// HasBranch checks if a local branch exists.
+func (g GitRepo) HasBranch(branch string) (bool, error) {
+	s, err := g.conf.RunCmdE("branch", "--list", branch)
+	if err != nil {
+		return false, err
+	}
+	return s != "", nil
+}
+
+// CreateBranch creates a branch starting at the given commit without checking it out.
+func (g GitRepo) CreateBranch(branch, startPoint string) error {
+	_, err := g.conf.RunCmdE("branch", branch, startPoint)
+	return err
+}
+
+// FirstCommitWithMessage gets the commit SHA of the oldest commit of a branch whose message contains the given text.
+// An empty SHA is returned if the branch has no commits or no commit is found.
+func (g GitRepo) FirstCommitWithMessage(branch, text string) (string, error) {
+	_, err := g.conf.RunCmdE("rev-parse", "--verify", "--quiet", branch)
+	if err != nil {
+		return "", nil
+	}
+	s, err := g.conf.RunCmdE("log", "--reverse", "--format=%H", "--fixed-strings", "--grep", text, branch)
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(s, "\n", 2)[0], nil
+}
+
+// MergeBranch merges a branch into the current branch.
+// If the merge has conflicts it is aborted and the conflicting files are reported in the error.
+func (g GitRepo) MergeBranch(branch, msg string) error {
+	_, err := g.conf.RunCmdE("merge", "--no-edit", "-m", msg, branch)
+	if err == nil {
+		return nil
+	}
+	conflicts, cErr := g.conf.RunCmdE("diff", "--name-only", "--diff-filter=U")
+	if cErr != nil || conflicts == "" {
+		return err
+	}
+	_, aErr := g.conf.RunCmdE("merge", "--abort")
+	if aErr != nil {
+		return aErr
+	}
+	return fmt.Errorf("merge of branch %s has conflicts in files: %s", branch, strings.Join(strings.Fields(conflicts), ", "))
+}
+
+// RemoveFiles removes the files tracked in a directory of the repository from the working tree and the index.
+func (g GitRepo) RemoveFiles(dir string) error {
+	_, err := g.conf.RunCmdE("rm", "-r", "-q", "--ignore-unmatch", "--", dir)
+	return err
+}
+
+// RestoreFiles restores the files of a directory of the repository as they were in the given commit.
+// Nothing is restored if the commit does not exist or if the directory did not exist in the commit.
+func (g GitRepo) RestoreFiles(commit, dir string) error {
+	_, err := g.conf.RunCmdE("rev-parse", "--verify", "--quiet", commit)
+	if err != nil {
+		return nil
+	}
+	s, err := g.conf.RunCmdE("ls-tree", "--name-only", commit, "--", dir)
+	if err != nil || s == "" {
+		return err
+	}
+	_, err = g.conf.RunCmdE("checkout", commit, "--", dir)
+	return err
+}
+
+// HasRemoteBranch checks if a branch exists in the 'remote' repository.
+func (g GitRepo) HasRemoteBranch(branch, remote string) (bool, error) {
+	s, err := g.conf.RunCmdE("ls-remote", "--heads", remote, branch)
+	if err != nil {
+		return false, err
+	}
+	return s != "", nil
+}
+
+// Fetch fetches the branches of the 'remote' repository.
+func (g GitRepo) Fetch(remote string) error {
+	_, err := g.conf.RunCmdE("fetch", remote)
+	return err
+}
+
+// IsMerged checks if all the commits of a branch are in another branch.
+func (g GitRepo) IsMerged(branch, into string) (bool, error) {
+	s, err := g.conf.RunCmdE("rev-list", "--count", fmt.Sprintf("%s..%s", into, branch))
+	if err != nil {
+		return false, err
+	}
+	return s == "0", nil
+}
+
+// CheckoutRemoteBranch checkouts a branch resetting it to the branch of the 'remote' repository.
+func (g GitRepo) CheckoutRemoteBranch(branch, remote string) error {
+	_, err := g.conf.RunCmdE("checkout", "-B", branch, fmt.Sprintf("%s/%s", remote, branch))
+	return err
+}
+
+// AddWorktree adds a working tree in the given path with the last commit of the branch checked out.
+// The working tree is detached from the branch so the branch can be used at the same time in other working trees.
+// A previous working tree in the path is replaced.
+func (g GitRepo) AddWorktree(path, branch string) error {
+	err := g.RemoveWorktree(path)
+	if err != nil {
+		return err
+	}
+	_, err = g.conf.RunCmdE("worktree", "add", "--force", "--detach", path, branch)
+	return err
+}
+
+// RemoveWorktree deletes the working tree in the given path.
+func (g GitRepo) RemoveWorktree(path string) error {
+	err := os.RemoveAll(path)
+	if err != nil {
+		return err
+	}
+	_, err = g.conf.RunCmdE("worktree", "prune")
+	return err
+}
+
 // AddRemote adds a remote to the repository
 func (g GitRepo) AddRemote(name, url string) error {
 	_, err := g.conf.RunCmdE("remote", "add", name, url)
@@ -143,12 +263,44 @@ func (g GitRepo) GetCommitSha() (string, error) {
 	return g.conf.RunCmdE("rev-parse", "HEAD")
 }
 
-func BuildGitHubURL(owner, repoName, token string) string {
-	return fmt.Sprintf("https://oauth2:%s@github.com/%s/%s.git", token, owner, repoName)
+const (
+	DefaultGitHubURL = "https://github.com"
+	DefaultGitLabURL = "https://gitlab.com"
+)
+
+// BuildGitHubURL builds the URL of a repository in GitHub authenticated with the token.
+// If baseURL is empty github.com is used. Example: https://github.example.com
+func BuildGitHubURL(baseURL, owner, repoName, token string) string {
+	if baseURL == "" {
+		baseURL = DefaultGitHubURL
+	}
+	return buildTokenURL(baseURL, owner, repoName, token)
+}
+
+// BuildGitLabURL builds the URL of a repository in GitLab authenticated with the token.
+// If baseURL is empty gitlab.com is used. Example: https://gitlab.example.com
+func BuildGitLabURL(baseURL, owner, repoName, token string) string {
+	if baseURL == "" {
+		baseURL = DefaultGitLabURL
+	}
+	return buildTokenURL(baseURL, owner, repoName, token)
 }
 
-func BuildGitLabURL(owner, repoName, token string) string {
-	return fmt.Sprintf("https://oauth2:%s@gitlab.com/%s/%s.git", token, owner, repoName)
+// buildTokenURL builds the URL of a repository with the token as the password of the oauth2 user
+func buildTokenURL(baseURL, owner, repoName, token string) string {
+	scheme, host, found := strings.Cut(strings.TrimSuffix(baseURL, "/"), "://")
+	if !found {
+		scheme, host = "https", scheme
+	}
+	return fmt.Sprintf("%s://oauth2:%s@%s/%s/%s.git", scheme, token, host, owner, repoName)
+}
+
+// BuildGitURL builds the URL of a repository hosted in a generic git server. Example: https://git.example.com/owner/repo.git
+func BuildGitURL(baseURL, owner, repoName string) string {
+	if owner == "" {
+		return fmt.Sprintf("%s/%s.git", strings.TrimSuffix(baseURL, "/"), repoName)
+	}
+	return fmt.Sprintf("%s/%s/%s.git", strings.TrimSuffix(baseURL, "/"), owner, repoName)
 }
 
 // ExtractRepoNameFromGitHubURL parses a GitHub URL and returns the repository name.
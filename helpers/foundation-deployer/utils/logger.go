@@ -38,6 +38,15 @@ func (c CustomLogger) Logf(t grunttest.TestingT, format string, args ...interfac
     }
 }
 
+// PrefixLogger returns a logger that adds the prefix to the messages of the given logger.
+// The discard logger is returned unchanged.
+func PrefixLogger(l *logger.Logger, prefix string) *logger.Logger {
+	if l == logger.Discard || prefix == "" {
+		return l
+	}
+	return logger.New(CustomLogger{baseFmt: "  # " + prefix + "%s"})
+}
+
 func GetLogger(quiet bool) *logger.Logger {
 	if quiet {
 		return logger.Discard